@@ -0,0 +1,207 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"site/internal/db"
+)
+
+func cmdDB(args []string) {
+	if len(args) < 1 {
+		fmt.Fprintln(os.Stderr, "Usage: site db <migrate|status|backup|export|import> [options]")
+		os.Exit(1)
+	}
+
+	switch args[0] {
+	case "migrate":
+		cmdDBMigrate(args[1:])
+	case "status":
+		cmdDBStatus(args[1:])
+	case "backup":
+		cmdDBBackup(args[1:])
+	case "export":
+		cmdDBExport(args[1:])
+	case "import":
+		cmdDBImport(args[1:])
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown db command: %s\n", args[0])
+		os.Exit(1)
+	}
+}
+
+func cmdDBMigrate(args []string) {
+	fs := flag.NewFlagSet("db migrate", flag.ExitOnError)
+	dbPath := fs.String("db", "", "Database file (defaults to $SITE_DB, site.yml or data/sqlite.db)")
+	fs.Parse(args)
+	path := resolveDBPath(*dbPath, loadSiteConfig())
+
+	database, err := db.Open(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to open database: %v\n", err)
+		os.Exit(1)
+	}
+	defer database.Close()
+
+	applied, err := database.Migrate()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Migration failed: %v\n", err)
+		os.Exit(1)
+	}
+
+	if applied == 0 {
+		fmt.Printf("Database is up to date (version %d)\n", db.SchemaVersion())
+		return
+	}
+	fmt.Printf("Applied %d migrations, database is at version %d\n", applied, db.SchemaVersion())
+}
+
+func cmdDBStatus(args []string) {
+	fs := flag.NewFlagSet("db status", flag.ExitOnError)
+	dbPath := fs.String("db", "", "Database file (defaults to $SITE_DB, site.yml or data/sqlite.db)")
+	fs.Parse(args)
+	path := resolveDBPath(*dbPath, loadSiteConfig())
+
+	if _, err := os.Stat(path); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to open database: %v\n", err)
+		os.Exit(1)
+	}
+
+	database, err := db.Open(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to open database: %v\n", err)
+		os.Exit(1)
+	}
+	defer database.Close()
+
+	statuses, err := database.MigrationStatus()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to read migrations: %v\n", err)
+		os.Exit(1)
+	}
+
+	pending, unknown := 0, 0
+	for _, s := range statuses {
+		switch {
+		case s.Unknown:
+			unknown++
+			fmt.Printf("  ?  %3d  %-40s %s (newer binary)\n", s.Version, s.Name, s.AppliedAt.Format("2006-01-02 15:04"))
+		case s.Applied:
+			fmt.Printf("  ✓  %3d  %-40s %s\n", s.Version, s.Name, s.AppliedAt.Format("2006-01-02 15:04"))
+		default:
+			pending++
+			fmt.Printf("     %3d  %-40s pending\n", s.Version, s.Name)
+		}
+	}
+
+	switch {
+	case unknown > 0:
+		fmt.Printf("Database is newer than this binary (supports version %d)\n", db.SchemaVersion())
+		os.Exit(1)
+	case pending > 0:
+		fmt.Printf("%d pending migrations, run 'site db migrate'\n", pending)
+	default:
+		fmt.Printf("Database is up to date (version %d)\n", db.SchemaVersion())
+	}
+}
+
+func cmdDBBackup(args []string) {
+	fs := flag.NewFlagSet("db backup", flag.ExitOnError)
+	dbPath := fs.String("db", "", "Database file (defaults to $SITE_DB, site.yml or data/sqlite.db)")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "Usage: site db backup [-db file] <backup file>")
+		os.Exit(1)
+	}
+
+	database := openDB(*dbPath)
+	defer database.Close()
+
+	if err := database.Backup(fs.Arg(0)); err != nil {
+		fmt.Fprintf(os.Stderr, "Backup failed: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Backed up to %s\n", fs.Arg(0))
+}
+
+func cmdDBExport(args []string) {
+	fs := flag.NewFlagSet("db export", flag.ExitOnError)
+	dbPath := fs.String("db", "", "Database file (defaults to $SITE_DB, site.yml or data/sqlite.db)")
+	output := fs.String("o", "", "Write the dump to a file instead of stdout")
+	fs.Parse(args)
+
+	database := openDB(*dbPath)
+	defer database.Close()
+
+	w := os.Stdout
+	if *output != "" {
+		f, err := os.Create(*output)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Export failed: %v\n", err)
+			os.Exit(1)
+		}
+		defer f.Close()
+		w = f
+	}
+
+	if err := database.Export(w); err != nil {
+		fmt.Fprintf(os.Stderr, "Export failed: %v\n", err)
+		os.Exit(1)
+	}
+	if *output != "" {
+		fmt.Printf("Exported to %s\n", *output)
+	}
+}
+
+func cmdDBImport(args []string) {
+	fs := flag.NewFlagSet("db import", flag.ExitOnError)
+	dbPath := fs.String("db", "", "Database file (defaults to $SITE_DB, site.yml or data/sqlite.db)")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "Usage: site db import [-db file] <dump file>")
+		os.Exit(1)
+	}
+
+	f, err := os.Open(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Import failed: %v\n", err)
+		os.Exit(1)
+	}
+	defer f.Close()
+
+	// Importing into a fresh host creates and migrates the database
+	database, err := db.New(resolveDBPath(*dbPath, loadSiteConfig()))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to open database: %v\n", err)
+		os.Exit(1)
+	}
+	defer database.Close()
+
+	result, err := database.Import(f)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Import failed: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Imported %d users, %d comments, %d reactions, %d feedback votes, %d webmentions, %d followers and %d newsletter subscribers\n",
+		result.Users, result.Comments, result.Reactions, result.Feedback, result.Webmentions, result.Followers, result.Subscribers)
+}
+
+// openDB opens an existing database without migrating it, exiting if it
+// is missing so a mistyped path isn't silently created empty
+func openDB(flagPath string) *db.DB {
+	path := resolveDBPath(flagPath, loadSiteConfig())
+	if _, err := os.Stat(path); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to open database: %v\n", err)
+		os.Exit(1)
+	}
+
+	database, err := db.Open(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to open database: %v\n", err)
+		os.Exit(1)
+	}
+	return database
+}
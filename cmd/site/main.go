@@ -1,16 +1,23 @@
 package main
 
 // site build --dry-run
+// site check
 // site dev -port 3000
 // site serve -port 8080
+// site webmention send
+// site stats -days 7
+// site newsletter send
 // site help
 
 import (
 	"flag"
 	"fmt"
 	"os"
+	"path/filepath"
 
 	"site/internal/build"
+	"site/internal/build/linkcheck"
+	"site/internal/build/output"
 	"site/internal/db"
 	"site/internal/server"
 
@@ -19,9 +26,35 @@ import (
 )
 
 type siteConfig struct {
-	BaseURL    string                `yaml:"base_url"`
-	DevBaseURL string                `yaml:"dev_base_url"`
-	Profile    server.ProfileConfig `yaml:"profile"`
+	Title       string                   `yaml:"title"`
+	BaseURL     string                   `yaml:"base_url"`
+	DevBaseURL  string                   `yaml:"dev_base_url"`
+	Profile     server.ProfileConfig     `yaml:"profile"`
+	Admins      []string                 `yaml:"admins"`
+	Comments    server.CommentsConfig    `yaml:"comments"`
+	RateLimits  server.RateLimitConfig   `yaml:"rate_limits"`
+	TrustProxy  bool                     `yaml:"trust_proxy"`
+	Database    databaseConfig           `yaml:"database"`
+	Auth        server.AuthConfig        `yaml:"auth"`
+	Email       server.EmailConfig       `yaml:"email"`
+	Webmentions server.WebmentionsConfig `yaml:"webmentions"`
+	ActivityPub server.ActivityPubConfig `yaml:"activitypub"`
+	Analytics   server.AnalyticsConfig   `yaml:"analytics"`
+	Newsletter  server.NewsletterConfig  `yaml:"newsletter"`
+}
+
+// activityPub returns the ActivityPub settings, named after the site
+// unless site.yml names the actor
+func (c siteConfig) activityPub() server.ActivityPubConfig {
+	ap := c.ActivityPub
+	if ap.Name == "" {
+		ap.Name = c.Title
+	}
+	return ap
+}
+
+type databaseConfig struct {
+	Path string `yaml:"path"`
 }
 
 func main() {
@@ -35,10 +68,20 @@ func main() {
 	switch os.Args[1] {
 	case "build":
 		cmdBuild(os.Args[2:])
+	case "check":
+		cmdCheck(os.Args[2:])
 	case "dev":
 		cmdDev(os.Args[2:])
 	case "serve":
 		cmdServe(os.Args[2:])
+	case "db":
+		cmdDB(os.Args[2:])
+	case "webmention":
+		cmdWebmention(os.Args[2:])
+	case "stats":
+		cmdStats(os.Args[2:])
+	case "newsletter":
+		cmdNewsletter(os.Args[2:])
 	case "help", "-h", "--help":
 		printUsage()
 	default:
@@ -53,14 +96,16 @@ func cmdBuild(args []string) {
 	contentDir := fs.String("content", "content", "Content directory")
 	outputDir := fs.String("output", "dist", "Output directory")
 	baseURL := fs.String("base-url", "", "Base URL for canonical links (defaults to site.yml)")
+	noCache := fs.Bool("no-cache", false, "Ignore the build cache and rebuild everything")
+	dryRun := fs.Bool("dry-run", false, "Report what would change without writing anything")
+	check := fs.Bool("check", false, "Fail the build if the output contains broken links")
+	dbPath := fs.String("db", "", "Database file (defaults to $SITE_DB, site.yml or data/sqlite.db)")
 	fs.Parse(args)
 
-	database, err := db.New("data/sqlite.db")
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Failed to initialize database: %v\n", err)
-		os.Exit(1)
+	cacheDir := build.DefaultCacheDir
+	if *noCache {
+		cacheDir = ""
 	}
-	defer database.Close()
 
 	cfg := build.Config{
 		ContentDir:  *contentDir,
@@ -68,22 +113,91 @@ func cmdBuild(args []string) {
 		BaseURL:     *baseURL,
 		StaticDir:   "static",
 		TemplateDir: "templates",
-		DB:          database,
+		CacheDir:    cacheDir,
 	}
 
+	if *dryRun {
+		report, err := build.DryRun(cfg)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Dry run failed: %v\n", err)
+			os.Exit(1)
+		}
+		printReport(report, *outputDir)
+		return
+	}
+
+	database, err := db.New(resolveDBPath(*dbPath, loadSiteConfig()))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to initialize database: %v\n", err)
+		os.Exit(1)
+	}
+	defer database.Close()
+	cfg.DB = database
+
 	if err := build.Build(cfg); err != nil {
 		fmt.Fprintf(os.Stderr, "Build failed: %v\n", err)
 		os.Exit(1)
 	}
+
+	if *check && !runLinkCheck(*outputDir, *contentDir, *baseURL) {
+		fmt.Fprintln(os.Stderr, "Build failed: broken links")
+		os.Exit(1)
+	}
 	fmt.Println("Build complete")
 }
 
+func cmdCheck(args []string) {
+	fs := flag.NewFlagSet("check", flag.ExitOnError)
+	outputDir := fs.String("output", "dist", "Output directory")
+	contentDir := fs.String("content", "content", "Content directory, for reporting links at their markdown source")
+	baseURL := fs.String("base-url", "", "Base URL whose absolute links are checked (defaults to site.yml)")
+	fs.Parse(args)
+
+	if !runLinkCheck(*outputDir, *contentDir, *baseURL) {
+		os.Exit(1)
+	}
+}
+
+// runLinkCheck reports broken internal links in outputDir and returns
+// whether there were none
+func runLinkCheck(outputDir, contentDir, baseURL string) bool {
+	if baseURL == "" {
+		baseURL = loadSiteConfig().BaseURL
+	}
+
+	checker := &linkcheck.Checker{
+		OutputDir:  outputDir,
+		ContentDir: contentDir,
+		BaseURL:    baseURL,
+		Ignore:     linkcheck.DefaultIgnore,
+	}
+
+	broken, err := checker.Check()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Link check failed: %v\n", err)
+		return false
+	}
+
+	for _, b := range broken {
+		b.File = filepath.Join(outputDir, b.File)
+		fmt.Fprintln(os.Stderr, b)
+	}
+	if len(broken) > 0 {
+		fmt.Fprintf(os.Stderr, "%d broken links\n", len(broken))
+		return false
+	}
+
+	fmt.Println("No broken links")
+	return true
+}
+
 func cmdDev(args []string) {
 	fs := flag.NewFlagSet("dev", flag.ExitOnError)
 	port := fs.Int("port", 3000, "Port to serve on")
 	contentDir := fs.String("content", "content", "Content directory")
 	outputDir := fs.String("output", "dist", "Output directory")
 	baseURL := fs.String("base-url", "", "Base URL (defaults to site.yml or localhost)")
+	dbPath := fs.String("db", "", "Database file (defaults to $SITE_DB, site.yml or data/sqlite.db)")
 	fs.Parse(args)
 
 	siteCfg := loadSiteConfig()
@@ -97,7 +211,18 @@ func cmdDev(args []string) {
 		TemplateDir: "templates",
 		DevMode:     true,
 		BaseURL:     finalBaseURL,
+		DBPath:      resolveDBPath(*dbPath, siteCfg),
 		Profile:     siteCfg.Profile,
+		Admins:      siteCfg.Admins,
+		Comments:    siteCfg.Comments,
+		RateLimits:  siteCfg.RateLimits,
+		TrustProxy:  siteCfg.TrustProxy,
+		Auth:        siteCfg.Auth,
+		Email:       siteCfg.Email,
+		Webmentions: siteCfg.Webmentions,
+		ActivityPub: siteCfg.activityPub(),
+		Analytics:   siteCfg.Analytics,
+		Newsletter:  siteCfg.Newsletter,
 	}
 
 	if err := server.Run(cfg); err != nil {
@@ -111,6 +236,7 @@ func cmdServe(args []string) {
 	port := fs.Int("port", 8080, "Port to serve on")
 	outputDir := fs.String("output", "dist", "Output directory")
 	baseURL := fs.String("base-url", "", "Base URL (defaults to site.yml base_url)")
+	dbPath := fs.String("db", "", "Database file (defaults to $SITE_DB, site.yml or data/sqlite.db)")
 	fs.Parse(args)
 
 	siteCfg := loadSiteConfig()
@@ -125,12 +251,23 @@ func cmdServe(args []string) {
 	}
 
 	cfg := server.Config{
-		Port:      *port,
-		OutputDir: *outputDir,
-		StaticDir: "static",
-		DevMode:   false,
-		BaseURL:   finalBaseURL,
-		Profile:   siteCfg.Profile,
+		Port:        *port,
+		OutputDir:   *outputDir,
+		StaticDir:   "static",
+		DevMode:     false,
+		BaseURL:     finalBaseURL,
+		DBPath:      resolveDBPath(*dbPath, siteCfg),
+		Profile:     siteCfg.Profile,
+		Admins:      siteCfg.Admins,
+		Comments:    siteCfg.Comments,
+		RateLimits:  siteCfg.RateLimits,
+		TrustProxy:  siteCfg.TrustProxy,
+		Auth:        siteCfg.Auth,
+		Email:       siteCfg.Email,
+		Webmentions: siteCfg.Webmentions,
+		ActivityPub: siteCfg.activityPub(),
+		Analytics:   siteCfg.Analytics,
+		Newsletter:  siteCfg.Newsletter,
 	}
 
 	if err := server.Run(cfg); err != nil {
@@ -139,6 +276,68 @@ func cmdServe(args []string) {
 	}
 }
 
+// printReport prints the files a dry-run build would create, change or delete
+func printReport(report *build.Report, outputDir string) {
+	var created, updated, deleted int
+	var delta int64
+
+	for _, c := range report.Changes {
+		switch c.Op {
+		case output.OpCreate:
+			created++
+			fmt.Printf("  + %-60s %10s\n", c.Path, formatSize(c.Size))
+		case output.OpUpdate:
+			updated++
+			fmt.Printf("  ~ %-60s %10s -> %s\n", c.Path, formatSize(c.OldSize), formatSize(c.Size))
+		case output.OpDelete:
+			deleted++
+			fmt.Printf("  - %-60s %10s\n", c.Path, formatSize(c.OldSize))
+		}
+		delta += c.Size - c.OldSize
+	}
+
+	if len(report.Changes) == 0 {
+		fmt.Printf("No changes to %s\n", outputDir)
+	}
+
+	sign := "+"
+	if delta < 0 {
+		sign = "-"
+		delta = -delta
+	}
+	fmt.Printf("\nDry run: %d created, %d changed, %d deleted, %d unchanged (%s%s)\n",
+		created, updated, deleted, report.Unchanged, sign, formatSize(delta))
+	fmt.Printf("Search index: %d posts would be indexed\n", report.Indexed)
+}
+
+// formatSize renders a byte count for humans
+func formatSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// resolveDBPath picks the database file: flag > SITE_DB > site.yml > default
+func resolveDBPath(flagPath string, siteCfg siteConfig) string {
+	if flagPath != "" {
+		return flagPath
+	}
+	if env := os.Getenv("SITE_DB"); env != "" {
+		return env
+	}
+	if siteCfg.Database.Path != "" {
+		return siteCfg.Database.Path
+	}
+	return db.DefaultPath
+}
+
 func loadSiteConfig() siteConfig {
 	var cfg siteConfig
 	if data, err := os.ReadFile("site.yml"); err == nil {
@@ -162,22 +361,73 @@ func printUsage() {
 
 Commands:
   build     Build static site to output directory
+  check     Check the output directory for broken links
   dev       Development server with hot reload
   serve     Production server with reactions API
+  db        Manage the database (migrate, status, backup, export, import)
+  webmention  Notify sites that published posts link to (send)
+  stats     Show page views and docs feedback
+  newsletter  Email new posts to subscribers (send)
   help      Show this message
 
 Build Options:
   -content   Content directory (default: content)
   -output    Output directory (default: dist)
   -base-url  Base URL for canonical links
+  -no-cache  Ignore the build cache and rebuild everything
+  -dry-run   Report files that would be created, changed or deleted
+  -check     Fail if the output contains broken links
+  -db        Database file
+
+Check Options:
+  -output    Output directory (default: dist)
+  -content   Content directory (default: content)
+  -base-url  Base URL whose absolute links are checked
 
 Dev Options:
   -port      Port to serve on (default: 8080)
   -content   Content directory (default: content)
   -output    Output directory (default: dist)
+  -db        Database file
 
 Serve Options:
   -port      Port to serve on (default: 8080)
   -output    Output directory (default: dist)
-  -base-url  Base URL for production`)
+  -base-url  Base URL for production
+  -db        Database file
+
+Database Commands:
+  db migrate  Apply pending schema migrations
+  db status   List migrations and whether they have been applied
+  db backup <file>  Write a consistent copy of the database
+  db export         Write users, comments and reactions as JSON (-o file)
+  db import <file>  Restore a JSON export
+
+Database Options:
+  -db        Database file
+
+Webmention Send Options:
+  -content   Content directory (default: content)
+  -base-url  Base URL of the published site
+  -post      Only send for one post (collection/slug)
+  -dry-run   List links that would be notified without sending
+  -force     Send again for links already notified
+  -db        Database file
+
+Stats Options:
+  -days      Days to report, today included (default: 30)
+  -limit     Pages and referrers to list (default: 10)
+  -json      Print the stats as JSON
+  -db        Database file
+
+Newsletter Send Options:
+  -content   Content directory (default: content)
+  -base-url  Base URL of the published site
+  -templates Email template directory (default: templates/email)
+  -dir       Write emails to .eml files here instead of sending them
+  -dry-run   List digests that would be sent without sending
+  -db        Database file
+
+The database file defaults to $SITE_DB, then database.path in site.yml,
+then data/sqlite.db.`)
 }
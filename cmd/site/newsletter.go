@@ -0,0 +1,254 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	htmltemplate "html/template"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	"site/internal/build"
+	"site/internal/db"
+	"site/internal/mail"
+	"site/internal/models"
+	"site/internal/server"
+)
+
+// newsletterTimeout bounds sending each digest
+const newsletterTimeout = 30 * time.Second
+
+// digest is what templates/email/digest.html and digest.txt render
+type digest struct {
+	Subject        string
+	Site           string // Site title
+	SiteURL        string
+	Series         string // Name of the subscribed series, "" for the whole site
+	Posts          []digestPost
+	UnsubscribeURL string
+}
+
+type digestPost struct {
+	Title       string
+	Description string
+	Date        time.Time
+	URL         string
+}
+
+func cmdNewsletter(args []string) {
+	if len(args) < 1 {
+		fmt.Fprintln(os.Stderr, "Usage: site newsletter send [options]")
+		os.Exit(1)
+	}
+
+	switch args[0] {
+	case "send":
+		cmdNewsletterSend(args[1:])
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown newsletter command: %s\n", args[0])
+		os.Exit(1)
+	}
+}
+
+// cmdNewsletterSend emails each confirmed subscriber a digest of the posts
+// published since they subscribed that they haven't received yet, so it
+// can run after every deploy or on a schedule
+func cmdNewsletterSend(args []string) {
+	fs := flag.NewFlagSet("newsletter send", flag.ExitOnError)
+	contentDir := fs.String("content", "content", "Content directory")
+	baseURL := fs.String("base-url", "", "Base URL of the published site (defaults to site.yml)")
+	templateDir := fs.String("templates", filepath.Join("templates", "email"), "Email template directory")
+	dir := fs.String("dir", "", "Write the emails to .eml files here instead of sending them")
+	dryRun := fs.Bool("dry-run", false, "List the digests that would be sent without sending")
+	dbPath := fs.String("db", "", "Database file (defaults to $SITE_DB, site.yml or data/sqlite.db)")
+	fs.Parse(args)
+
+	siteCfg := loadSiteConfig()
+	if *baseURL == "" {
+		*baseURL = siteCfg.BaseURL
+	}
+	base, err := url.Parse(strings.TrimSuffix(*baseURL, "/"))
+	if err != nil || base.Host == "" {
+		fmt.Fprintln(os.Stderr, "A base URL is required: set base_url in site.yml or pass -base-url")
+		os.Exit(1)
+	}
+
+	emailCfg := siteCfg.Email
+	if *dir != "" {
+		emailCfg = server.EmailConfig{From: emailCfg.From, Dir: *dir}
+	}
+	mailer := server.NewMailer(emailCfg, false)
+	if mailer == nil && !*dryRun {
+		fmt.Fprintln(os.Stderr, "Email is not configured: set email in site.yml or pass -dir")
+		os.Exit(1)
+	}
+	from := emailCfg.From
+	if from == "" {
+		from = "noreply@" + base.Hostname()
+	}
+
+	htmlTmpl, err := htmltemplate.ParseFiles(filepath.Join(*templateDir, "digest.html"))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load email template: %v\n", err)
+		os.Exit(1)
+	}
+	textTmpl, err := template.ParseFiles(filepath.Join(*templateDir, "digest.txt"))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load email template: %v\n", err)
+		os.Exit(1)
+	}
+
+	collections, err := build.LoadContent(build.Config{
+		ContentDir:  *contentDir,
+		TemplateDir: "templates",
+		CacheDir:    build.DefaultCacheDir,
+		BaseURL:     base.String(),
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load content: %v\n", err)
+		os.Exit(1)
+	}
+
+	// Dated posts from every series, newest first
+	var posts []*models.Post
+	seriesNames := make(map[string]string)
+	for _, collection := range collections {
+		if !collection.IsSeries() {
+			continue
+		}
+		seriesNames[collection.Slug] = collection.Name
+		posts = append(posts, collection.Posts...)
+	}
+	sort.SliceStable(posts, func(i, j int) bool {
+		return posts[i].Date.After(posts[j].Date)
+	})
+
+	database, err := db.New(resolveDBPath(*dbPath, siteCfg))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to initialize database: %v\n", err)
+		os.Exit(1)
+	}
+	defer database.Close()
+
+	subscribers, err := database.ConfirmedSubscribers()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to read subscribers: %v\n", err)
+		os.Exit(1)
+	}
+
+	site := siteCfg.Title
+	if site == "" {
+		site = base.Hostname()
+	}
+	var sent, upToDate, failed int
+
+	for _, sub := range subscribers {
+		received, err := database.NewsletterSent(sub.ID)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to read sent newsletters: %v\n", err)
+			os.Exit(1)
+		}
+
+		// Posts are dated by day, so a post from the day someone
+		// subscribed counts as new to them
+		since := sub.ConfirmedAt.UTC().Truncate(24 * time.Hour)
+		d := digest{
+			Site:           site,
+			SiteURL:        base.String() + "/",
+			Series:         seriesNames[sub.Series],
+			UnsubscribeURL: base.String() + "/newsletter/unsubscribe?token=" + url.QueryEscape(sub.UnsubscribeToken),
+		}
+		var ids []string
+		for _, post := range posts {
+			if !inSeries(post.CollectionSlug, sub.Series) || post.Date.Before(since) || received[post.ID()] {
+				continue
+			}
+			ids = append(ids, post.ID())
+			d.Posts = append(d.Posts, digestPost{
+				Title:       post.Title,
+				Description: post.Description,
+				Date:        post.Date,
+				URL:         base.String() + post.URL,
+			})
+		}
+		if len(d.Posts) == 0 {
+			upToDate++
+			continue
+		}
+
+		d.Subject = d.Posts[0].Title
+		if len(d.Posts) > 1 {
+			d.Subject = fmt.Sprintf("%d new posts from %s", len(d.Posts), site)
+		}
+
+		if *dryRun {
+			fmt.Printf("  %s: %s\n", sub.Email, strings.Join(ids, ", "))
+			continue
+		}
+
+		msg, err := renderDigest(htmlTmpl, textTmpl, d)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to render digest: %v\n", err)
+			os.Exit(1)
+		}
+		msg.From = from
+		msg.To = sub.Email
+
+		ctx, cancel := context.WithTimeout(context.Background(), newsletterTimeout)
+		err = mailer.Send(ctx, msg)
+		cancel()
+		if err != nil {
+			failed++
+			fmt.Fprintf(os.Stderr, "  ! %s: %v\n", sub.Email, err)
+			continue
+		}
+		sent++
+		fmt.Printf("  + %s: %d posts\n", sub.Email, len(d.Posts))
+		if err := database.RecordNewsletterSent(sub.ID, ids); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to record sent newsletter: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	if *dryRun {
+		fmt.Printf("\nDry run: %d subscribers up to date\n", upToDate)
+		return
+	}
+	fmt.Printf("\nSent %d digests, %d subscribers up to date, %d failed\n", sent, upToDate, failed)
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
+
+// inSeries reports whether a post's collection is the subscribed series or
+// nested under it. Subscribers to the whole site have series "".
+func inSeries(collectionSlug, series string) bool {
+	return series == "" || collectionSlug == series || strings.HasPrefix(collectionSlug, series+"/")
+}
+
+// renderDigest renders a digest's HTML and text versions, with the
+// headers that let mail clients offer one-click unsubscribe
+func renderDigest(htmlTmpl *htmltemplate.Template, textTmpl *template.Template, d digest) (mail.Message, error) {
+	var html, text bytes.Buffer
+	if err := htmlTmpl.Execute(&html, d); err != nil {
+		return mail.Message{}, err
+	}
+	if err := textTmpl.Execute(&text, d); err != nil {
+		return mail.Message{}, err
+	}
+	return mail.Message{
+		Subject: d.Subject,
+		Body:    text.String(),
+		HTML:    html.String(),
+		Headers: map[string]string{
+			"List-Unsubscribe":      "<" + d.UnsubscribeURL + ">",
+			"List-Unsubscribe-Post": "List-Unsubscribe=One-Click",
+		},
+	}, nil
+}
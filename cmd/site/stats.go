@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"site/internal/analytics"
+	"site/internal/models"
+)
+
+const (
+	statsBarWidth    = 40 // Width of the longest bar in the daily chart
+	statsReasons     = 3  // Latest feedback reasons shown per docs page
+	statsReasonWidth = 70
+)
+
+// cmdStats prints what the analytics recorded (top pages, referrers,
+// browser classes and daily totals) and the docs pages' feedback
+func cmdStats(args []string) {
+	fs := flag.NewFlagSet("stats", flag.ExitOnError)
+	days := fs.Int("days", 30, "Days to report, today included")
+	limit := fs.Int("limit", 10, "Pages and referrers to list")
+	asJSON := fs.Bool("json", false, "Print the stats as JSON")
+	dbPath := fs.String("db", "", "Database file (defaults to $SITE_DB, site.yml or data/sqlite.db)")
+	fs.Parse(args)
+
+	if *days < 1 || *limit < 1 {
+		fmt.Fprintln(os.Stderr, "-days and -limit must be at least 1")
+		os.Exit(1)
+	}
+
+	database := openDB(*dbPath)
+	defer database.Close()
+
+	stats, err := database.AnalyticsStats(analytics.Since(*days), *limit)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to read stats: %v\n", err)
+		os.Exit(1)
+	}
+	feedback, err := database.FeedbackReport(time.Now().AddDate(0, 0, -*days), "", statsReasons)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to read feedback: %v\n", err)
+		os.Exit(1)
+	}
+
+	if *asJSON {
+		out, err := json.MarshalIndent(struct {
+			*models.AnalyticsStats
+			Feedback []models.CollectionFeedback `json:"feedback"`
+		}{stats, feedback}, "", "  ")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to encode stats: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Println(string(out))
+		return
+	}
+	printStats(stats)
+	printFeedback(feedback)
+}
+
+// printStats renders stats as tables
+func printStats(stats *models.AnalyticsStats) {
+	fmt.Printf("Since %s: %d views, %d visitors\n", stats.Since, stats.Views, stats.Visitors)
+	if stats.Views == 0 {
+		return
+	}
+
+	fmt.Printf("\n%-50s %8s %9s\n", "Top pages", "Views", "Visitors")
+	for _, p := range stats.Pages {
+		fmt.Printf("  %-48s %8d %9d\n", p.Path, p.Views, p.Visitors)
+	}
+
+	if len(stats.Referrers) > 0 {
+		fmt.Printf("\n%-50s %8s\n", "Referrers", "Views")
+		for _, r := range stats.Referrers {
+			fmt.Printf("  %-48s %8d\n", r.Host, r.Views)
+		}
+	}
+
+	fmt.Printf("\n%-50s %8s\n", "Browsers", "Views")
+	for _, a := range stats.Agents {
+		fmt.Printf("  %-48s %8d %8.1f%%\n", a.Class, a.Views, 100*float64(a.Views)/float64(stats.Views))
+	}
+
+	busiest := 0
+	for _, d := range stats.Days {
+		busiest = max(busiest, d.Views)
+	}
+	fmt.Printf("\n%-12s %8s %9s\n", "Day", "Views", "Visitors")
+	for _, d := range stats.Days {
+		bar := strings.Repeat("█", (d.Views*statsBarWidth+busiest-1)/busiest)
+		fmt.Printf("  %-10s %8d %9d  %s\n", d.Day, d.Views, d.Visitors, bar)
+	}
+}
+
+// printFeedback lists docs pages by collection, the ones readers found
+// least helpful first, with their latest reasons
+func printFeedback(report []models.CollectionFeedback) {
+	if len(report) == 0 {
+		return
+	}
+
+	fmt.Printf("\n%-50s %8s %9s\n", "Docs feedback", "Helpful", "Not")
+	for _, c := range report {
+		fmt.Printf("  %-48s %8d %9d\n", c.Collection, c.Yes, c.No)
+		for _, p := range c.Pages {
+			fmt.Printf("    %-46s %8d %9d\n", p.Post, p.Yes, p.No)
+			for _, r := range p.Reasons {
+				mark := "-"
+				if r.Helpful {
+					mark = "+"
+				}
+				reason := strings.Join(strings.Fields(r.Reason), " ")
+				if len([]rune(reason)) > statsReasonWidth {
+					reason = string([]rune(reason)[:statsReasonWidth-1]) + "…"
+				}
+				fmt.Printf("      %s %s\n", mark, reason)
+			}
+		}
+	}
+}
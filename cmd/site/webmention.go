@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"site/internal/build"
+	"site/internal/db"
+	"site/internal/webmention"
+)
+
+// webmentionTimeout bounds each request to another site
+const webmentionTimeout = 15 * time.Second
+
+func cmdWebmention(args []string) {
+	if len(args) < 1 {
+		fmt.Fprintln(os.Stderr, "Usage: site webmention send [options]")
+		os.Exit(1)
+	}
+
+	switch args[0] {
+	case "send":
+		cmdWebmentionSend(args[1:])
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown webmention command: %s\n", args[0])
+		os.Exit(1)
+	}
+}
+
+// cmdWebmentionSend notifies the sites that published posts link to. Links
+// already sent are skipped, so it can run after every deploy.
+func cmdWebmentionSend(args []string) {
+	fs := flag.NewFlagSet("webmention send", flag.ExitOnError)
+	contentDir := fs.String("content", "content", "Content directory")
+	baseURL := fs.String("base-url", "", "Base URL of the published site (defaults to site.yml)")
+	postID := fs.String("post", "", "Only send for this post (collection/slug)")
+	dryRun := fs.Bool("dry-run", false, "List the links that would be notified without sending")
+	force := fs.Bool("force", false, "Send again for links that were already notified")
+	dbPath := fs.String("db", "", "Database file (defaults to $SITE_DB, site.yml or data/sqlite.db)")
+	fs.Parse(args)
+
+	siteCfg := loadSiteConfig()
+	if *baseURL == "" {
+		*baseURL = siteCfg.BaseURL
+	}
+	base, err := url.Parse(strings.TrimSuffix(*baseURL, "/"))
+	if err != nil || base.Host == "" {
+		fmt.Fprintln(os.Stderr, "A base URL is required: set base_url in site.yml or pass -base-url")
+		os.Exit(1)
+	}
+
+	collections, err := build.LoadContent(build.Config{
+		ContentDir:  *contentDir,
+		TemplateDir: "templates",
+		CacheDir:    build.DefaultCacheDir,
+		BaseURL:     base.String(),
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load content: %v\n", err)
+		os.Exit(1)
+	}
+
+	database, err := db.New(resolveDBPath(*dbPath, siteCfg))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to initialize database: %v\n", err)
+		os.Exit(1)
+	}
+	defer database.Close()
+
+	client := &http.Client{Timeout: webmentionTimeout}
+	var sent, skipped, noEndpoint, failed int
+
+	for _, collection := range collections {
+		for _, post := range collection.Posts {
+			if *postID != "" && post.ID() != *postID {
+				continue
+			}
+			source := base.String() + post.URL
+
+			for _, target := range webmention.Links(post.Content, base.JoinPath(post.URL)) {
+				if u, err := url.Parse(target); err != nil || strings.EqualFold(u.Host, base.Host) {
+					continue
+				}
+
+				if !*force {
+					done, err := database.WebmentionSent(source, target)
+					if err != nil {
+						fmt.Fprintf(os.Stderr, "Failed to read sent webmentions: %v\n", err)
+						os.Exit(1)
+					}
+					if done {
+						skipped++
+						continue
+					}
+				}
+
+				if *dryRun {
+					fmt.Printf("  %s -> %s\n", source, target)
+					continue
+				}
+
+				endpoint, err := sendWebmention(client, source, target)
+				switch {
+				case err != nil:
+					failed++
+					fmt.Fprintf(os.Stderr, "  ! %s -> %s: %v\n", source, target, err)
+				case endpoint == "":
+					noEndpoint++
+				default:
+					sent++
+					fmt.Printf("  + %s -> %s\n", source, target)
+					if err := database.RecordWebmentionSent(source, target, endpoint); err != nil {
+						fmt.Fprintf(os.Stderr, "Failed to record webmention: %v\n", err)
+						os.Exit(1)
+					}
+				}
+			}
+		}
+	}
+
+	if *dryRun {
+		fmt.Printf("\nDry run: %d already sent\n", skipped)
+		return
+	}
+	fmt.Printf("\nSent %d webmentions, %d links without an endpoint, %d already sent, %d failed\n",
+		sent, noEndpoint, skipped, failed)
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
+
+// sendWebmention discovers target's endpoint and notifies it, returning
+// the endpoint, or "" if target doesn't accept webmentions
+func sendWebmention(client *http.Client, source, target string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*webmentionTimeout)
+	defer cancel()
+
+	endpoint, err := webmention.Discover(ctx, client, target)
+	if err != nil || endpoint == "" {
+		return "", err
+	}
+	if err := webmention.Send(ctx, client, endpoint, source, target); err != nil {
+		return "", err
+	}
+	return endpoint, nil
+}
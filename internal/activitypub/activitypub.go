@@ -0,0 +1,120 @@
+// Package activitypub lets fediverse servers such as Mastodon follow the
+// site: actor documents, activities, HTTP signatures and delivery
+package activitypub
+
+const (
+	// ContentType is the media type of ActivityPub documents
+	ContentType = "application/activity+json"
+	// Public addresses an activity to everyone
+	Public = "https://www.w3.org/ns/activitystreams#Public"
+)
+
+// Context is the JSON-LD context of the documents the site serves
+var Context = []any{
+	"https://www.w3.org/ns/activitystreams",
+	"https://w3id.org/security/v1",
+	map[string]string{
+		"schema":        "http://schema.org#",
+		"PropertyValue": "schema:PropertyValue",
+		"value":         "schema:value",
+	},
+}
+
+// Actor is an account: the site's own, or a follower's
+type Actor struct {
+	Context           any             `json:"@context,omitempty"`
+	ID                string          `json:"id"`
+	Type              string          `json:"type"`
+	PreferredUsername string          `json:"preferredUsername,omitempty"`
+	Name              string          `json:"name,omitempty"`
+	Summary           string          `json:"summary,omitempty"`
+	URL               string          `json:"url,omitempty"`
+	Icon              *Image          `json:"icon,omitempty"`
+	Inbox             string          `json:"inbox"`
+	Outbox            string          `json:"outbox,omitempty"`
+	Followers         string          `json:"followers,omitempty"`
+	Endpoints         *Endpoints      `json:"endpoints,omitempty"`
+	PublicKey         PublicKey       `json:"publicKey"`
+	Attachment        []PropertyValue `json:"attachment,omitempty"`
+}
+
+type Endpoints struct {
+	SharedInbox string `json:"sharedInbox,omitempty"`
+}
+
+type Image struct {
+	Type string `json:"type"`
+	URL  string `json:"url"`
+}
+
+// PublicKey is the key an actor signs its requests with
+type PublicKey struct {
+	ID           string `json:"id"`
+	Owner        string `json:"owner"`
+	PublicKeyPem string `json:"publicKeyPem"`
+}
+
+// PropertyValue is a profile field, such as a link to another profile
+type PropertyValue struct {
+	Type  string `json:"type"`
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// Activity is something an actor did. Object is either an ID or an
+// embedded object.
+type Activity struct {
+	Context   any      `json:"@context,omitempty"`
+	ID        string   `json:"id"`
+	Type      string   `json:"type"`
+	Actor     string   `json:"actor"`
+	Object    any      `json:"object,omitempty"`
+	Published string   `json:"published,omitempty"`
+	To        []string `json:"to,omitempty"`
+	Cc        []string `json:"cc,omitempty"`
+}
+
+// Note is a short post. The site publishes each blog post as a Note that
+// links to it.
+type Note struct {
+	Context      any      `json:"@context,omitempty"`
+	ID           string   `json:"id"`
+	Type         string   `json:"type"`
+	AttributedTo string   `json:"attributedTo"`
+	Content      string   `json:"content"`
+	URL          string   `json:"url"`
+	Published    string   `json:"published,omitempty"`
+	To           []string `json:"to"`
+	Cc           []string `json:"cc,omitempty"`
+}
+
+// OrderedCollection lists items such as an outbox's activities
+type OrderedCollection struct {
+	Context      any    `json:"@context,omitempty"`
+	ID           string `json:"id"`
+	Type         string `json:"type"`
+	TotalItems   int    `json:"totalItems"`
+	OrderedItems any    `json:"orderedItems,omitempty"`
+}
+
+// ObjectID returns the ID of an activity's object
+func ObjectID(object any) string {
+	switch o := object.(type) {
+	case string:
+		return o
+	case map[string]any:
+		id, _ := o["id"].(string)
+		return id
+	}
+	return ""
+}
+
+// ObjectType returns the type of an embedded object, or "" if the object
+// is only an ID
+func ObjectType(object any) string {
+	if o, ok := object.(map[string]any); ok {
+		t, _ := o["type"].(string)
+		return t
+	}
+	return ""
+}
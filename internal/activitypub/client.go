@@ -0,0 +1,138 @@
+package activitypub
+
+import (
+	"bytes"
+	"context"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// maxDocument caps the size of fetched actor documents
+const maxDocument = 1 << 20
+
+// accept asks for ActivityPub documents, in both spellings servers use
+const accept = `application/activity+json, application/ld+json; profile="https://www.w3.org/ns/activitystreams"`
+
+// ErrGone means a remote actor or inbox has been deleted
+var ErrGone = errors.New("actor is gone")
+
+// FetchActor fetches an actor document. Requests are signed, so servers
+// that only answer signed fetches answer it.
+func FetchActor(ctx context.Context, client *http.Client, actorURL string, signer *Signer) (*Actor, error) {
+	var actor Actor
+	if err := fetch(ctx, client, actorURL, signer, &actor); err != nil {
+		return nil, err
+	}
+	if actor.ID != actorURL || actor.Inbox == "" {
+		return nil, fmt.Errorf("%s is not an actor", actorURL)
+	}
+	return &actor, nil
+}
+
+// FetchKey fetches the public key a signature names and returns it with
+// the ID of the actor that owns it. Key IDs are usually the actor's URL
+// with a fragment, but may also be documents of their own.
+func FetchKey(ctx context.Context, client *http.Client, keyID string, signer *Signer) (string, *rsa.PublicKey, error) {
+	docURL, _, _ := strings.Cut(keyID, "#")
+
+	// Decode both shapes at once: an actor embedding the key, or the key
+	var doc struct {
+		PublicKey
+		ActorKey PublicKey `json:"publicKey"`
+	}
+	if err := fetch(ctx, client, docURL, signer, &doc); err != nil {
+		return "", nil, err
+	}
+
+	key := doc.ActorKey
+	if key.ID != keyID {
+		key = doc.PublicKey
+	}
+	if key.ID != keyID || key.Owner == "" || key.PublicKeyPem == "" {
+		return "", nil, fmt.Errorf("no key %s at %s", keyID, docURL)
+	}
+	// A key can only speak for actors on its own server
+	if !sameHost(key.Owner, keyID) {
+		return "", nil, fmt.Errorf("key %s does not belong to %s", keyID, key.Owner)
+	}
+
+	pub, err := ParsePublicKey(key.PublicKeyPem)
+	if err != nil {
+		return "", nil, err
+	}
+	return key.Owner, pub, nil
+}
+
+// Deliver posts an activity to an inbox
+func Deliver(ctx context.Context, client *http.Client, inbox string, activity any, signer *Signer) error {
+	body, err := json.Marshal(activity)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, inbox, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", ContentType)
+	if err := signer.Sign(req, body); err != nil {
+		return err
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, maxDocument))
+
+	if resp.StatusCode == http.StatusGone {
+		return fmt.Errorf("inbox %s: %w", inbox, ErrGone)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("inbox %s returned %s", inbox, resp.Status)
+	}
+	return nil
+}
+
+// fetch GETs an ActivityPub document and decodes it into v
+func fetch(ctx context.Context, client *http.Client, url string, signer *Signer, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", accept)
+	if err := signer.Sign(req, nil); err != nil {
+		return err
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusGone {
+		return ErrGone
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("fetching %s: %s", url, resp.Status)
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxDocument)).Decode(v); err != nil {
+		return fmt.Errorf("failed to decode %s: %w", url, err)
+	}
+	return nil
+}
+
+// sameHost reports whether two URLs are on the same host
+func sameHost(a, b string) bool {
+	ua, errA := url.Parse(a)
+	ub, errB := url.Parse(b)
+	return errA == nil && errB == nil && ua.Host != "" && strings.EqualFold(ua.Host, ub.Host)
+}
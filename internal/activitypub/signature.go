@@ -0,0 +1,222 @@
+package activitypub
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+// maxClockSkew is how far a signed request's Date may be from now. It
+// matches what Mastodon allows, since deliveries can sit in its queue.
+const maxClockSkew = 12 * time.Hour
+
+// keyBits is the size of generated actor keys
+const keyBits = 2048
+
+var signatureParamRegex = regexp.MustCompile(`(\w+)="([^"]*)"`)
+
+// Signer signs requests on behalf of the site's actor
+type Signer struct {
+	KeyID string
+	Key   *rsa.PrivateKey
+}
+
+// Sign adds Date, Digest and Signature headers to req using the
+// draft-cavage HTTP signatures Mastodon expects. body is the request body,
+// nil for requests without one.
+func (s *Signer) Sign(req *http.Request, body []byte) error {
+	if req.Host == "" {
+		req.Host = req.URL.Host
+	}
+	req.Header.Set("Date", time.Now().UTC().Format(http.TimeFormat))
+
+	headers := []string{"(request-target)", "host", "date"}
+	if body != nil {
+		sum := sha256.Sum256(body)
+		req.Header.Set("Digest", "SHA-256="+base64.StdEncoding.EncodeToString(sum[:]))
+		headers = append(headers, "digest")
+	}
+
+	signed, err := signingString(req, headers)
+	if err != nil {
+		return err
+	}
+	hash := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, s.Key, crypto.SHA256, hash[:])
+	if err != nil {
+		return fmt.Errorf("failed to sign request: %w", err)
+	}
+
+	req.Header.Set("Signature", fmt.Sprintf(`keyId="%s",algorithm="rsa-sha256",headers="%s",signature="%s"`,
+		s.KeyID, strings.Join(headers, " "), base64.StdEncoding.EncodeToString(sig)))
+	return nil
+}
+
+// Signature is a parsed and checked Signature header, ready to be
+// verified once the signer's key has been fetched
+type Signature struct {
+	KeyID  string
+	value  []byte
+	signed string
+}
+
+// ParseSignature reads a request's Signature header. It checks everything
+// that doesn't need the key: the covered headers, the Date and, for
+// requests with a body, the Digest.
+func ParseSignature(r *http.Request, body []byte) (*Signature, error) {
+	header := r.Header.Get("Signature")
+	if header == "" {
+		return nil, errors.New("request is not signed")
+	}
+
+	params := make(map[string]string)
+	for _, m := range signatureParamRegex.FindAllStringSubmatch(header, -1) {
+		params[m[1]] = m[2]
+	}
+	if params["keyId"] == "" || params["signature"] == "" {
+		return nil, errors.New("malformed signature")
+	}
+	switch params["algorithm"] {
+	case "", "rsa-sha256", "hs2019":
+	default:
+		return nil, fmt.Errorf("unsupported signature algorithm %q", params["algorithm"])
+	}
+
+	headers := strings.Fields(strings.ToLower(params["headers"]))
+	required := []string{"(request-target)", "host", "date"}
+	if body != nil {
+		required = append(required, "digest")
+	}
+	for _, h := range required {
+		if !slices.Contains(headers, h) {
+			return nil, fmt.Errorf("signature does not cover %s", h)
+		}
+	}
+
+	date, err := http.ParseTime(r.Header.Get("Date"))
+	if err != nil {
+		return nil, errors.New("invalid Date header")
+	}
+	if d := time.Since(date); d > maxClockSkew || d < -maxClockSkew {
+		return nil, errors.New("signature date is too far from now")
+	}
+
+	if body != nil {
+		sum := sha256.Sum256(body)
+		if r.Header.Get("Digest") != "SHA-256="+base64.StdEncoding.EncodeToString(sum[:]) {
+			return nil, errors.New("digest does not match body")
+		}
+	}
+
+	value, err := base64.StdEncoding.DecodeString(params["signature"])
+	if err != nil {
+		return nil, errors.New("malformed signature")
+	}
+	signed, err := signingString(r, headers)
+	if err != nil {
+		return nil, err
+	}
+	return &Signature{KeyID: params["keyId"], value: value, signed: signed}, nil
+}
+
+// Verify checks the signature against its signer's key
+func (s *Signature) Verify(key *rsa.PublicKey) error {
+	hash := sha256.Sum256([]byte(s.signed))
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, hash[:], s.value); err != nil {
+		return errors.New("signature does not match")
+	}
+	return nil
+}
+
+// signingString builds the string a signature covers from headers
+func signingString(r *http.Request, headers []string) (string, error) {
+	lines := make([]string, 0, len(headers))
+	for _, h := range headers {
+		var value string
+		switch h {
+		case "(request-target)":
+			value = strings.ToLower(r.Method) + " " + r.URL.RequestURI()
+		case "host":
+			value = r.Host
+		default:
+			values := r.Header.Values(h)
+			if len(values) == 0 {
+				return "", fmt.Errorf("signed header %s is missing", h)
+			}
+			value = strings.Join(values, ", ")
+		}
+		lines = append(lines, h+": "+value)
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// GenerateKey creates a key for the site's actor, PEM encoded
+func GenerateKey() (string, error) {
+	key, err := rsa.GenerateKey(rand.Reader, keyBits)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate key: %w", err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return "", err
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})), nil
+}
+
+// ParsePrivateKey decodes a key made by GenerateKey
+func ParsePrivateKey(data string) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode([]byte(data))
+	if block == nil {
+		return nil, errors.New("invalid private key")
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid private key: %w", err)
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("private key is not RSA")
+	}
+	return rsaKey, nil
+}
+
+// EncodePublicKey PEM encodes a public key for an actor document
+func EncodePublicKey(key *rsa.PublicKey) (string, error) {
+	der, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		return "", err
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})), nil
+}
+
+// ParsePublicKey decodes the publicKeyPem of an actor document
+func ParsePublicKey(data string) (*rsa.PublicKey, error) {
+	block, _ := pem.Decode([]byte(data))
+	if block == nil {
+		return nil, errors.New("invalid public key")
+	}
+
+	if block.Type == "RSA PUBLIC KEY" {
+		return x509.ParsePKCS1PublicKey(block.Bytes)
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid public key: %w", err)
+	}
+	rsaKey, ok := key.(*rsa.PublicKey)
+	if !ok {
+		return nil, errors.New("public key is not RSA")
+	}
+	return rsaKey, nil
+}
@@ -0,0 +1,168 @@
+package activitypub
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testKey(t *testing.T) *rsa.PrivateKey {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, keyBits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+// signedRequest returns a POST to an inbox signed by key
+func signedRequest(t *testing.T, key *rsa.PrivateKey, body []byte) *http.Request {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "http://site.test/ap/inbox", bytes.NewReader(body))
+	signer := &Signer{KeyID: "https://remote.test/users/alice#main-key", Key: key}
+	if err := signer.Sign(req, body); err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+	return req
+}
+
+func TestSignatureRoundTrip(t *testing.T) {
+	key := testKey(t)
+	body := []byte(`{"type":"Follow"}`)
+	req := signedRequest(t, key, body)
+
+	sig, err := ParseSignature(req, body)
+	if err != nil {
+		t.Fatalf("ParseSignature: %v", err)
+	}
+	if sig.KeyID != "https://remote.test/users/alice#main-key" {
+		t.Errorf("KeyID = %q", sig.KeyID)
+	}
+	if err := sig.Verify(&key.PublicKey); err != nil {
+		t.Errorf("Verify: %v", err)
+	}
+	if err := sig.Verify(&testKey(t).PublicKey); err == nil {
+		t.Error("Verify accepted another key")
+	}
+}
+
+func TestParseSignatureRejects(t *testing.T) {
+	key := testKey(t)
+	body := []byte(`{"type":"Follow"}`)
+
+	tests := []struct {
+		name   string
+		body   []byte
+		modify func(*http.Request)
+		want   string
+	}{
+		{
+			name: "unsigned",
+			body: body,
+			modify: func(r *http.Request) {
+				r.Header.Del("Signature")
+			},
+			want: "not signed",
+		},
+		{
+			name: "body changed",
+			body: []byte(`{"type":"Undo"}`),
+			want: "digest",
+		},
+		{
+			name: "digest header changed",
+			body: body,
+			modify: func(r *http.Request) {
+				r.Header.Set("Digest", "SHA-256=AAAA")
+			},
+			want: "digest",
+		},
+		{
+			name: "stale date",
+			body: body,
+			modify: func(r *http.Request) {
+				r.Header.Set("Date", time.Now().Add(-2*maxClockSkew).UTC().Format(http.TimeFormat))
+			},
+			want: "date",
+		},
+		{
+			name: "date in the future",
+			body: body,
+			modify: func(r *http.Request) {
+				r.Header.Set("Date", time.Now().Add(2*maxClockSkew).UTC().Format(http.TimeFormat))
+			},
+			want: "date",
+		},
+		{
+			name: "digest not covered",
+			body: body,
+			modify: func(r *http.Request) {
+				r.Header.Set("Signature", strings.Replace(r.Header.Get("Signature"), " digest", "", 1))
+			},
+			want: "does not cover digest",
+		},
+		{
+			name: "unsupported algorithm",
+			body: body,
+			modify: func(r *http.Request) {
+				r.Header.Set("Signature", strings.Replace(r.Header.Get("Signature"), "rsa-sha256", "hmac-sha256", 1))
+			},
+			want: "algorithm",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := signedRequest(t, key, body)
+			if tt.modify != nil {
+				tt.modify(req)
+			}
+			_, err := ParseSignature(req, tt.body)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("ParseSignature error = %v, want one mentioning %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestVerifyRejectsChangedHost(t *testing.T) {
+	key := testKey(t)
+	body := []byte(`{"type":"Follow"}`)
+	req := signedRequest(t, key, body)
+	req.Host = "other.test"
+
+	sig, err := ParseSignature(req, body)
+	if err != nil {
+		t.Fatalf("ParseSignature: %v", err)
+	}
+	if err := sig.Verify(&key.PublicKey); err == nil {
+		t.Error("Verify accepted a request replayed to another host")
+	}
+}
+
+func TestKeyEncoding(t *testing.T) {
+	pemKey, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ParsePrivateKey(pemKey)
+	if err != nil {
+		t.Fatalf("ParsePrivateKey: %v", err)
+	}
+	pub, err := EncodePublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := ParsePublicKey(pub)
+	if err != nil {
+		t.Fatalf("ParsePublicKey: %v", err)
+	}
+	if !parsed.Equal(&key.PublicKey) {
+		t.Error("public key changed in a round trip")
+	}
+}
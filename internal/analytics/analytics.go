@@ -0,0 +1,114 @@
+// Package analytics counts page views without cookies or stored IP
+// addresses: visitors are hashes keyed with a salt that changes every day
+package analytics
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Kinds of browser a view comes from. Nothing finer is kept, so a view
+// can't be tied to a device or a country.
+const (
+	ClassDesktop = "desktop"
+	ClassMobile  = "mobile"
+	ClassTablet  = "tablet"
+	ClassBot     = "bot"
+	ClassOther   = "other"
+)
+
+// botMarkers are substrings of crawler, feed reader and script user agents
+var botMarkers = []string{
+	"bot", "crawl", "spider", "slurp", "curl", "wget", "python", "go-http-client",
+	"java/", "okhttp", "headless", "lighthouse", "feed", "fetch", "preview", "monitor",
+}
+
+// Day returns the UTC day t falls on, as stored in the database
+func Day(t time.Time) string {
+	return t.UTC().Format(time.DateOnly)
+}
+
+// Since returns the first day of the last days days, today included
+func Since(days int) string {
+	return Day(time.Now().AddDate(0, 0, 1-days))
+}
+
+// OptedOut reports whether the browser asked not to be tracked, with
+// either Do Not Track or Global Privacy Control
+func OptedOut(r *http.Request) bool {
+	return r.Header.Get("DNT") == "1" || r.Header.Get("Sec-GPC") == "1"
+}
+
+// Prefetch reports whether the request is a speculative load the reader
+// may never see
+func Prefetch(r *http.Request) bool {
+	purpose := r.Header.Get("Sec-Purpose") + r.Header.Get("Purpose") + r.Header.Get("X-Moz")
+	return strings.Contains(purpose, "prefetch") || strings.Contains(purpose, "prerender")
+}
+
+// Classify sorts a user agent into one of the classes above
+func Classify(userAgent string) string {
+	ua := strings.ToLower(userAgent)
+	if ua == "" {
+		return ClassBot
+	}
+	for _, marker := range botMarkers {
+		if strings.Contains(ua, marker) {
+			return ClassBot
+		}
+	}
+
+	switch {
+	case strings.Contains(ua, "ipad"), strings.Contains(ua, "tablet"), strings.Contains(ua, "kindle"),
+		strings.Contains(ua, "android") && !strings.Contains(ua, "mobile"):
+		return ClassTablet
+	case strings.Contains(ua, "mobi"), strings.Contains(ua, "iphone"), strings.Contains(ua, "android"):
+		return ClassMobile
+	case strings.Contains(ua, "windows"), strings.Contains(ua, "macintosh"), strings.Contains(ua, "x11"),
+		strings.Contains(ua, "linux"), strings.Contains(ua, "cros"):
+		return ClassDesktop
+	}
+	return ClassOther
+}
+
+// ReferrerHost returns the host a view was referred from, or "" for
+// direct visits and links within the site. Only the host is kept, since
+// full referrer URLs can carry search terms and tokens.
+func ReferrerHost(referer, ownHost string) string {
+	u, err := url.Parse(referer)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return ""
+	}
+	host := strings.TrimPrefix(strings.ToLower(u.Hostname()), "www.")
+	own := strings.TrimPrefix(strings.ToLower(ownHost), "www.")
+	if i := strings.LastIndex(own, ":"); i >= 0 && !strings.Contains(own, "]") {
+		own = own[:i]
+	}
+	if host == own {
+		return ""
+	}
+	return host
+}
+
+// NewSalt returns a random salt for a day's visitor hashes
+func NewSalt() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// Visitor returns the hash that tells a day's visitors apart. Once the
+// day's salt is deleted it can't be linked back to an address.
+func Visitor(salt, ip, userAgent string) string {
+	mac := hmac.New(sha256.New, []byte(salt))
+	mac.Write([]byte(ip + "\n" + userAgent))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))[:16]
+}
@@ -0,0 +1,96 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"site/internal/models"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/github"
+)
+
+const githubAPIURL = "https://api.github.com"
+
+// GitHub signs users in with their GitHub account
+type GitHub struct {
+	oauth2Provider
+	APIURL string
+}
+
+// NewGitHub returns a GitHub provider for an OAuth app
+func NewGitHub(clientID, clientSecret, redirectURL string) *GitHub {
+	return &GitHub{
+		oauth2Provider: oauth2Provider{
+			id:   "github",
+			name: "GitHub",
+			Config: &oauth2.Config{
+				ClientID:     clientID,
+				ClientSecret: clientSecret,
+				RedirectURL:  redirectURL,
+				Scopes:       []string{"user:email"},
+				Endpoint:     github.Endpoint,
+			},
+		},
+		APIURL: githubAPIURL,
+	}
+}
+
+func (g *GitHub) Profile(ctx context.Context, token *oauth2.Token) (*models.User, error) {
+	var info struct {
+		ID        int64  `json:"id"`
+		Login     string `json:"login"`
+		Name      string `json:"name"`
+		Email     string `json:"email"`
+		AvatarURL string `json:"avatar_url"`
+	}
+	if err := g.getJSON(ctx, token, g.APIURL+"/user", &info); err != nil {
+		return nil, err
+	}
+	if info.ID == 0 {
+		return nil, errors.New("github profile has no user ID")
+	}
+
+	// The profile's public email isn't necessarily verified, and may be
+	// missing. The emails endpoint says which addresses are; prefer the
+	// public one if it is verified, then the verified primary address,
+	// then whichever address there is.
+	var emails []struct {
+		Email    string `json:"email"`
+		Primary  bool   `json:"primary"`
+		Verified bool   `json:"verified"`
+	}
+	if g.getJSON(ctx, token, g.APIURL+"/user/emails", &emails) != nil {
+		emails = nil
+	}
+	email, verified := info.Email, false
+	for _, e := range emails {
+		switch {
+		case e.Verified && strings.EqualFold(e.Email, info.Email):
+			email, verified = e.Email, true
+		case e.Verified && e.Primary && !verified:
+			email, verified = e.Email, true
+		case e.Primary && email == "":
+			email = e.Email
+		}
+	}
+	info.Email = email
+
+	// Use login as name if name is empty
+	name := info.Name
+	if name == "" {
+		name = info.Login
+	}
+
+	return &models.User{
+		ID:            g.id + ":" + strconv.FormatInt(info.ID, 10),
+		Email:         info.Email,
+		EmailVerified: verified,
+		Name:          name,
+		AvatarURL:     info.AvatarURL,
+		CreatedAt:     time.Now(),
+	}, nil
+}
@@ -0,0 +1,66 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"site/internal/models"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+)
+
+const googleUserInfoURL = "https://www.googleapis.com/oauth2/v2/userinfo"
+
+// Google signs users in with their Google account
+type Google struct {
+	oauth2Provider
+	UserInfoURL string
+}
+
+// NewGoogle returns a Google provider for an OAuth client
+func NewGoogle(clientID, clientSecret, redirectURL string) *Google {
+	return &Google{
+		oauth2Provider: oauth2Provider{
+			id:   "google",
+			name: "Google",
+			Config: &oauth2.Config{
+				ClientID:     clientID,
+				ClientSecret: clientSecret,
+				RedirectURL:  redirectURL,
+				Scopes: []string{
+					"https://www.googleapis.com/auth/userinfo.email",
+					"https://www.googleapis.com/auth/userinfo.profile",
+				},
+				Endpoint: google.Endpoint,
+			},
+		},
+		UserInfoURL: googleUserInfoURL,
+	}
+}
+
+func (g *Google) Profile(ctx context.Context, token *oauth2.Token) (*models.User, error) {
+	var info struct {
+		ID            string `json:"id"`
+		Email         string `json:"email"`
+		VerifiedEmail bool   `json:"verified_email"`
+		Name          string `json:"name"`
+		Picture       string `json:"picture"`
+	}
+	if err := g.getJSON(ctx, token, g.UserInfoURL, &info); err != nil {
+		return nil, err
+	}
+	if info.ID == "" {
+		return nil, errors.New("google profile has no user ID")
+	}
+
+	return &models.User{
+		ID:            g.id + ":" + info.ID,
+		Email:         info.Email,
+		EmailVerified: info.VerifiedEmail,
+		Name:          info.Name,
+		AvatarURL:     info.Picture,
+		CreatedAt:     time.Now(),
+	}, nil
+}
@@ -0,0 +1,182 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// fakeIdP serves discovery, token and userinfo endpoints. The token
+// endpoint only accepts code "good-code", and userinfo returns claims for
+// the access token it issued.
+func fakeIdP(t *testing.T, claims map[string]any) *httptest.Server {
+	t.Helper()
+	mux := http.NewServeMux()
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 srv.URL,
+			"authorization_endpoint": srv.URL + "/authorize",
+			"token_endpoint":         srv.URL + "/token",
+			"userinfo_endpoint":      srv.URL + "/userinfo",
+		})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if r.PostFormValue("code") != "good-code" || r.PostFormValue("grant_type") != "authorization_code" {
+			http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+			return
+		}
+		if id, secret, ok := r.BasicAuth(); !ok || id != "site" || secret != "secret" {
+			if r.PostFormValue("client_id") != "site" || r.PostFormValue("client_secret") != "secret" {
+				http.Error(w, `{"error":"invalid_client"}`, http.StatusUnauthorized)
+				return
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{
+			"access_token": "access-123",
+			"token_type":   "Bearer",
+			"expires_in":   3600,
+		})
+	})
+	mux.HandleFunc("/userinfo", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer access-123" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode(claims)
+	})
+	return srv
+}
+
+func newTestOIDC(t *testing.T, issuer string) *OIDC {
+	t.Helper()
+	p, err := NewOIDC(context.Background(), OIDCConfig{
+		ID:       "fake",
+		Issuer:   issuer,
+		ClientID: "site",
+	}, "secret", "http://localhost/auth/fake/callback")
+	if err != nil {
+		t.Fatalf("NewOIDC: %v", err)
+	}
+	return p
+}
+
+func TestOIDCSignIn(t *testing.T) {
+	tests := []struct {
+		name         string
+		claims       map[string]any
+		wantEmail    string
+		wantVerified bool
+		wantName     string
+	}{
+		{
+			name:         "verified email",
+			claims:       map[string]any{"sub": "42", "email": "ada@example.com", "email_verified": true, "name": "Ada"},
+			wantEmail:    "ada@example.com",
+			wantVerified: true,
+			wantName:     "Ada",
+		},
+		{
+			name:         "verified as a string",
+			claims:       map[string]any{"sub": "42", "email": "ada@example.com", "email_verified": "true"},
+			wantEmail:    "ada@example.com",
+			wantVerified: true,
+			wantName:     "ada",
+		},
+		{
+			name:      "unverified email",
+			claims:    map[string]any{"sub": "42", "email": "admin@example.com", "email_verified": false, "preferred_username": "mallory"},
+			wantEmail: "admin@example.com",
+			wantName:  "mallory",
+		},
+		{
+			name:      "no verification claim",
+			claims:    map[string]any{"sub": "42", "email": "admin@example.com"},
+			wantEmail: "admin@example.com",
+			wantName:  "admin",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := fakeIdP(t, tt.claims)
+			p := newTestOIDC(t, srv.URL+"/")
+			ctx := context.Background()
+
+			if p.ID() != "fake" || p.Name() != "fake" {
+				t.Errorf("got ID %q, name %q", p.ID(), p.Name())
+			}
+			if got := p.Config.Endpoint.TokenURL; got != srv.URL+"/token" {
+				t.Errorf("token URL from discovery = %q", got)
+			}
+
+			token, err := p.Exchange(ctx, "good-code")
+			if err != nil {
+				t.Fatalf("Exchange: %v", err)
+			}
+			user, err := p.Profile(ctx, token)
+			if err != nil {
+				t.Fatalf("Profile: %v", err)
+			}
+			if user.ID != "fake:42" {
+				t.Errorf("ID = %q, want fake:42", user.ID)
+			}
+			if user.Email != tt.wantEmail {
+				t.Errorf("Email = %q, want %q", user.Email, tt.wantEmail)
+			}
+			if user.EmailVerified != tt.wantVerified {
+				t.Errorf("EmailVerified = %v, want %v", user.EmailVerified, tt.wantVerified)
+			}
+			if user.Name != tt.wantName {
+				t.Errorf("Name = %q, want %q", user.Name, tt.wantName)
+			}
+		})
+	}
+}
+
+func TestOIDCRejectsBadCode(t *testing.T) {
+	srv := fakeIdP(t, map[string]any{"sub": "42"})
+	p := newTestOIDC(t, srv.URL)
+	if _, err := p.Exchange(context.Background(), "stolen-code"); err == nil {
+		t.Fatal("Exchange accepted an unknown code")
+	}
+}
+
+func TestOIDCRejectsMissingSubject(t *testing.T) {
+	srv := fakeIdP(t, map[string]any{"email": "ada@example.com", "email_verified": true})
+	p := newTestOIDC(t, srv.URL)
+	ctx := context.Background()
+	token, err := p.Exchange(ctx, "good-code")
+	if err != nil {
+		t.Fatalf("Exchange: %v", err)
+	}
+	if _, err := p.Profile(ctx, token); err == nil {
+		t.Fatal("Profile accepted userinfo without a subject")
+	}
+}
+
+func TestOIDCDiscoveryIssuerMismatch(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 "https://idp.example.com",
+			"authorization_endpoint": "https://idp.example.com/authorize",
+			"token_endpoint":         "https://idp.example.com/token",
+			"userinfo_endpoint":      "https://idp.example.com/userinfo",
+		})
+	}))
+	defer srv.Close()
+
+	_, err := NewOIDC(context.Background(), OIDCConfig{
+		ID:       "fake",
+		Issuer:   srv.URL,
+		ClientID: "site",
+	}, "secret", "http://localhost/auth/fake/callback")
+	if err == nil {
+		t.Fatal("NewOIDC accepted a discovery document for another issuer")
+	}
+}
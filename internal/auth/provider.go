@@ -0,0 +1,87 @@
+// Package auth signs users in with external identity providers
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"site/internal/models"
+
+	"golang.org/x/oauth2"
+)
+
+// Provider is an identity provider users can sign in with. User IDs it
+// returns are prefixed with its ID, e.g. "github:123".
+type Provider interface {
+	// ID names the provider in URLs: /auth/{id} and /auth/{id}/callback
+	ID() string
+	// Name is shown on the sign in button
+	Name() string
+	// AuthURL returns the page to send the browser to for signing in
+	AuthURL(state string) string
+	// Exchange trades the code passed to the callback for a token
+	Exchange(ctx context.Context, code string) (*oauth2.Token, error)
+	// Profile fetches the signed-in user's details. EmailVerified is only
+	// set when the provider says the address is verified, since the admins
+	// list matches verified emails.
+	Profile(ctx context.Context, token *oauth2.Token) (*models.User, error)
+}
+
+// oauth2Provider implements the parts of Provider every OAuth 2 provider
+// shares. Requests use the *http.Client in ctx under oauth2.HTTPClient,
+// if there is one.
+type oauth2Provider struct {
+	id     string
+	name   string
+	Config *oauth2.Config
+}
+
+func (p *oauth2Provider) ID() string   { return p.id }
+func (p *oauth2Provider) Name() string { return p.name }
+
+func (p *oauth2Provider) AuthURL(state string) string {
+	return p.Config.AuthCodeURL(state, oauth2.AccessTypeOffline)
+}
+
+func (p *oauth2Provider) Exchange(ctx context.Context, code string) (*oauth2.Token, error) {
+	return p.Config.Exchange(ctx, code)
+}
+
+// getJSON fetches url on behalf of the user and decodes the response into v
+func (p *oauth2Provider) getJSON(ctx context.Context, token *oauth2.Token, url string, v any) error {
+	return getJSON(p.Config.Client(ctx, token), url, v)
+}
+
+// getJSON fetches url with client and decodes the response into v
+func getJSON(client *http.Client, url string, v any) error {
+	resp, err := client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %s", url, resp.Status)
+	}
+	if err := decodeJSON(resp, v); err != nil {
+		return fmt.Errorf("failed to decode %s: %w", url, err)
+	}
+	return nil
+}
+
+// decodeJSON decodes a response body of at most 1MB into v
+func decodeJSON(resp *http.Response, v any) error {
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(v)
+}
+
+// contextClient returns the HTTP client carried by ctx, as the oauth2
+// package does
+func contextClient(ctx context.Context) *http.Client {
+	if client, ok := ctx.Value(oauth2.HTTPClient).(*http.Client); ok {
+		return client
+	}
+	return http.DefaultClient
+}
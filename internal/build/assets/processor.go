@@ -8,6 +8,8 @@ import (
 	"path/filepath"
 	"strings"
 
+	"site/internal/build/output"
+
 	"github.com/tdewolff/minify/v2"
 	"github.com/tdewolff/minify/v2/css"
 	"github.com/tdewolff/minify/v2/html"
@@ -20,13 +22,13 @@ type Hashes map[string]string
 // Processor handles static asset processing
 type Processor struct {
 	staticDir string
-	outputDir string
+	out       output.Writer
 	devMode   bool
 	minifier  *minify.M
 }
 
 // NewProcessor creates a new asset processor
-func NewProcessor(staticDir, outputDir string, devMode bool) *Processor {
+func NewProcessor(staticDir string, out output.Writer, devMode bool) *Processor {
 	// Setup minifier
 	m := minify.New()
 	m.AddFunc("text/css", css.Minify)
@@ -36,7 +38,7 @@ func NewProcessor(staticDir, outputDir string, devMode bool) *Processor {
 
 	return &Processor{
 		staticDir: staticDir,
-		outputDir: outputDir,
+		out:       out,
 		devMode:   devMode,
 		minifier:  m,
 	}
@@ -57,8 +59,7 @@ func (p *Processor) ProcessAll() (Hashes, error) {
 		}
 
 		if info.IsDir() {
-			destPath := filepath.Join(p.outputDir, relPath)
-			return os.MkdirAll(destPath, 0755)
+			return nil
 		}
 
 		// Process file
@@ -99,7 +100,7 @@ func (p *Processor) processFile(srcPath, relPath string, hashes Hashes) error {
 	}
 
 	// Generate hash and rename if needed
-	destPath := filepath.Join(p.outputDir, relPath)
+	destPath := relPath
 
 	if shouldHash {
 		// Generate SHA256 hash of content
@@ -120,10 +121,6 @@ func (p *Processor) processFile(srcPath, relPath string, hashes Hashes) error {
 		hashes[webPath] = hashedWebPath
 	}
 
-	if err := os.MkdirAll(filepath.Dir(destPath), 0755); err != nil {
-		return err
-	}
-
 	// Write to destination
-	return os.WriteFile(destPath, output, 0644)
+	return p.out.WriteFile(destPath, output)
 }
@@ -7,16 +7,22 @@ import (
 	"html/template"
 	"os"
 	"path/filepath"
+	"regexp"
 	"runtime"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
 	"site/internal/build/assets"
+	"site/internal/build/cache"
 	"site/internal/build/content"
+	"site/internal/build/manifest"
 	"site/internal/build/markdown"
+	"site/internal/build/markdown/extensions"
 	"site/internal/build/og"
+	"site/internal/build/output"
 	"site/internal/build/search"
 	"site/internal/models"
 
@@ -29,11 +35,103 @@ const postsPerPage = 10
 type Site struct {
 	Config      Config
 	Collections []*models.Collection
+	Tags        *models.Taxonomy
+	Categories  *models.Taxonomy
 	AssetHashes assets.Hashes // Maps original filename to hashed filename
+
+	out          output.Writer
+	cache        *cache.Cache
+	renderer     *markdown.Renderer
+	configKey    string                        // Hash of site.yml and the resolved config
+	pagesKey     string                        // Hash of the inputs every page shares
+	bodiesKey    string                        // Hash of every post's rendered body
+	templateKeys map[*template.Template]string // Hash of the files each page template is parsed from
+	feedFiles    []string                      // Outputs written by the sitemap and feeds
+	feedDirs     map[string]bool               // Output directories that get feeds
 }
 
 // Build generates the static site
 func Build(cfg Config) error {
+	out := output.NewDir(cfg.OutputDir)
+	site := newSite(cfg, out)
+
+	if err := site.run(); err != nil {
+		return err
+	}
+
+	// Remove outputs left over from previous builds
+	if _, err := out.Prune(); err != nil {
+		return fmt.Errorf("failed to prune output directory: %w", err)
+	}
+
+	if err := site.cache.Save(); err != nil {
+		return fmt.Errorf("failed to save build cache: %w", err)
+	}
+
+	return nil
+}
+
+// Report describes what a dry-run build would change in the output directory
+type Report struct {
+	Changes   []output.Change
+	Unchanged int
+	Indexed   int // Posts that would be written to the search index
+}
+
+// DryRun runs the full build pipeline against an in-memory output and
+// reports how the result differs from the current output directory.
+// Nothing is written to disk, the search index or the build cache.
+func DryRun(cfg Config) (*Report, error) {
+	index := &countingIndex{}
+	cfg.DB = index
+
+	mem := output.NewMemory()
+	site := newSite(cfg, mem)
+
+	if err := site.run(); err != nil {
+		return nil, err
+	}
+
+	changes, unchanged, err := mem.Diff(site.Config.OutputDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compare with output directory: %w", err)
+	}
+
+	return &Report{
+		Changes:   changes,
+		Unchanged: unchanged,
+		Indexed:   index.posts,
+	}, nil
+}
+
+// LoadContent reads the published posts the way a build would, without
+// writing any output
+func LoadContent(cfg Config) ([]*models.Collection, error) {
+	site := newSite(cfg, output.NewMemory())
+	if err := site.loadContent(); err != nil {
+		return nil, err
+	}
+	return site.Collections, nil
+}
+
+// countingIndex stands in for the search database during a dry run
+type countingIndex struct {
+	posts int
+}
+
+func (c *countingIndex) ClearSearchIndex() error {
+	c.posts = 0
+	return nil
+}
+
+func (c *countingIndex) IndexPost(slug, collectionSlug, title, description, content, postType, url, date string) error {
+	c.posts++
+	return nil
+}
+
+// newSite resolves the config against site.yml and prepares a site that
+// writes its outputs to out
+func newSite(cfg Config, out output.Writer) *Site {
 	// Load site config
 	if cfg.SiteName == "" {
 		cfg.SiteName = "Site"
@@ -41,16 +139,17 @@ func Build(cfg Config) error {
 
 	// Try to load site.yml for config
 	siteConfigPath := "site.yml"
-	if data, err := os.ReadFile(siteConfigPath); err == nil {
+	siteConfigData, err := os.ReadFile(siteConfigPath)
+	if err == nil {
 		var siteCfg struct {
-			Title              string             `yaml:"title"`
-			Description        string             `yaml:"description"`
-			BaseURL            string             `yaml:"base_url"`
-			DefaultSocialImage string             `yaml:"default_social_image"`
-			Profile            ProfileConfig      `yaml:"profile"`
-			Referrals          []ReferralConfig   `yaml:"referrals"`
-		}
-		if err := yaml.Unmarshal(data, &siteCfg); err == nil {
+			Title              string           `yaml:"title"`
+			Description        string           `yaml:"description"`
+			BaseURL            string           `yaml:"base_url"`
+			DefaultSocialImage string           `yaml:"default_social_image"`
+			Profile            ProfileConfig    `yaml:"profile"`
+			Referrals          []ReferralConfig `yaml:"referrals"`
+		}
+		if err := yaml.Unmarshal(siteConfigData, &siteCfg); err == nil {
 			if siteCfg.Title != "" {
 				cfg.SiteName = siteCfg.Title
 			}
@@ -68,68 +167,238 @@ func Build(cfg Config) error {
 		}
 	}
 
-	site := &Site{
-		Config: cfg,
+	var buildCache *cache.Cache
+	if cfg.CacheDir != "" {
+		buildCache = cache.Open(cfg.CacheDir)
 	}
 
-	// Clean output directory
-	if err := os.RemoveAll(cfg.OutputDir); err != nil {
-		return fmt.Errorf("failed to clean output directory: %w", err)
+	return &Site{
+		Config:    cfg,
+		out:       out,
+		cache:     buildCache,
+		configKey: cache.Key(string(siteConfigData), cfg.BaseURL, cfg.SiteName, fmt.Sprint(cfg.DevMode)),
 	}
+}
 
+// run executes the build pipeline, writing every output through s.out
+func (s *Site) run() error {
 	// Load content
-	if err := site.loadContent(); err != nil {
+	if err := s.loadContent(); err != nil {
 		return fmt.Errorf("failed to load content: %w", err)
 	}
 
 	// Index content for search (if database provided)
-	if cfg.DB != nil {
-		indexer := search.NewIndexer(cfg.DB)
-		if err := indexer.IndexAll(site.Collections); err != nil {
+	if s.Config.DB != nil {
+		indexer := search.NewIndexer(s.Config.DB)
+		if err := indexer.IndexAll(s.Collections); err != nil {
 			return fmt.Errorf("failed to index content: %w", err)
 		}
 	}
 
 	// Copy static files first to generate hashes
-	processor := assets.NewProcessor(cfg.StaticDir, cfg.OutputDir, cfg.DevMode)
+	processor := assets.NewProcessor(s.Config.StaticDir, s.out, s.Config.DevMode)
 	assetHashes, err := processor.ProcessAll()
 	if err != nil {
 		return fmt.Errorf("failed to copy static files: %w", err)
 	}
-	site.AssetHashes = assetHashes
+	s.AssetHashes = assetHashes
 
 	// Load templates (after copying static, so asset hashes are available)
-	tmpl, err := site.loadTemplates()
+	s.bodiesKey = s.hashBodies()
+	tmpl, err := s.loadTemplates()
 	if err != nil {
 		return fmt.Errorf("failed to load templates: %w", err)
 	}
 
 	// Generate OG images concurrently
-	if err := site.generateOGImages(); err != nil {
+	if err := s.generateOGImages(); err != nil {
 		return fmt.Errorf("failed to generate OG images: %w", err)
 	}
 
-	// Generate pages
-	if err := site.generatePages(tmpl); err != nil {
+	// Generate pages, each skipped if nothing it depends on changed
+	navKey := s.hashNav()
+	s.pagesKey = s.hashPageInputs(navKey)
+	if err := s.generatePages(tmpl); err != nil {
 		return fmt.Errorf("failed to generate pages: %w", err)
 	}
 
-	// Generate sitemap
-	if err := site.generateSitemap(); err != nil {
-		return fmt.Errorf("failed to generate sitemap: %w", err)
+	// Generate sitemap and feeds, unless no post changed
+	feedsKey := cache.Key(s.configKey, navKey, s.bodiesKey, strconv.FormatBool(tmpl.hasTaxonomyPages()))
+	if files, ok := s.cache.Fresh("feeds", feedsKey); !ok || !s.keepAll(files) {
+		if err := s.generateSitemap(tmpl.hasTaxonomyPages()); err != nil {
+			return fmt.Errorf("failed to generate sitemap: %w", err)
+		}
+
+		if err := s.generateFeeds(); err != nil {
+			return fmt.Errorf("failed to generate feeds: %w", err)
+		}
+		s.cache.Put("feeds", feedsKey, s.feedFiles...)
+	}
+
+	// Write the post manifest the server validates API requests against
+	if err := s.writeManifest(); err != nil {
+		return fmt.Errorf("failed to write post manifest: %w", err)
 	}
 
 	return nil
 }
 
+// writeManifest records every published post and its per-post flags
+func (s *Site) writeManifest() error {
+	data, err := manifest.New(s.Collections).Marshal()
+	if err != nil {
+		return err
+	}
+	return s.out.WriteFile(manifest.File, data)
+}
+
+// hashPageInputs hashes what every page depends on besides its template
+// and its own data: config, critical CSS, the asset hashes pages link to,
+// the year in the footer and navKey, the collections and post metadata
+// that navigation and listings show
+func (s *Site) hashPageInputs(navKey string) string {
+	criticalCSS := cache.HashFiles(filepath.Join(s.Config.StaticDir, "css", "critical.css"))
+	assetsJSON, _ := json.Marshal(s.AssetHashes)
+
+	return cache.Key(
+		s.configKey,
+		criticalCSS,
+		string(assetsJSON),
+		fmt.Sprint(time.Now().Year()),
+		navKey,
+	)
+}
+
+// navCollection and navPost are the parts of collections and posts that
+// pages other than a post's own read. Post bodies are left out, so editing
+// a post only re-renders its page.
+type navCollection struct {
+	Slug, Name, Description, Icon, Banner string
+	Type                                  models.CollectionType
+	LatestPost                            time.Time
+	PostCount                             int
+	Posts                                 []navPost
+	ChildSeries                           []string
+}
+
+type navPost struct {
+	Title, Description, Slug, CollectionSlug, TopicSlug, URL, OGImage string
+	Date, Updated                                                     time.Time
+	Draft, Comments, Reactions                                        bool
+	Order                                                             int
+	Tags, Categories                                                  []string
+	Prev, Next                                                        string // URLs
+}
+
+// hashNav hashes the metadata of every collection and post
+func (s *Site) hashNav() string {
+	var nav []navCollection
+	for _, c := range s.Collections {
+		nc := navCollection{
+			Slug:        c.Slug,
+			Name:        c.Name,
+			Description: c.Description,
+			Icon:        c.Icon,
+			Banner:      c.Banner,
+			Type:        c.Type,
+			LatestPost:  c.LatestPost,
+			PostCount:   c.PostCount,
+		}
+		for _, child := range c.ChildSeries {
+			nc.ChildSeries = append(nc.ChildSeries, child.Slug)
+		}
+		for _, p := range c.Posts {
+			np := navPost{
+				Title:          p.Title,
+				Description:    p.Description,
+				Slug:           p.Slug,
+				CollectionSlug: p.CollectionSlug,
+				TopicSlug:      p.TopicSlug,
+				URL:            p.URL,
+				OGImage:        p.OGImage,
+				Date:           p.Date,
+				Updated:        p.Updated,
+				Draft:          p.Draft,
+				Comments:       p.Comments,
+				Reactions:      p.Reactions,
+				Order:          p.Order,
+				Tags:           p.Tags,
+				Categories:     p.Categories,
+			}
+			if p.PrevPost != nil {
+				np.Prev = p.PrevPost.URL
+			}
+			if p.NextPost != nil {
+				np.Next = p.NextPost.URL
+			}
+			nc.Posts = append(nc.Posts, np)
+		}
+		nav = append(nav, nc)
+	}
+	data, _ := json.Marshal(nav)
+	return cache.Key(string(data))
+}
+
+// hashBody hashes what a post's own page shows of it beyond its metadata
+func hashBody(p *models.Post) string {
+	toc, _ := json.Marshal(p.TOC)
+	return cache.Key(p.Content, string(toc))
+}
+
+// hashBodies hashes the body of every post
+func (s *Site) hashBodies() string {
+	var parts []string
+	for _, c := range s.Collections {
+		for _, p := range c.Posts {
+			parts = append(parts, p.URL, hashBody(p))
+		}
+	}
+	return cache.Key(parts...)
+}
+
+// bodyRefRegex finds template references to post bodies. A template that
+// reads bodies other than its own post's makes its pages depend on all of
+// them.
+var bodyRefRegex = regexp.MustCompile(`\.(Content|TOC)\b`)
+
+// ownBodyRefs are how the post template reads its own post's body
+var ownBodyRefs = strings.NewReplacer(".Post.Content", "", ".Post.TOC", "")
+
+// keepAll marks cached outputs as produced, reporting false if any is missing
+func (s *Site) keepAll(files []string) bool {
+	for _, f := range files {
+		if !s.out.Keep(f) {
+			return false
+		}
+	}
+	return true
+}
+
 // loadContent reads and parses all content files
 func (s *Site) loadContent() error {
-	loader := content.NewLoader(s.Config.ContentDir)
+	// Directive templates override the built-in handlers of the same name
+	directives := extensions.NewDirectives()
+	if err := directives.LoadTemplates(filepath.Join(s.Config.TemplateDir, "directives")); err != nil {
+		return err
+	}
+	s.renderer = markdown.NewRenderer(directives)
+
+	loader := content.NewLoader(s.Config.ContentDir, s.renderer).WithCache(s.cache)
 	collections, err := loader.LoadAll()
 	if err != nil {
 		return err
 	}
 	s.Collections = collections
+
+	// Build the taxonomy index from frontmatter
+	s.Tags = models.NewTaxonomy(models.TaxonomyTags, collections, func(p *models.Post) []string { return p.Tags })
+	s.Categories = models.NewTaxonomy(models.TaxonomyCategories, collections, func(p *models.Post) []string { return p.Categories })
+	for _, taxonomy := range []*models.Taxonomy{s.Tags, s.Categories} {
+		for _, warning := range taxonomy.Warnings {
+			fmt.Fprintf(os.Stderr, "Warning: %s\n", warning)
+		}
+	}
+	s.planFeeds()
 	return nil
 }
 
@@ -153,7 +422,7 @@ func (s *Site) generateOGImages() error {
 	}
 
 	generator, err := og.NewGenerator(
-		s.Config.OutputDir,
+		s.out,
 		profilePhotoPath,
 		fontPath,
 		fontBoldPath,
@@ -176,6 +445,14 @@ func (s *Site) generateOGImages() error {
 				sem <- struct{}{}
 				defer func() { <-sem }()
 
+				// Reuse the previous image if nothing drawn on it changed
+				outPath := generator.OutputPath(p, c)
+				key := generator.Fingerprint(p, c)
+				if _, ok := s.cache.Fresh("og:"+outPath, key); ok && s.out.Keep(outPath) {
+					p.OGImage = s.Config.BaseURL + generator.WebPath(p, c)
+					return
+				}
+
 				ogPath, err := generator.Generate(p, c)
 				if err != nil {
 					select {
@@ -184,6 +461,7 @@ func (s *Site) generateOGImages() error {
 					}
 					return
 				}
+				s.cache.Put("og:"+outPath, key, outPath)
 				p.OGImage = s.Config.BaseURL + ogPath
 			}(post, collection)
 		}
@@ -208,6 +486,8 @@ type TemplateSet struct {
 	Post       *template.Template
 	Profile    *template.Template
 	Referrals  *template.Template
+	Taxonomy   *template.Template
+	Term       *template.Template
 }
 
 // loadTemplates loads all HTML templates
@@ -242,6 +522,7 @@ func (s *Site) loadTemplates() (*TemplateSet, error) {
 		"hasPrefix": func(s, prefix string) bool {
 			return strings.HasPrefix(s, prefix)
 		},
+		"termSlug": models.TermSlug,
 	}
 
 	basePath := filepath.Join(s.Config.TemplateDir, "base.html")
@@ -250,6 +531,8 @@ func (s *Site) loadTemplates() (*TemplateSet, error) {
 		return nil, fmt.Errorf("failed to read base.html: %w", err)
 	}
 
+	s.templateKeys = make(map[*template.Template]string)
+
 	// Load all partials
 	partialFiles, err := filepath.Glob(filepath.Join(s.Config.TemplateDir, "partials", "*.html"))
 	if err != nil {
@@ -265,6 +548,7 @@ func (s *Site) loadTemplates() (*TemplateSet, error) {
 		}
 
 		tmpl := template.New("base.html").Funcs(funcMap)
+		sources := []string{string(baseContent)}
 
 		// Parse base template
 		if _, err := tmpl.Parse(string(baseContent)); err != nil {
@@ -281,12 +565,20 @@ func (s *Site) loadTemplates() (*TemplateSet, error) {
 			if _, err := tmpl.New(partialName).Parse(string(partialContent)); err != nil {
 				return nil, fmt.Errorf("failed to parse partial %s: %w", partialName, err)
 			}
+			sources = append(sources, partialName, string(partialContent))
 		}
 
 		// Parse page template
 		if _, err := tmpl.New(pageName).Parse(string(pageContent)); err != nil {
 			return nil, fmt.Errorf("failed to parse %s: %w", pageName, err)
 		}
+		sources = append(sources, pageName, string(pageContent))
+
+		key := cache.Key(sources...)
+		if bodyRefRegex.MatchString(ownBodyRefs.Replace(strings.Join(sources, "\n"))) {
+			key = cache.Key(key, s.bodiesKey)
+		}
+		s.templateKeys[tmpl] = key
 
 		return tmpl, nil
 	}
@@ -334,6 +626,16 @@ func (s *Site) loadTemplates() (*TemplateSet, error) {
 		ts.Referrals = nil
 	}
 
+	// Tag and category pages are optional
+	ts.Taxonomy, err = parseWithBase("taxonomy.html")
+	if err != nil {
+		ts.Taxonomy = nil
+	}
+	ts.Term, err = parseWithBase("term.html")
+	if err != nil {
+		ts.Term = nil
+	}
+
 	return ts, nil
 }
 
@@ -361,6 +663,11 @@ func (s *Site) generatePages(ts *TemplateSet) error {
 		return err
 	}
 
+	// Generate tag and category pages
+	if err := s.generateTaxonomies(ts); err != nil {
+		return err
+	}
+
 	// Generate collection pages
 	for _, collection := range s.Collections {
 		// Use blog template for main blog with pagination
@@ -401,6 +708,8 @@ type PageData struct {
 	StructuredData  template.JS
 	DevMode         bool
 	User            *models.User
+	Feeds           []FeedLink
+	Tags            []*models.Term // Every tag on the site, most used first
 }
 
 // getSocialImage determines the appropriate Open Graph image for a page
@@ -478,6 +787,8 @@ func (s *Site) generateHome(tmpl *template.Template) error {
 			Collections:     s.Collections,
 			Year:            time.Now().Year(),
 			DevMode:         s.Config.DevMode,
+			Feeds:           s.feedLinks(nil),
+			Tags:            s.Tags.Terms,
 		},
 		Profile:         s.Config.Profile,
 		Referrals:       s.Config.Referrals,
@@ -504,18 +815,17 @@ func (s *Site) generateProfile(tmpl *template.Template) error {
 		return fmt.Errorf("failed to parse profile.md: %w", err)
 	}
 
-	// Create renderer and highlighter
-	renderer := markdown.NewRenderer()
-	highlighter := markdown.NewHighlighter()
-
 	// Process code blocks with syntax highlighting
-	content = markdown.ProcessCodeBlocks(content, highlighter)
+	content = markdown.ProcessCodeBlocks(content, markdown.NewHighlighter())
 
 	// Render markdown to HTML
-	html, err := renderer.Render(content)
+	doc, err := s.renderer.Render(content, markdown.TOCOptions{})
 	if err != nil {
 		return fmt.Errorf("failed to render profile markdown: %w", err)
 	}
+	for _, warning := range doc.Warnings {
+		fmt.Fprintf(os.Stderr, "Warning: %s: %s\n", profilePath, warning)
+	}
 
 	// Use title from frontmatter or default
 	title := fm.Title
@@ -539,12 +849,14 @@ func (s *Site) generateProfile(tmpl *template.Template) error {
 			Collections:     s.Collections,
 			Year:            time.Now().Year(),
 			DevMode:         s.Config.DevMode,
+			Feeds:           s.feedLinks(nil),
+			Tags:            s.Tags.Terms,
 		},
 		Profile: s.Config.Profile,
-		Content: template.HTML(html),
+		Content: template.HTML(doc.HTML),
 	}
 
-	return s.renderPage(tmpl, "profile.html", data)
+	return s.renderPage(tmpl, "profile.html", data, cache.Key(fm.Description, title, doc.HTML))
 }
 
 // generateReferrals creates the /referrals page
@@ -563,6 +875,8 @@ func (s *Site) generateReferrals(tmpl *template.Template) error {
 			Collections:  s.Collections,
 			Year:         time.Now().Year(),
 			DevMode:      s.Config.DevMode,
+			Feeds:        s.feedLinks(nil),
+			Tags:         s.Tags.Terms,
 		},
 		Referrals: s.Config.Referrals,
 	}
@@ -594,6 +908,8 @@ func (s *Site) generateDocsLanding(tmpl *template.Template) error {
 			Collections:  s.Collections,
 			Year:         time.Now().Year(),
 			DevMode:      s.Config.DevMode,
+			Feeds:        s.feedLinks(nil),
+			Tags:         s.Tags.Terms,
 		},
 		DocsCollections: docsCollections,
 	}
@@ -655,6 +971,8 @@ func (s *Site) generateBlogLanding(tmpl *template.Template, collection *models.C
 				Collections:  s.Collections,
 				Year:         time.Now().Year(),
 				DevMode:      s.Config.DevMode,
+				Feeds:        s.feedLinks(collection),
+				Tags:         s.Tags.Terms,
 			},
 			Collection:  collection,
 			Posts:       pagePosts,
@@ -732,6 +1050,8 @@ func (s *Site) generateCollection(tmpl *template.Template, collection *models.Co
 			Collections:  s.Collections,
 			Year:         time.Now().Year(),
 			DevMode:      s.Config.DevMode,
+			Feeds:        s.feedLinks(collection),
+			Tags:         s.Tags.Terms,
 		},
 		Collection: collection,
 	}
@@ -779,6 +1099,8 @@ func (s *Site) generatePost(tmpl *template.Template, collection *models.Collecti
 			Year:           time.Now().Year(),
 			StructuredData: template.JS(sdJSON),
 			DevMode:        s.Config.DevMode,
+			Feeds:          s.feedLinks(collection),
+			Tags:           s.Tags.Terms,
 		},
 		Collection: collection,
 		Post:       post,
@@ -790,11 +1112,20 @@ func (s *Site) generatePost(tmpl *template.Template, collection *models.Collecti
 	}
 
 	outPath := filepath.Join(post.TopicSlug, post.Slug, "index.html")
-	return s.renderPage(tmpl, outPath, data)
+	return s.renderPage(tmpl, outPath, data, hashBody(post))
 }
 
-// renderPage renders a template to a file
-func (s *Site) renderPage(tmpl *template.Template, outPath string, data interface{}) error {
+// renderPage renders a template to a file. deps hash whatever the page's
+// data holds beyond the inputs all pages share, such as a post's body. A
+// page the previous build rendered with the same template and deps is kept
+// as it is.
+func (s *Site) renderPage(tmpl *template.Template, outPath string, data interface{}, deps ...string) error {
+	key := cache.Key(append([]string{s.pagesKey, s.templateKeys[tmpl]}, deps...)...)
+	name := "page:" + filepath.ToSlash(outPath)
+	if _, ok := s.cache.Fresh(name, key); ok && s.out.Keep(outPath) {
+		return nil
+	}
+
 	var buf bytes.Buffer
 
 	// Execute the base template (which includes the content block)
@@ -803,16 +1134,16 @@ func (s *Site) renderPage(tmpl *template.Template, outPath string, data interfac
 	}
 
 	// Write output file
-	fullPath := filepath.Join(s.Config.OutputDir, outPath)
-	if err := os.MkdirAll(filepath.Dir(fullPath), 0755); err != nil {
+	if err := s.out.WriteFile(outPath, buf.Bytes()); err != nil {
 		return err
 	}
-
-	return os.WriteFile(fullPath, buf.Bytes(), 0644)
+	s.cache.Put(name, key, outPath)
+	return nil
 }
 
-// generateSitemap creates sitemap.xml
-func (s *Site) generateSitemap() error {
+// generateSitemap creates sitemap.xml. Tag and category pages are listed
+// only when taxonomyPages says they were generated.
+func (s *Site) generateSitemap(taxonomyPages bool) error {
 	var buf bytes.Buffer
 	buf.WriteString(`<?xml version="1.0" encoding="UTF-8"?>`)
 	buf.WriteString("\n")
@@ -836,8 +1167,18 @@ func (s *Site) generateSitemap() error {
 		}
 	}
 
+	// Tag and category pages
+	if taxonomyPages {
+		for _, taxonomy := range s.taxonomies() {
+			buf.WriteString(fmt.Sprintf("  <url><loc>%s%s</loc></url>\n", s.Config.BaseURL, taxonomy.URL()))
+			for _, term := range taxonomy.Terms {
+				buf.WriteString(fmt.Sprintf("  <url><loc>%s%s</loc></url>\n", s.Config.BaseURL, taxonomy.TermURL(term)))
+			}
+		}
+	}
+
 	buf.WriteString("</urlset>\n")
 
-	sitemapPath := filepath.Join(s.Config.OutputDir, "sitemap.xml")
-	return os.WriteFile(sitemapPath, buf.Bytes(), 0644)
+	s.feedFiles = append(s.feedFiles, "sitemap.xml")
+	return s.out.WriteFile("sitemap.xml", buf.Bytes())
 }
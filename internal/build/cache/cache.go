@@ -0,0 +1,230 @@
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"site/internal/models"
+)
+
+// manifestVersion is bumped whenever the manifest layout changes
+const manifestVersion = 1
+
+const manifestFile = "manifest.json"
+
+// PostEntry is a cached markdown render for a single source file
+type PostEntry struct {
+	Key      string           `json:"key"`
+	HTML     string           `json:"html"`
+	TOC      []models.TOCItem `json:"toc"`
+	Warnings []string         `json:"warnings,omitempty"`
+}
+
+// Entry records the key a piece of work was done with and the outputs it produced
+type Entry struct {
+	Key     string   `json:"key"`
+	Outputs []string `json:"outputs,omitempty"`
+}
+
+type manifest struct {
+	Version int                  `json:"version"`
+	Binary  string               `json:"binary"`
+	Posts   map[string]PostEntry `json:"posts"`
+	Entries map[string]Entry     `json:"entries"`
+}
+
+func newManifest() *manifest {
+	return &manifest{
+		Version: manifestVersion,
+		Binary:  binaryKey(),
+		Posts:   make(map[string]PostEntry),
+		Entries: make(map[string]Entry),
+	}
+}
+
+// Cache is a persistent build cache. It remembers the keys used by the
+// previous build and collects those used by the current one; only the
+// latter are written back by Save, so stale entries age out naturally.
+//
+// A nil *Cache is valid and behaves as an always-empty cache.
+type Cache struct {
+	dir  string
+	mu   sync.Mutex
+	prev *manifest
+	next *manifest
+}
+
+// Open loads the cache stored in dir. A missing or unreadable manifest
+// yields an empty cache rather than an error.
+func Open(dir string) *Cache {
+	c := &Cache{
+		dir:  dir,
+		prev: newManifest(),
+		next: newManifest(),
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, manifestFile))
+	if err != nil {
+		return c
+	}
+
+	var m manifest
+	// Outputs depend on the code that produced them, so a different
+	// binary invalidates everything
+	if err := json.Unmarshal(data, &m); err != nil || m.Version != manifestVersion || m.Binary != c.next.Binary {
+		return c
+	}
+	if m.Posts != nil {
+		c.prev.Posts = m.Posts
+	}
+	if m.Entries != nil {
+		c.prev.Entries = m.Entries
+	}
+	return c
+}
+
+// Post returns the cached render for a source path if it was made with key
+func (c *Cache) Post(path, key string) (PostEntry, bool) {
+	if c == nil {
+		return PostEntry{}, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.prev.Posts[path]
+	if !ok || entry.Key != key {
+		return PostEntry{}, false
+	}
+	c.next.Posts[path] = entry
+	return entry, true
+}
+
+// PutPost records the render for a source path
+func (c *Cache) PutPost(path string, entry PostEntry) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	c.next.Posts[path] = entry
+	c.mu.Unlock()
+}
+
+// Fresh reports whether name was recorded with key by the previous build
+// and returns the outputs it produced. A fresh entry is carried forward.
+func (c *Cache) Fresh(name, key string) ([]string, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.prev.Entries[name]
+	if !ok || entry.Key != key {
+		return nil, false
+	}
+	c.next.Entries[name] = entry
+	return entry.Outputs, true
+}
+
+// Put records that name was produced with key
+func (c *Cache) Put(name, key string, outputs ...string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	c.next.Entries[name] = Entry{Key: key, Outputs: outputs}
+	c.mu.Unlock()
+}
+
+// Save writes the entries used by this build back to disk
+func (c *Cache) Save() error {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	data, err := json.Marshal(c.next)
+	c.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(c.dir, 0755); err != nil {
+		return err
+	}
+
+	// Write atomically so an interrupted build never leaves a torn manifest
+	tmp := filepath.Join(c.dir, manifestFile+".tmp")
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(c.dir, manifestFile))
+}
+
+var (
+	binaryOnce sync.Once
+	binaryHash string
+)
+
+// binaryKey hashes the running executable, or returns "" if it can't be read
+func binaryKey() string {
+	binaryOnce.Do(func() {
+		if path, err := os.Executable(); err == nil {
+			binaryHash = HashFiles(path)
+		}
+	})
+	return binaryHash
+}
+
+// Key hashes its parts into a cache key
+func Key(parts ...string) string {
+	h := sha256.New()
+	for _, p := range parts {
+		fmt.Fprintf(h, "%d:%s;", len(p), p)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// HashFiles hashes the contents of the given files. Missing files are
+// hashed as absent so that creating them later changes the key.
+func HashFiles(paths ...string) string {
+	h := sha256.New()
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			fmt.Fprintf(h, "%s:missing;", path)
+			continue
+		}
+		fmt.Fprintf(h, "%s:%d:", path, len(data))
+		h.Write(data)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// HashTree hashes every file under dir, including their relative paths
+func HashTree(dir string) (string, error) {
+	var files []string
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if !info.IsDir() {
+			files = append(files, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	sort.Strings(files)
+	return HashFiles(files...), nil
+}
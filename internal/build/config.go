@@ -12,6 +12,7 @@ const (
 	DefaultOutputDir   = "dist"
 	DefaultStaticDir   = "static"
 	DefaultTemplateDir = "templates"
+	DefaultCacheDir    = ".cache/build"
 )
 
 type Config struct {
@@ -19,6 +20,7 @@ type Config struct {
 	OutputDir          string
 	StaticDir          string
 	TemplateDir        string
+	CacheDir           string // Empty disables the build cache
 	BaseURL            string
 	SiteName           string
 	SiteDesc           string
@@ -1,12 +1,14 @@
 package content
 
 import (
+	"bytes"
 	"fmt"
 	"os"
 	"path/filepath"
 	"sort"
 	"strings"
 
+	"site/internal/build/cache"
 	"site/internal/build/markdown"
 	"site/internal/models"
 
@@ -18,17 +20,24 @@ type Loader struct {
 	contentDir  string
 	renderer    *markdown.Renderer
 	highlighter *markdown.Highlighter
+	cache       *cache.Cache
 }
 
 // NewLoader creates a new content loader
-func NewLoader(contentDir string) *Loader {
+func NewLoader(contentDir string, renderer *markdown.Renderer) *Loader {
 	return &Loader{
 		contentDir:  contentDir,
-		renderer:    markdown.NewRenderer(),
+		renderer:    renderer,
 		highlighter: markdown.NewHighlighter(),
 	}
 }
 
+// WithCache makes the loader reuse renders of unchanged source files
+func (l *Loader) WithCache(c *cache.Cache) *Loader {
+	l.cache = c
+	return l
+}
+
 // LoadAll loads all collections from the content directory
 func (l *Loader) LoadAll() ([]*models.Collection, error) {
 	var collections []*models.Collection
@@ -104,6 +113,12 @@ func (l *Loader) scanDir(dir, prefix string, collections *[]*models.Collection)
 			slug = prefix + "/" + dirName
 		}
 
+		// Tag and category pages are written to these paths
+		if prefix == "" && (dirName == models.TaxonomyTags || dirName == models.TaxonomyCategories) {
+			fmt.Fprintf(os.Stderr, "Warning: skipping %s: the name is reserved for %s pages\n", fullPath, dirName)
+			continue
+		}
+
 		// Check if this directory is a collection (has _metadata.yml)
 		metaPath := filepath.Join(fullPath, "_metadata.yml")
 		if _, err := os.Stat(metaPath); err == nil {
@@ -232,17 +247,18 @@ func (l *Loader) loadPost(path, collectionSlug string) (*models.Post, error) {
 		return nil, err
 	}
 
-	// Extract TOC before processing
-	toc := markdown.ExtractTOC(content)
+	// Blank lines in place of the frontmatter keep the body at its line in
+	// the file, so warnings point at the right line
+	padding := strings.Repeat("\n", bytes.Count(data, []byte("\n"))-strings.Count(content, "\n"))
 
-	// Process code blocks with syntax highlighting
-	content = markdown.ProcessCodeBlocks(content, l.highlighter)
-
-	// Render markdown to HTML
-	html, err := l.renderer.Render(content)
+	tocOpts := markdown.TOCOptions{MinLevel: fm.TOCMinDepth, MaxLevel: fm.TOCMaxDepth}
+	doc, err := l.render(path, data, padding+content, tocOpts)
 	if err != nil {
 		return nil, err
 	}
+	for _, warning := range doc.Warnings {
+		fmt.Fprintf(os.Stderr, "Warning: %s: %s\n", path, warning)
+	}
 
 	slug := strings.TrimSuffix(filepath.Base(path), ".md")
 	url := "/" + collectionSlug + "/" + slug
@@ -254,12 +270,16 @@ func (l *Loader) loadPost(path, collectionSlug string) (*models.Post, error) {
 		Updated:        markdown.ParseDate(fm.Updated),
 		Draft:          fm.Draft,
 		Order:          fm.Order,
+		Tags:           fm.Tags,
+		Categories:     fm.Categories,
+		Comments:       fm.Comments == nil || *fm.Comments,
+		Reactions:      fm.Reactions == nil || *fm.Reactions,
 		Slug:           slug,
 		CollectionSlug: collectionSlug,
 		TopicSlug:      collectionSlug, // backward compatibility
 		URL:            url,
-		Content:        html,
-		TOC:            toc,
+		Content:        doc.HTML,
+		TOC:            doc.TOC,
 	}
 
 	if post.Title == "" {
@@ -268,3 +288,24 @@ func (l *Loader) loadPost(path, collectionSlug string) (*models.Post, error) {
 
 	return post, nil
 }
+
+// render converts a post body to HTML, reusing the cached result when the
+// source file and renderer are unchanged since the last build
+func (l *Loader) render(path string, source []byte, content string, tocOpts markdown.TOCOptions) (*markdown.Document, error) {
+	key := cache.Key(l.renderer.Key(), string(source))
+	if entry, ok := l.cache.Post(path, key); ok {
+		return &markdown.Document{HTML: entry.HTML, TOC: entry.TOC, Warnings: entry.Warnings}, nil
+	}
+
+	// Process code blocks with syntax highlighting
+	content = markdown.ProcessCodeBlocks(content, l.highlighter)
+
+	// Render markdown to HTML, collecting the TOC from the same parse
+	doc, err := l.renderer.Render(content, tocOpts)
+	if err != nil {
+		return nil, err
+	}
+
+	l.cache.PutPost(path, cache.PostEntry{Key: key, HTML: doc.HTML, TOC: doc.TOC, Warnings: doc.Warnings})
+	return doc, nil
+}
@@ -0,0 +1,344 @@
+package feed
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"html"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Format describes one syndication format and the file it is written to
+type Format struct {
+	Name     string
+	File     string
+	MIMEType string
+	encode   func(f *Feed, selfURL string) ([]byte, error)
+}
+
+// Formats lists every format generated for a feed
+var Formats = []Format{
+	{Name: "Atom", File: "atom.xml", MIMEType: "application/atom+xml", encode: Atom},
+	{Name: "RSS", File: "rss.xml", MIMEType: "application/rss+xml", encode: RSS},
+	{Name: "JSON Feed", File: "feed.json", MIMEType: "application/feed+json", encode: JSON},
+}
+
+// Encode renders f in this format; selfURL is the absolute URL of the document
+func (ft Format) Encode(f *Feed, selfURL string) ([]byte, error) {
+	return ft.encode(f, selfURL)
+}
+
+// Feed is a format-neutral syndication feed
+type Feed struct {
+	Title       string
+	Description string
+	PageURL     string // Absolute URL of the HTML page the feed mirrors
+	Author      string
+	Items       []Item
+}
+
+// Item is a single entry in a feed. Atom requires every entry to have a
+// date, so Published or Updated must be set.
+type Item struct {
+	ID          string
+	Title       string
+	Summary     string
+	ContentHTML string
+	URL         string
+	Published   time.Time
+	Updated     time.Time
+}
+
+// Modified returns the updated date, falling back to the published date
+func (i Item) Modified() time.Time {
+	if !i.Updated.IsZero() {
+		return i.Updated
+	}
+	return i.Published
+}
+
+// LastModified returns the most recent modification date of any item
+func (f *Feed) LastModified() time.Time {
+	var latest time.Time
+	for _, item := range f.Items {
+		if m := item.Modified(); m.After(latest) {
+			latest = m
+		}
+	}
+	return latest
+}
+
+// Atom feed (RFC 4287)
+
+type atomFeed struct {
+	XMLName  xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Title    string      `xml:"title"`
+	Subtitle string      `xml:"subtitle,omitempty"`
+	ID       string      `xml:"id"`
+	Updated  string      `xml:"updated"`
+	Links    []atomLink  `xml:"link"`
+	Author   *atomAuthor `xml:"author,omitempty"`
+	Entries  []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+}
+
+type atomText struct {
+	Type string `xml:"type,attr"`
+	Body string `xml:",chardata"`
+}
+
+type atomEntry struct {
+	Title     string    `xml:"title"`
+	ID        string    `xml:"id"`
+	Link      atomLink  `xml:"link"`
+	Published string    `xml:"published,omitempty"`
+	Updated   string    `xml:"updated"`
+	Summary   *atomText `xml:"summary,omitempty"`
+	Content   *atomText `xml:"content,omitempty"`
+}
+
+// Atom encodes f as an Atom 1.0 document
+func Atom(f *Feed, selfURL string) ([]byte, error) {
+	doc := atomFeed{
+		Title:    f.Title,
+		Subtitle: f.Description,
+		ID:       f.PageURL,
+		Updated:  formatRFC3339(f.LastModified()),
+		Links: []atomLink{
+			{Href: f.PageURL, Rel: "alternate", Type: "text/html"},
+			{Href: selfURL, Rel: "self", Type: "application/atom+xml"},
+		},
+	}
+	if f.Author != "" {
+		doc.Author = &atomAuthor{Name: f.Author}
+	}
+
+	for _, item := range f.Items {
+		entry := atomEntry{
+			Title:   item.Title,
+			ID:      item.ID,
+			Link:    atomLink{Href: item.URL, Rel: "alternate", Type: "text/html"},
+			Updated: formatRFC3339(item.Modified()),
+		}
+		if !item.Published.IsZero() {
+			entry.Published = formatRFC3339(item.Published)
+		}
+		if item.Summary != "" {
+			entry.Summary = &atomText{Type: "text", Body: item.Summary}
+		}
+		if item.ContentHTML != "" {
+			entry.Content = &atomText{Type: "html", Body: item.ContentHTML}
+		}
+		doc.Entries = append(doc.Entries, entry)
+	}
+
+	return marshalXML(doc)
+}
+
+// RSS 2.0 feed
+
+type rssDoc struct {
+	XMLName   xml.Name   `xml:"rss"`
+	Version   string     `xml:"version,attr"`
+	AtomNS    string     `xml:"xmlns:atom,attr"`
+	ContentNS string     `xml:"xmlns:content,attr"`
+	Channel   rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title         string    `xml:"title"`
+	Link          string    `xml:"link"`
+	Description   string    `xml:"description"`
+	LastBuildDate string    `xml:"lastBuildDate,omitempty"`
+	AtomLink      rssAtom   `xml:"atom:link"`
+	Items         []rssItem `xml:"item"`
+}
+
+type rssAtom struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr"`
+	Type string `xml:"type,attr"`
+}
+
+type rssGUID struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	Value       string `xml:",chardata"`
+}
+
+type rssItem struct {
+	Title       string  `xml:"title"`
+	Link        string  `xml:"link"`
+	GUID        rssGUID `xml:"guid"`
+	PubDate     string  `xml:"pubDate,omitempty"`
+	Description string  `xml:"description,omitempty"`
+	Content     *cdata  `xml:"content:encoded,omitempty"`
+}
+
+type cdata struct {
+	Value string `xml:",cdata"`
+}
+
+// RSS encodes f as an RSS 2.0 document with full content in content:encoded
+func RSS(f *Feed, selfURL string) ([]byte, error) {
+	doc := rssDoc{
+		Version:   "2.0",
+		AtomNS:    "http://www.w3.org/2005/Atom",
+		ContentNS: "http://purl.org/rss/1.0/modules/content/",
+		Channel: rssChannel{
+			Title:       f.Title,
+			Link:        f.PageURL,
+			Description: f.Description,
+			AtomLink:    rssAtom{Href: selfURL, Rel: "self", Type: "application/rss+xml"},
+		},
+	}
+	if updated := f.LastModified(); !updated.IsZero() {
+		doc.Channel.LastBuildDate = updated.UTC().Format(time.RFC1123Z)
+	}
+
+	for _, item := range f.Items {
+		ri := rssItem{
+			Title:       item.Title,
+			Link:        item.URL,
+			GUID:        rssGUID{IsPermaLink: item.ID == item.URL, Value: item.ID},
+			Description: item.Summary,
+		}
+		if !item.Published.IsZero() {
+			ri.PubDate = item.Published.UTC().Format(time.RFC1123Z)
+		}
+		if item.ContentHTML != "" {
+			// A CDATA section cannot contain its own terminator
+			ri.Content = &cdata{Value: strings.ReplaceAll(item.ContentHTML, "]]>", "]]]]><![CDATA[>")}
+		}
+		doc.Channel.Items = append(doc.Channel.Items, ri)
+	}
+
+	return marshalXML(doc)
+}
+
+// JSON Feed 1.1 (https://jsonfeed.org/version/1.1)
+
+type jsonFeed struct {
+	Version     string       `json:"version"`
+	Title       string       `json:"title"`
+	HomePageURL string       `json:"home_page_url,omitempty"`
+	FeedURL     string       `json:"feed_url,omitempty"`
+	Description string       `json:"description,omitempty"`
+	Authors     []jsonAuthor `json:"authors,omitempty"`
+	Items       []jsonItem   `json:"items"`
+}
+
+type jsonAuthor struct {
+	Name string `json:"name"`
+}
+
+type jsonItem struct {
+	ID            string `json:"id"`
+	URL           string `json:"url,omitempty"`
+	Title         string `json:"title,omitempty"`
+	ContentHTML   string `json:"content_html,omitempty"`
+	Summary       string `json:"summary,omitempty"`
+	DatePublished string `json:"date_published,omitempty"`
+	DateModified  string `json:"date_modified,omitempty"`
+}
+
+// JSON encodes f as a JSON Feed 1.1 document
+func JSON(f *Feed, selfURL string) ([]byte, error) {
+	doc := jsonFeed{
+		Version:     "https://jsonfeed.org/version/1.1",
+		Title:       f.Title,
+		HomePageURL: f.PageURL,
+		FeedURL:     selfURL,
+		Description: f.Description,
+		Items:       []jsonItem{},
+	}
+	if f.Author != "" {
+		doc.Authors = []jsonAuthor{{Name: f.Author}}
+	}
+
+	for _, item := range f.Items {
+		ji := jsonItem{
+			ID:          item.ID,
+			URL:         item.URL,
+			Title:       item.Title,
+			ContentHTML: item.ContentHTML,
+			Summary:     item.Summary,
+		}
+		if !item.Published.IsZero() {
+			ji.DatePublished = formatRFC3339(item.Published)
+		}
+		if !item.Updated.IsZero() {
+			ji.DateModified = formatRFC3339(item.Updated)
+		}
+		doc.Items = append(doc.Items, ji)
+	}
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func marshalXML(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+	enc := xml.NewEncoder(&buf)
+	enc.Indent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	buf.WriteString("\n")
+	return buf.Bytes(), nil
+}
+
+func formatRFC3339(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+var (
+	tagRegex = regexp.MustCompile(`<[a-zA-Z][^>]*>`)
+	// urlAttr matches an href, src or poster attribute with a double-quoted,
+	// single-quoted or unquoted value
+	urlAttr = regexp.MustCompile(`(?i)(\s(?:href|src|poster)\s*=\s*)(?:"([^"]*)"|'([^']*)'|([^\s"'>]+))`)
+)
+
+// AbsoluteURLs resolves the links in html against pageURL, the absolute
+// URL of the page it comes from, so content still works when read outside
+// the site, e.g. in a feed reader. Root-relative, document-relative and
+// fragment-only links are all rewritten; absolute ones are left alone.
+func AbsoluteURLs(content, pageURL string) string {
+	base, err := url.Parse(pageURL)
+	if err != nil {
+		return content
+	}
+
+	return tagRegex.ReplaceAllStringFunc(content, func(tag string) string {
+		return urlAttr.ReplaceAllStringFunc(tag, func(attr string) string {
+			m := urlAttr.FindStringSubmatch(attr)
+			raw := html.UnescapeString(m[2] + m[3] + m[4])
+			ref, err := url.Parse(strings.TrimSpace(raw))
+			if err != nil || ref.IsAbs() {
+				return attr
+			}
+			return m[1] + `"` + html.EscapeString(base.ResolveReference(ref).String()) + `"`
+		})
+	})
+}
@@ -0,0 +1,183 @@
+package build
+
+import (
+	"path"
+	"path/filepath"
+	"sort"
+
+	"site/internal/build/feed"
+	"site/internal/models"
+)
+
+// feedItemLimit caps the number of entries in each feed
+const feedItemLimit = 20
+
+// FeedLink describes a feed advertised with <link rel="alternate">
+type FeedLink struct {
+	Title string
+	Type  string
+	URL   string
+}
+
+// generateFeeds writes site-wide feeds at the root and one set per
+// collection and term that has dated posts
+func (s *Site) generateFeeds() error {
+	if err := s.writeFeeds("", s.Config.SiteName, s.Config.SiteDesc, s.Config.BaseURL, s.allPosts()); err != nil {
+		return err
+	}
+
+	for _, collection := range s.Collections {
+		title := collection.Name + " | " + s.Config.SiteName
+		pageURL := s.Config.BaseURL + "/" + collection.Slug
+		if err := s.writeFeeds(collection.Slug, title, collection.Description, pageURL, collectionFeedPosts(collection)); err != nil {
+			return err
+		}
+	}
+
+	// Tag and category scoped feeds
+	for _, taxonomy := range s.taxonomies() {
+		for _, term := range taxonomy.Terms {
+			title := term.Name + " | " + s.Config.SiteName
+			pageURL := s.Config.BaseURL + taxonomy.TermURL(term)
+			if err := s.writeFeeds(termDir(taxonomy, term), title, "", pageURL, term.Posts); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// allPosts returns the posts of every collection
+func (s *Site) allPosts() []*models.Post {
+	var posts []*models.Post
+	for _, collection := range s.Collections {
+		posts = append(posts, collection.Posts...)
+	}
+	return posts
+}
+
+// datedPosts returns the posts with a date. Undated posts, like most docs
+// pages, have nothing to order them or fill a feed's required dates with,
+// so feeds leave them out.
+func datedPosts(posts []*models.Post) []*models.Post {
+	var dated []*models.Post
+	for _, post := range posts {
+		if !post.Date.IsZero() {
+			dated = append(dated, post)
+		}
+	}
+	return dated
+}
+
+// planFeeds records which feeds have dated posts to list, so pages only
+// advertise feeds that exist
+func (s *Site) planFeeds() {
+	s.feedDirs = make(map[string]bool)
+	plan := func(dir string, posts []*models.Post) {
+		if len(datedPosts(posts)) > 0 {
+			s.feedDirs[dir] = true
+		}
+	}
+
+	plan("", s.allPosts())
+	for _, collection := range s.Collections {
+		plan(collection.Slug, collectionFeedPosts(collection))
+	}
+	for _, taxonomy := range s.taxonomies() {
+		for _, term := range taxonomy.Terms {
+			plan(termDir(taxonomy, term), term.Posts)
+		}
+	}
+}
+
+// collectionFeedPosts returns a collection's posts along with those of its
+// child series, so subscribing to the blog also covers every series in it
+func collectionFeedPosts(collection *models.Collection) []*models.Post {
+	posts := append([]*models.Post(nil), collection.Posts...)
+	for _, child := range collection.ChildSeries {
+		posts = append(posts, collectionFeedPosts(child)...)
+	}
+	return posts
+}
+
+// writeFeeds writes every feed format for the dated posts into dir
+// (relative to the output root), or nothing if none is dated
+func (s *Site) writeFeeds(dir, title, description, pageURL string, posts []*models.Post) error {
+	if !s.feedDirs[dir] {
+		return nil
+	}
+	sorted := datedPosts(posts)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Date.After(sorted[j].Date)
+	})
+	if len(sorted) > feedItemLimit {
+		sorted = sorted[:feedItemLimit]
+	}
+
+	f := &feed.Feed{
+		Title:       title,
+		Description: description,
+		PageURL:     pageURL,
+		Author:      s.Config.SiteName,
+	}
+	for _, post := range sorted {
+		postURL := s.Config.BaseURL + post.URL
+		f.Items = append(f.Items, feed.Item{
+			ID:          postURL,
+			Title:       post.Title,
+			Summary:     post.Description,
+			ContentHTML: feed.AbsoluteURLs(post.Content, postURL),
+			URL:         postURL,
+			Published:   post.Date,
+			Updated:     post.Updated,
+		})
+	}
+
+	for _, format := range feed.Formats {
+		data, err := format.Encode(f, s.feedURL(dir, format))
+		if err != nil {
+			return err
+		}
+
+		outPath := filepath.Join(dir, format.File)
+		s.feedFiles = append(s.feedFiles, outPath)
+		if err := s.out.WriteFile(outPath, data); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// feedURL returns the absolute URL of a feed written to dir
+func (s *Site) feedURL(dir string, format feed.Format) string {
+	return s.Config.BaseURL + path.Join("/", filepath.ToSlash(dir), format.File)
+}
+
+// feedLinks returns the feeds a page should advertise: the site-wide feeds
+// and, when the page belongs to a collection, that collection's feeds
+func (s *Site) feedLinks(collection *models.Collection) []FeedLink {
+	links := s.feedLinksFor(s.Config.SiteName, "")
+	if collection != nil {
+		links = append(links, s.feedLinksFor(collection.Name, collection.Slug)...)
+	}
+	return links
+}
+
+// feedLinksFor returns one link per format for the feeds written to dir,
+// or none if dir has no feed
+func (s *Site) feedLinksFor(title, dir string) []FeedLink {
+	if !s.feedDirs[dir] {
+		return nil
+	}
+	var links []FeedLink
+	for _, format := range feed.Formats {
+		links = append(links, FeedLink{
+			Title: title + " (" + format.Name + ")",
+			Type:  format.MIMEType,
+			URL:   s.feedURL(dir, format),
+		})
+	}
+	return links
+}
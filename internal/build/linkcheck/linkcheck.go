@@ -0,0 +1,310 @@
+package linkcheck
+
+import (
+	"fmt"
+	"html"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"site/internal/build/manifest"
+)
+
+// DefaultIgnore lists paths served dynamically by the server rather than
+// from the output directory. Each entry covers the path itself and
+// everything below it, so "/github" skips /github but not /github-actions.
+var DefaultIgnore = []string{"/api", "/auth", "/ws", "/github", "/linkedin", "/email", "/webmention", "/ap", "/newsletter", "/.well-known"}
+
+// Broken is a reference that does not resolve to a file or anchor
+type Broken struct {
+	File   string // HTML file containing the reference, relative to the output dir
+	Line   int
+	URL    string
+	Reason string
+
+	// Source is the markdown file the reference was written in, when the
+	// page is a post whose source contains it, and SourceLine its line
+	Source     string
+	SourceLine int
+}
+
+// String reports the markdown source when it is known, since that is what
+// needs fixing, and the generated file otherwise
+func (b Broken) String() string {
+	if b.Source != "" {
+		return fmt.Sprintf("%s:%d: %s (%s)", b.Source, b.SourceLine, b.URL, b.Reason)
+	}
+	return fmt.Sprintf("%s:%d: %s (%s)", b.File, b.Line, b.URL, b.Reason)
+}
+
+// Checker validates internal links in a generated site
+type Checker struct {
+	OutputDir  string
+	ContentDir string   // Markdown sources of the posts, "" to report generated files only
+	BaseURL    string   // Absolute links under this URL are treated as internal
+	Ignore     []string // Paths that are not checked, with everything below them
+}
+
+var (
+	commentRegex = regexp.MustCompile(`(?s)<!--.*?-->`)
+	tagRegex     = regexp.MustCompile(`(?s)<[a-zA-Z][^>]*>`)
+	attrRegex    = regexp.MustCompile(`(?is)\s([a-z][a-z0-9-]*)\s*=\s*(?:"([^"]*)"|'([^']*)'|([^\s"'>]+))`)
+)
+
+// page is a parsed HTML file
+type page struct {
+	rel    string
+	lines  []int // Offsets of each newline, for mapping matches to lines
+	ids    map[string]bool
+	refs   []ref
+	source string // Markdown source of a post page, "" for other pages
+}
+
+// ref is an href or src value and the byte offset of its tag
+type ref struct {
+	url    string
+	offset int
+}
+
+// Check crawls every HTML file in the output directory and returns the
+// broken references sorted by file and line
+func (c *Checker) Check() ([]Broken, error) {
+	files := make(map[string]bool)
+	var htmlFiles []string
+
+	err := filepath.Walk(c.OutputDir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(c.OutputDir, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		files[rel] = true
+		if strings.HasSuffix(rel, ".html") {
+			htmlFiles = append(htmlFiles, rel)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// The manifest tells posts from other pages. It is missing if the
+	// output was built before manifests, or not by this site.
+	m, err := manifest.Load(filepath.Join(c.OutputDir, manifest.File))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	pages := make(map[string]*page, len(htmlFiles))
+	for _, rel := range htmlFiles {
+		data, err := os.ReadFile(filepath.Join(c.OutputDir, filepath.FromSlash(rel)))
+		if err != nil {
+			return nil, err
+		}
+		pages[rel] = parsePage(rel, string(data))
+
+		// A post's ID is its path under the content directory
+		id := strings.TrimPrefix(pageURL(rel), "/")
+		if _, ok := m.Post(id); ok && c.ContentDir != "" {
+			pages[rel].source = filepath.Join(c.ContentDir, filepath.FromSlash(id)+".md")
+		}
+	}
+
+	var broken []Broken
+	for _, rel := range htmlFiles {
+		broken = append(broken, c.checkPage(pages[rel], files, pages)...)
+	}
+	locateSources(broken, pages)
+
+	sort.SliceStable(broken, func(i, j int) bool {
+		if broken[i].File != broken[j].File {
+			return broken[i].File < broken[j].File
+		}
+		return broken[i].Line < broken[j].Line
+	})
+
+	return broken, nil
+}
+
+// parsePage collects the anchors and references of an HTML file. Attribute
+// values may be double-quoted, single-quoted or unquoted; tags inside
+// comments are skipped.
+func parsePage(rel, content string) *page {
+	p := &page{rel: rel, ids: make(map[string]bool)}
+	for i, ch := range content {
+		if ch == '\n' {
+			p.lines = append(p.lines, i)
+		}
+	}
+
+	comments := commentRegex.FindAllStringIndex(content, -1)
+	for _, t := range tagRegex.FindAllStringIndex(content, -1) {
+		if inRanges(t[0], comments) {
+			continue
+		}
+		for _, m := range attrRegex.FindAllStringSubmatch(content[t[0]:t[1]], -1) {
+			value := html.UnescapeString(m[2] + m[3] + m[4])
+			switch strings.ToLower(m[1]) {
+			case "id", "name":
+				p.ids[value] = true
+			case "href", "src":
+				p.refs = append(p.refs, ref{url: value, offset: t[0]})
+			}
+		}
+	}
+	return p
+}
+
+// inRanges reports whether offset falls inside one of the sorted ranges
+func inRanges(offset int, ranges [][]int) bool {
+	i := sort.Search(len(ranges), func(i int) bool { return ranges[i][1] > offset })
+	return i < len(ranges) && ranges[i][0] <= offset
+}
+
+// lineAt returns the 1-based line number of a byte offset
+func (p *page) lineAt(offset int) int {
+	return sort.SearchInts(p.lines, offset) + 1
+}
+
+// checkPage validates every href and src in a single page
+func (c *Checker) checkPage(p *page, files map[string]bool, pages map[string]*page) []Broken {
+	var broken []Broken
+
+	for _, r := range p.refs {
+		raw := r.url
+		line := p.lineAt(r.offset)
+
+		target, fragment, ok := c.resolve(p.rel, raw)
+		if !ok {
+			continue
+		}
+
+		file, found := lookup(target, files)
+		if !found {
+			broken = append(broken, Broken{File: p.rel, Line: line, URL: raw, Reason: "not found"})
+			continue
+		}
+
+		if fragment == "" {
+			continue
+		}
+		if tp, ok := pages[file]; ok && !tp.ids[fragment] {
+			broken = append(broken, Broken{File: p.rel, Line: line, URL: raw, Reason: "missing anchor #" + fragment})
+		}
+	}
+
+	return broken
+}
+
+// locateSources points each broken reference on a post page at the line of
+// its markdown source that contains the URL. References the source doesn't
+// contain come from the template and keep their generated location.
+func locateSources(broken []Broken, pages map[string]*page) {
+	sources := make(map[string][]string)
+	for i := range broken {
+		source := pages[broken[i].File].source
+		if source == "" {
+			continue
+		}
+		lines, ok := sources[source]
+		if !ok {
+			data, err := os.ReadFile(source)
+			if err == nil {
+				lines = strings.Split(string(data), "\n")
+			}
+			sources[source] = lines
+		}
+		for n, line := range lines {
+			if strings.Contains(line, broken[i].URL) {
+				broken[i].Source = source
+				broken[i].SourceLine = n + 1
+				break
+			}
+		}
+	}
+}
+
+// resolve turns a reference found in page rel into a site path and
+// fragment. It returns false for references that are not checked.
+func (c *Checker) resolve(rel, raw string) (string, string, bool) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" || raw == "#" {
+		return "", "", false
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", "", false
+	}
+
+	switch {
+	case u.Scheme != "" || u.Host != "":
+		base, err := url.Parse(c.BaseURL)
+		if c.BaseURL == "" || err != nil || !strings.EqualFold(u.Host, base.Host) {
+			return "", "", false // External (or mailto:, data:, javascript:)
+		}
+	case u.Path == "":
+		// Same-page fragment
+		return pageURL(rel), u.Fragment, true
+	case !strings.HasPrefix(u.Path, "/"):
+		u.Path = path.Join(path.Dir(pageURL(rel)), u.Path)
+	}
+
+	target := path.Clean("/" + u.Path)
+	if c.ignored(target) {
+		return "", "", false
+	}
+
+	return target, u.Fragment, true
+}
+
+// ignored reports whether target is an ignored path or below one. A
+// trailing slash on an entry is optional.
+func (c *Checker) ignored(target string) bool {
+	for _, p := range c.Ignore {
+		p = strings.TrimSuffix(p, "/")
+		if target == p || strings.HasPrefix(target, p+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// pageURL returns the URL a generated file is served at, e.g.
+// blog/welcome/index.html -> /blog/welcome
+func pageURL(rel string) string {
+	rel = strings.TrimSuffix(rel, "index.html")
+	rel = strings.TrimSuffix(rel, ".html")
+	return path.Clean("/" + rel)
+}
+
+// lookup maps a site path to a file the same way the server does:
+// exact file, then dir/index.html, then path.html
+func lookup(target string, files map[string]bool) (string, bool) {
+	rel := strings.TrimPrefix(target, "/")
+	candidates := []string{
+		rel,
+		path.Join(rel, "index.html"),
+		rel + ".html",
+	}
+	if rel == "" {
+		candidates = []string{"index.html"}
+	}
+
+	for _, candidate := range candidates {
+		if files[candidate] {
+			return candidate, true
+		}
+	}
+	return "", false
+}
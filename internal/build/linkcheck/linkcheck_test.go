@@ -0,0 +1,69 @@
+package linkcheck
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeSite creates an output directory holding files by path
+func writeSite(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestCheckIgnore(t *testing.T) {
+	dir := writeSite(t, map[string]string{
+		"index.html": `<a href="/github">GitHub</a> <a href="/api/comments">api</a>
+<a href="/github-actions/x">actions</a> <a href="/wsl-setup">wsl</a> <a href="/emails/weekly">emails</a>`,
+	})
+
+	c := &Checker{OutputDir: dir, Ignore: DefaultIgnore}
+	broken, err := c.Check()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, b := range broken {
+		got = append(got, b.URL)
+	}
+	want := []string{"/github-actions/x", "/wsl-setup", "/emails/weekly"}
+	if len(got) != len(want) {
+		t.Fatalf("broken = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("broken[%d] = %q, want %q", i, got[i], want[i])
+		}
+	}
+}
+
+func TestCheckQuoting(t *testing.T) {
+	dir := writeSite(t, map[string]string{
+		"index.html": `<h2 id=top>Top</h2>
+<a href='/missing'>single</a>
+<img src=/missing.png>
+<!-- <a href="/commented"> -->
+<a href=#top>ok</a> <a href="about">ok</a>`,
+		"about.html": `<p>About</p>`,
+	})
+
+	broken, err := (&Checker{OutputDir: dir}).Check()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(broken) != 2 || broken[0].URL != "/missing" || broken[0].Line != 2 || broken[1].URL != "/missing.png" {
+		t.Fatalf("broken = %v", broken)
+	}
+}
@@ -0,0 +1,81 @@
+package manifest
+
+import (
+	"encoding/json"
+	"os"
+	"time"
+
+	"site/internal/models"
+)
+
+// File is the manifest's name inside the output directory. The leading
+// underscore keeps it from being served as a page.
+const File = "_manifest.json"
+
+// Post holds the per-post details the server needs. The post's URL is
+// "/" + its ID.
+type Post struct {
+	Comments    bool      `json:"comments"`
+	Reactions   bool      `json:"reactions"`
+	Title       string    `json:"title,omitempty"`
+	Description string    `json:"description,omitempty"`
+	Date        time.Time `json:"date,omitzero"`
+	Series      bool      `json:"series,omitempty"` // Dated post in a blog-style collection
+	Docs        bool      `json:"docs,omitempty"`   // Page of a docs-style collection
+}
+
+// Manifest lists every published post by ID ("collection/slug")
+type Manifest struct {
+	Posts map[string]Post `json:"posts"`
+}
+
+// New builds a manifest from the loaded collections
+func New(collections []*models.Collection) *Manifest {
+	m := &Manifest{Posts: make(map[string]Post)}
+	for _, collection := range collections {
+		for _, post := range collection.Posts {
+			m.Posts[post.ID()] = Post{
+				Comments:    post.Comments,
+				Reactions:   post.Reactions,
+				Title:       post.Title,
+				Description: post.Description,
+				Date:        post.Date,
+				Series:      collection.IsSeries(),
+				Docs:        collection.IsDocs(),
+			}
+		}
+	}
+	return m
+}
+
+// Post looks up a post by ID. A nil manifest knows no posts.
+func (m *Manifest) Post(id string) (Post, bool) {
+	if m == nil {
+		return Post{}, false
+	}
+	p, ok := m.Posts[id]
+	return p, ok
+}
+
+// Marshal encodes the manifest. Map keys are sorted by encoding/json, so
+// an unchanged site produces identical bytes.
+func (m *Manifest) Marshal() ([]byte, error) {
+	return json.MarshalIndent(m, "", "  ")
+}
+
+// Load reads a manifest written by the build
+func Load(path string) (*Manifest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var m Manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, err
+	}
+	if m.Posts == nil {
+		m.Posts = make(map[string]Post)
+	}
+	return &m, nil
+}
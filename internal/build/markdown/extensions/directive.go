@@ -0,0 +1,644 @@
+package extensions
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"html/template"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+// DirectiveType distinguishes the three directive syntaxes
+type DirectiveType int
+
+const (
+	// ContainerDirective wraps markdown content:
+	//
+	//	:::name[label]{key="value"}
+	//	content
+	//	:::
+	//
+	// Use more colons on the outer fence to nest containers of the same depth
+	// unambiguously, e.g. ::::outer ... :::inner ... ::: ... ::::
+	ContainerDirective DirectiveType = iota
+	// LeafDirective is a single line block: ::name[label]{key="value"}
+	LeafDirective
+	// InlineDirective appears within text: :name[label]{key="value"}
+	InlineDirective
+)
+
+// Directive is the data passed to handlers and directive templates
+type Directive struct {
+	Type    DirectiveType
+	Name    string
+	Label   string
+	Attrs   map[string]string
+	Content template.HTML // Rendered children of a container directive
+}
+
+// Attr returns an attribute value, or fallback if it is not set
+func (d *Directive) Attr(key, fallback string) string {
+	if v, ok := d.Attrs[key]; ok && v != "" {
+		return v
+	}
+	return fallback
+}
+
+// DirectiveHandler renders a directive to HTML
+type DirectiveHandler func(d *Directive) (string, error)
+
+// Directives is a registry of directive handlers and templates
+type Directives struct {
+	handlers  map[string]DirectiveHandler
+	leaves    map[string]bool
+	required  map[string][]string // Attributes a handler can't render without
+	templates map[string]*template.Template
+	sources   map[string][]byte
+}
+
+// NewDirectives creates a registry with the built-in directives
+func NewDirectives() *Directives {
+	d := &Directives{
+		handlers:  make(map[string]DirectiveHandler),
+		leaves:    make(map[string]bool),
+		required:  make(map[string][]string),
+		templates: make(map[string]*template.Template),
+		sources:   make(map[string][]byte),
+	}
+	d.Register("aside", renderAside)
+	d.RegisterLeaf("pdf", renderPDF)
+	d.Require("pdf", "src")
+	return d
+}
+
+// Register adds a handler for a directive name
+func (d *Directives) Register(name string, h DirectiveHandler) {
+	d.handlers[name] = h
+}
+
+// RegisterLeaf adds a handler for a directive that never has content, so
+// it is also accepted with three colons and no closing fence (:::pdf{...})
+func (d *Directives) RegisterLeaf(name string, h DirectiveHandler) {
+	d.handlers[name] = h
+	d.leaves[name] = true
+}
+
+// Require lists attributes a handler needs. A directive missing one is
+// reported as a warning and renders nothing, like an unknown directive
+// does not fail the build.
+func (d *Directives) Require(name string, attrs ...string) {
+	d.required[name] = append(d.required[name], attrs...)
+}
+
+// LoadTemplates registers every <name>.html in dir as the template for
+// directive <name>, taking precedence over a built-in handler of the same
+// name. A missing directory is not an error.
+func (d *Directives) LoadTemplates(dir string) error {
+	files, err := filepath.Glob(filepath.Join(dir, "*.html"))
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		name := strings.TrimSuffix(filepath.Base(file), ".html")
+		tmpl, err := template.New(name).Parse(string(data))
+		if err != nil {
+			return fmt.Errorf("failed to parse directive template %s: %w", file, err)
+		}
+		d.templates[name] = tmpl
+		d.sources[name] = data
+	}
+
+	return nil
+}
+
+// Known reports whether a directive name has a handler or template
+func (d *Directives) Known(name string) bool {
+	_, ok := d.handlers[name]
+	if !ok {
+		_, ok = d.templates[name]
+	}
+	return ok
+}
+
+// missing returns the first required attribute dir lacks, or "". A
+// template replaces the handler, so its directives require nothing.
+func (d *Directives) missing(dir *Directive) string {
+	if _, ok := d.templates[dir.Name]; ok {
+		return ""
+	}
+	for _, attr := range d.required[dir.Name] {
+		if dir.Attrs[attr] == "" {
+			return attr
+		}
+	}
+	return ""
+}
+
+// check warns about a directive that can't be rendered as written. line
+// is its line in the source.
+func (d *Directives) check(pc parser.Context, dir *Directive, line int) {
+	if !d.Known(dir.Name) {
+		warnDirective(pc, "line %d: unknown directive %q", line, dir.Name)
+		return
+	}
+	if attr := d.missing(dir); attr != "" {
+		warnDirective(pc, "line %d: directive %q needs a %s attribute and was left out", line, dir.Name, attr)
+	}
+}
+
+// Fingerprint identifies the loaded templates, so renders can be cached
+// against them
+func (d *Directives) Fingerprint() string {
+	names := make([]string, 0, len(d.sources))
+	for name := range d.sources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	h := sha256.New()
+	for _, name := range names {
+		fmt.Fprintf(h, "%s:%d:", name, len(d.sources[name]))
+		h.Write(d.sources[name])
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// render produces the HTML for a directive. Unknown directives render
+// their content (or label) without any wrapper, and directives missing a
+// required attribute render nothing.
+func (d *Directives) render(dir *Directive) (string, error) {
+	if d.missing(dir) != "" {
+		return "", nil
+	}
+
+	if tmpl, ok := d.templates[dir.Name]; ok {
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, dir); err != nil {
+			return "", fmt.Errorf("directive %q: %w", dir.Name, err)
+		}
+		return buf.String(), nil
+	}
+
+	if h, ok := d.handlers[dir.Name]; ok {
+		html, err := h(dir)
+		if err != nil {
+			return "", fmt.Errorf("directive %q: %w", dir.Name, err)
+		}
+		return html, nil
+	}
+
+	if dir.Type == ContainerDirective {
+		return string(dir.Content), nil
+	}
+	return template.HTMLEscapeString(dir.Label), nil
+}
+
+var directiveWarningsKey = parser.NewContextKey()
+
+// DirectiveWarnings returns the problems found while parsing a document,
+// such as unknown directive names
+func DirectiveWarnings(pc parser.Context) []string {
+	warnings, _ := pc.Get(directiveWarningsKey).([]string)
+	return warnings
+}
+
+func warnDirective(pc parser.Context, format string, args ...interface{}) {
+	warnings, _ := pc.Get(directiveWarningsKey).([]string)
+	pc.Set(directiveWarningsKey, append(warnings, fmt.Sprintf(format, args...)))
+}
+
+// lineAt returns the 1-based line number of a byte offset in source
+func lineAt(source []byte, offset int) int {
+	return bytes.Count(source[:offset], []byte("\n")) + 1
+}
+
+// DirectiveBlock is a container or leaf directive in the AST
+type DirectiveBlock struct {
+	ast.BaseBlock
+	Directive Directive
+	fence     int  // Number of colons in the opening fence
+	open      bool // Container still accepting lines
+}
+
+// Dump implements ast.Node.Dump
+func (n *DirectiveBlock) Dump(source []byte, level int) {
+	ast.DumpHelper(n, source, level, map[string]string{
+		"Name":  n.Directive.Name,
+		"Label": n.Directive.Label,
+	}, nil)
+}
+
+// KindDirectiveBlock is the kind for DirectiveBlock nodes
+var KindDirectiveBlock = ast.NewNodeKind("DirectiveBlock")
+
+// Kind implements ast.Node.Kind
+func (n *DirectiveBlock) Kind() ast.NodeKind {
+	return KindDirectiveBlock
+}
+
+// DirectiveInline is an inline directive in the AST
+type DirectiveInline struct {
+	ast.BaseInline
+	Directive Directive
+}
+
+// Dump implements ast.Node.Dump
+func (n *DirectiveInline) Dump(source []byte, level int) {
+	ast.DumpHelper(n, source, level, map[string]string{
+		"Name":  n.Directive.Name,
+		"Label": n.Directive.Label,
+	}, nil)
+}
+
+// KindDirectiveInline is the kind for DirectiveInline nodes
+var KindDirectiveInline = ast.NewNodeKind("DirectiveInline")
+
+// Kind implements ast.Node.Kind
+func (n *DirectiveInline) Kind() ast.NodeKind {
+	return KindDirectiveInline
+}
+
+// directiveBlockParser parses container and leaf directives
+type directiveBlockParser struct {
+	directives *Directives
+}
+
+// Trigger returns the characters that trigger this parser
+func (p *directiveBlockParser) Trigger() []byte {
+	return []byte{':'}
+}
+
+// Open checks if the line starts a directive block
+func (p *directiveBlockParser) Open(parent ast.Node, reader text.Reader, pc parser.Context) (ast.Node, parser.State) {
+	line, segment := reader.PeekLine()
+	trimmed := bytes.TrimSpace(line)
+
+	fence := countColons(trimmed)
+	if fence < 2 {
+		return nil, parser.NoChildren
+	}
+
+	// Allow "::: name" as well as ":::name"
+	d, rest, ok := parseDirective(bytes.TrimLeft(trimmed[fence:], " \t"), true)
+	if !ok || len(bytes.TrimSpace(rest)) > 0 {
+		return nil, parser.NoChildren
+	}
+
+	p.directives.check(pc, &d, lineAt(reader.Source(), segment.Start))
+
+	reader.AdvanceToEOL()
+
+	if fence == 2 || p.directives.leaves[d.Name] {
+		d.Type = LeafDirective
+		return &DirectiveBlock{Directive: d}, parser.NoChildren
+	}
+
+	d.Type = ContainerDirective
+	return &DirectiveBlock{Directive: d, fence: fence, open: true}, parser.HasChildren
+}
+
+// Continue is called when the parser should continue parsing
+func (p *directiveBlockParser) Continue(node ast.Node, reader text.Reader, pc parser.Context) parser.State {
+	n := node.(*DirectiveBlock)
+	if !n.open {
+		return parser.Close
+	}
+
+	line, _ := reader.PeekLine()
+	trimmed := bytes.TrimSpace(line)
+
+	// A closing fence belongs to the innermost open container
+	if fence := countColons(trimmed); fence >= n.fence && fence == len(trimmed) && !hasOpenDirective(n) {
+		reader.AdvanceToEOL()
+		return parser.Close
+	}
+
+	return parser.Continue | parser.HasChildren
+}
+
+// Close is called when the parser is done
+func (p *directiveBlockParser) Close(node ast.Node, reader text.Reader, pc parser.Context) {
+	node.(*DirectiveBlock).open = false
+}
+
+// CanInterruptParagraph returns true if this parser can interrupt a paragraph
+func (p *directiveBlockParser) CanInterruptParagraph() bool {
+	return true
+}
+
+// CanAcceptIndentedLine returns false
+func (p *directiveBlockParser) CanAcceptIndentedLine() bool {
+	return false
+}
+
+// hasOpenDirective reports whether a container directive nested inside n
+// is still open
+func hasOpenDirective(n ast.Node) bool {
+	for c := n.LastChild(); c != nil; c = c.LastChild() {
+		if d, ok := c.(*DirectiveBlock); ok && d.open {
+			return true
+		}
+	}
+	return false
+}
+
+// directiveInlineParser parses inline directives
+type directiveInlineParser struct {
+	directives *Directives
+}
+
+// Trigger returns the characters that trigger this parser
+func (p *directiveInlineParser) Trigger() []byte {
+	return []byte{':'}
+}
+
+// Parse parses an inline directive. A label or attributes are required so
+// ordinary colons in prose are left alone.
+func (p *directiveInlineParser) Parse(parent ast.Node, block text.Reader, pc parser.Context) ast.Node {
+	// Skip colons inside words and URLs, e.g. "10:30" or "https://"
+	if prev := block.PrecendingCharacter(); prev == ':' || unicode.IsLetter(prev) || unicode.IsDigit(prev) {
+		return nil
+	}
+
+	line, segment := block.PeekLine()
+	if len(line) < 2 || line[0] != ':' {
+		return nil
+	}
+
+	d, rest, ok := parseDirective(line[1:], false)
+	if !ok {
+		return nil
+	}
+
+	p.directives.check(pc, &d, lineAt(block.Source(), segment.Start))
+
+	block.Advance(len(line) - len(rest))
+	d.Type = InlineDirective
+	return &DirectiveInline{Directive: d}
+}
+
+// directiveHTMLRenderer renders directive nodes to HTML
+type directiveHTMLRenderer struct {
+	directives *Directives
+	render     func(w io.Writer, source []byte, n ast.Node) error
+}
+
+// RegisterFuncs registers rendering functions
+func (r *directiveHTMLRenderer) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
+	reg.Register(KindDirectiveBlock, r.renderBlock)
+	reg.Register(KindDirectiveInline, r.renderInline)
+}
+
+// renderBlock renders a DirectiveBlock, passing its rendered children to
+// the handler as Content
+func (r *directiveHTMLRenderer) renderBlock(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	if !entering {
+		return ast.WalkContinue, nil
+	}
+
+	n := node.(*DirectiveBlock)
+	d := n.Directive
+
+	var content bytes.Buffer
+	for c := n.FirstChild(); c != nil; c = c.NextSibling() {
+		if err := r.render(&content, source, c); err != nil {
+			return ast.WalkStop, err
+		}
+	}
+	d.Content = template.HTML(content.String())
+
+	html, err := r.directives.render(&d)
+	if err != nil {
+		return ast.WalkStop, err
+	}
+
+	w.WriteString(html)
+	if !strings.HasSuffix(html, "\n") {
+		w.WriteString("\n")
+	}
+	return ast.WalkSkipChildren, nil
+}
+
+// renderInline renders a DirectiveInline
+func (r *directiveHTMLRenderer) renderInline(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	if !entering {
+		return ast.WalkContinue, nil
+	}
+
+	d := node.(*DirectiveInline).Directive
+	html, err := r.directives.render(&d)
+	if err != nil {
+		return ast.WalkStop, err
+	}
+
+	// Template files usually end in a newline that would add stray space
+	w.WriteString(strings.TrimRight(html, "\n"))
+	return ast.WalkSkipChildren, nil
+}
+
+// DirectiveExtension is a goldmark extension for :::name directives
+type DirectiveExtension struct {
+	directives *Directives
+}
+
+// Extend extends the goldmark parser with directive support
+func (e *DirectiveExtension) Extend(m goldmark.Markdown) {
+	m.Parser().AddOptions(
+		parser.WithBlockParsers(
+			util.Prioritized(&directiveBlockParser{directives: e.directives}, 500),
+		),
+		parser.WithInlineParsers(
+			util.Prioritized(&directiveInlineParser{directives: e.directives}, 500),
+		),
+	)
+	m.Renderer().AddOptions(
+		renderer.WithNodeRenderers(
+			util.Prioritized(&directiveHTMLRenderer{
+				directives: e.directives,
+				render:     m.Renderer().Render,
+			}, 500),
+		),
+	)
+}
+
+// NewDirectiveExtension creates a new directive extension
+func NewDirectiveExtension(directives *Directives) goldmark.Extender {
+	return &DirectiveExtension{directives: directives}
+}
+
+// countColons returns the number of leading colons in line
+func countColons(line []byte) int {
+	n := 0
+	for n < len(line) && line[n] == ':' {
+		n++
+	}
+	return n
+}
+
+// parseDirective parses name[label]{attrs} from the start of s and returns
+// the remainder. Blocks may omit both label and attributes; inline
+// directives need at least one of them.
+func parseDirective(s []byte, block bool) (Directive, []byte, bool) {
+	d := Directive{Attrs: make(map[string]string)}
+
+	if len(s) == 0 || !isNameStart(s[0]) {
+		return d, s, false
+	}
+	i := 1
+	for i < len(s) && (isNameStart(s[i]) || s[i] == '-' || s[i] >= '0' && s[i] <= '9') {
+		i++
+	}
+	d.Name = string(s[:i])
+	s = s[i:]
+
+	hasLabel := len(s) > 0 && s[0] == '['
+	if hasLabel {
+		label, rest, ok := parseLabel(s)
+		if !ok {
+			return d, s, false
+		}
+		d.Label = label
+		s = rest
+	}
+
+	hasAttrs := len(s) > 0 && s[0] == '{'
+	if hasAttrs {
+		attrs, rest, ok := parseAttrs(s)
+		if !ok {
+			return d, s, false
+		}
+		d.Attrs = attrs
+		s = rest
+	}
+
+	if !block && !hasLabel && !hasAttrs {
+		return d, s, false
+	}
+	return d, s, true
+}
+
+func isNameStart(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '_'
+}
+
+// parseLabel parses a [label] with balanced brackets and backslash escapes
+func parseLabel(s []byte) (string, []byte, bool) {
+	var label strings.Builder
+	depth := 0
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '\\' && i+1 < len(s):
+			i++
+			label.WriteByte(s[i])
+			continue
+		case c == '[':
+			depth++
+			if depth == 1 {
+				continue
+			}
+		case c == ']':
+			depth--
+			if depth == 0 {
+				return label.String(), s[i+1:], true
+			}
+		}
+		label.WriteByte(c)
+	}
+	return "", s, false
+}
+
+// parseAttrs parses {key="value" key=value key #id .class}
+func parseAttrs(s []byte) (map[string]string, []byte, bool) {
+	attrs := make(map[string]string)
+	var classes []string
+
+	i := 1 // Skip '{'
+	for {
+		for i < len(s) && (s[i] == ' ' || s[i] == '\t') {
+			i++
+		}
+		if i >= len(s) {
+			return nil, s, false
+		}
+		if s[i] == '}' {
+			i++
+			break
+		}
+
+		// Shorthand #id and .class
+		prefix := s[i]
+		if prefix == '#' || prefix == '.' {
+			i++
+		}
+
+		start := i
+		for i < len(s) && !strings.ContainsRune(" \t=}", rune(s[i])) {
+			i++
+		}
+		key := string(s[start:i])
+		if key == "" {
+			return nil, s, false
+		}
+
+		switch prefix {
+		case '#':
+			attrs["id"] = key
+			continue
+		case '.':
+			classes = append(classes, key)
+			continue
+		}
+
+		if i >= len(s) || s[i] != '=' {
+			attrs[key] = ""
+			continue
+		}
+		i++
+
+		var value string
+		if i < len(s) && (s[i] == '"' || s[i] == '\'') {
+			quote := s[i]
+			end := bytes.IndexByte(s[i+1:], quote)
+			if end < 0 {
+				return nil, s, false
+			}
+			value = string(s[i+1 : i+1+end])
+			i += end + 2
+		} else {
+			start := i
+			for i < len(s) && !strings.ContainsRune(" \t}", rune(s[i])) {
+				i++
+			}
+			value = string(s[start:i])
+		}
+		attrs[key] = value
+	}
+
+	if len(classes) > 0 {
+		if existing := attrs["class"]; existing != "" {
+			classes = append([]string{existing}, classes...)
+		}
+		attrs["class"] = strings.Join(classes, " ")
+	}
+
+	return attrs, s[i:], true
+}
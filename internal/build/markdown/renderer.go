@@ -2,6 +2,7 @@ package markdown
 
 import (
 	"bytes"
+	stdhtml "html"
 	"regexp"
 	"strings"
 	"time"
@@ -11,13 +12,19 @@ import (
 
 	embed "github.com/13rac1/goldmark-embed"
 	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
 	"github.com/yuin/goldmark/extension"
 	"github.com/yuin/goldmark/parser"
 	"github.com/yuin/goldmark/renderer/html"
+	"github.com/yuin/goldmark/text"
 	alertcallouts "github.com/zmtcreative/gm-alert-callouts"
 	"gopkg.in/yaml.v3"
 )
 
+// Version identifies the renderer's output. Bump it whenever extensions or
+// options change the generated HTML so cached renders are discarded.
+const Version = "3"
+
 var frontmatterRegex = regexp.MustCompile(`(?s)^---\n(.+?)\n---\n(.*)$`)
 
 // ParseFrontmatter parses YAML frontmatter from markdown content
@@ -50,11 +57,17 @@ func ParseDate(s string) time.Time {
 
 // Renderer wraps goldmark with all custom extensions
 type Renderer struct {
-	md goldmark.Markdown
+	md  goldmark.Markdown
+	key string
 }
 
-// NewRenderer creates a new markdown renderer with all extensions
-func NewRenderer() *Renderer {
+// NewRenderer creates a new markdown renderer with all extensions. A nil
+// directives registry uses only the built-in directives.
+func NewRenderer(directives *extensions.Directives) *Renderer {
+	if directives == nil {
+		directives = extensions.NewDirectives()
+	}
+
 	md := goldmark.New(
 		goldmark.WithExtensions(
 			extension.GFM,
@@ -64,8 +77,7 @@ func NewRenderer() *Renderer {
 				alertcallouts.WithFolding(true),
 			),
 			embed.New(),
-			extensions.NewPDFEmbedExtension(),
-			extensions.NewAsideExtension(),
+			extensions.NewDirectiveExtension(directives),
 		),
 		goldmark.WithParserOptions(
 			parser.WithAutoHeadingID(),
@@ -77,61 +89,125 @@ func NewRenderer() *Renderer {
 		),
 	)
 
-	return &Renderer{md: md}
+	return &Renderer{md: md, key: Version + ":" + directives.Fingerprint()}
 }
 
-// Render converts markdown to HTML
-func (r *Renderer) Render(source string) (string, error) {
-	var buf bytes.Buffer
-	if err := r.md.Convert([]byte(source), &buf); err != nil {
-		return "", err
-	}
-	return buf.String(), nil
+// Key identifies the renderer's configuration for caching renders
+func (r *Renderer) Key() string {
+	return r.key
 }
 
-// ExtractTOC extracts table of contents from markdown
-func ExtractTOC(markdown string) []models.TOCItem {
-	var items []models.TOCItem
+// Document is a rendered markdown document
+type Document struct {
+	HTML     string
+	TOC      []models.TOCItem
+	Warnings []string // Problems that did not stop rendering, e.g. unknown directives
+}
+
+// TOCOptions selects which heading levels appear in the table of contents.
+// Zero values fall back to DefaultTOCMinLevel and DefaultTOCMaxLevel.
+type TOCOptions struct {
+	MinLevel int
+	MaxLevel int
+}
 
-	// Match markdown headings (## Heading)
-	headingRegex := regexp.MustCompile(`(?m)^(#{1,6})\s+(.+)$`)
-	matches := headingRegex.FindAllStringSubmatch(markdown, -1)
+const (
+	DefaultTOCMinLevel = 1
+	DefaultTOCMaxLevel = 6
+)
 
-	for _, match := range matches {
-		level := len(match[1])
-		text := strings.TrimSpace(match[2])
-		id := slugify(text)
+// Render converts markdown to HTML and builds a nested table of contents
+// from the headings in the parsed document, so TOC links always match the
+// IDs goldmark assigns to the rendered headings
+func (r *Renderer) Render(source string, opts TOCOptions) (*Document, error) {
+	src := []byte(source)
+	pc := parser.NewContext()
+	doc := r.md.Parser().Parse(text.NewReader(src), parser.WithContext(pc))
 
-		items = append(items, models.TOCItem{
-			Level: level,
-			ID:    id,
-			Text:  text,
-		})
+	var buf bytes.Buffer
+	if err := r.md.Renderer().Render(&buf, src, doc); err != nil {
+		return nil, err
 	}
 
-	return items
+	return &Document{
+		HTML:     buf.String(),
+		TOC:      nestTOC(collectHeadings(doc, src, opts)),
+		Warnings: extensions.DirectiveWarnings(pc),
+	}, nil
 }
 
-// slugify converts text to a URL-friendly slug
-func slugify(s string) string {
-	// Convert to lowercase
-	s = strings.ToLower(s)
-
-	// Replace spaces with hyphens
-	s = strings.ReplaceAll(s, " ", "-")
+// collectHeadings returns a flat list of the document's headings within
+// the configured levels, in document order
+func collectHeadings(doc ast.Node, src []byte, opts TOCOptions) []models.TOCItem {
+	minLevel, maxLevel := opts.MinLevel, opts.MaxLevel
+	if minLevel <= 0 {
+		minLevel = DefaultTOCMinLevel
+	}
+	if maxLevel <= 0 {
+		maxLevel = DefaultTOCMaxLevel
+	}
 
-	// Remove non-alphanumeric characters except hyphens
-	reg := regexp.MustCompile(`[^a-z0-9-]`)
-	s = reg.ReplaceAllString(s, "")
+	var items []models.TOCItem
+	_ = ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		heading, ok := n.(*ast.Heading)
+		if !ok || !entering {
+			return ast.WalkContinue, nil
+		}
+
+		if heading.Level >= minLevel && heading.Level <= maxLevel {
+			if id, ok := heading.AttributeString("id"); ok {
+				items = append(items, models.TOCItem{
+					Level: heading.Level,
+					ID:    string(id.([]byte)),
+					Text:  headingText(heading, src),
+				})
+			}
+		}
+		return ast.WalkSkipChildren, nil
+	})
 
-	// Remove multiple consecutive hyphens
-	reg = regexp.MustCompile(`-+`)
-	s = reg.ReplaceAllString(s, "-")
+	return items
+}
 
-	// Trim hyphens from ends
-	s = strings.Trim(s, "-")
+// headingText returns the plain text of a heading, without markup
+func headingText(heading *ast.Heading, src []byte) string {
+	var buf bytes.Buffer
+	_ = ast.Walk(heading, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+		switch n := n.(type) {
+		case *ast.Text:
+			buf.Write(n.Value(src))
+			if n.SoftLineBreak() {
+				buf.WriteByte(' ')
+			}
+		case *ast.String:
+			// Typographer substitutions are stored as HTML entities
+			buf.Write(n.Value)
+		case *ast.RawHTML:
+			return ast.WalkSkipChildren, nil
+		}
+		return ast.WalkContinue, nil
+	})
+	return strings.TrimSpace(stdhtml.UnescapeString(buf.String()))
+}
 
-	return s
+// nestTOC turns a flat list of headings into a tree, placing each heading
+// under the closest preceding heading with a lower level
+func nestTOC(flat []models.TOCItem) []models.TOCItem {
+	var items []models.TOCItem
+	for i := 0; i < len(flat); {
+		item := flat[i]
+		j := i + 1
+		for j < len(flat) && flat[j].Level > item.Level {
+			j++
+		}
+		item.Children = nestTOC(flat[i+1 : j])
+		items = append(items, item)
+		i = j
+	}
+	return items
 }
 
 // StripHTML removes HTML tags from a string
@@ -1,6 +1,7 @@
 package og
 
 import (
+	"bytes"
 	"image"
 	"image/color"
 	"image/jpeg"
@@ -8,6 +9,8 @@ import (
 	"path/filepath"
 	"strings"
 
+	"site/internal/build/cache"
+	"site/internal/build/output"
 	"site/internal/models"
 
 	"github.com/fogleman/gg"
@@ -19,21 +22,23 @@ const (
 )
 
 type Generator struct {
-	outputDir    string
+	out          output.Writer
 	profilePhoto image.Image
 	fontPath     string
 	fontBoldPath string
 	siteName     string
 	siteURL      string
+	inputsKey    string // hash of the photo and fonts baked into every image
 }
 
-func NewGenerator(outputDir, profilePhotoPath, fontPath, fontBoldPath, siteName, siteURL string) (*Generator, error) {
+func NewGenerator(out output.Writer, profilePhotoPath, fontPath, fontBoldPath, siteName, siteURL string) (*Generator, error) {
 	g := &Generator{
-		outputDir:    outputDir,
+		out:          out,
 		fontPath:     fontPath,
 		fontBoldPath: fontBoldPath,
 		siteName:     siteName,
 		siteURL:      siteURL,
+		inputsKey:    cache.HashFiles(profilePhotoPath, fontPath, fontBoldPath),
 	}
 
 	if profilePhotoPath != "" {
@@ -81,16 +86,26 @@ func (g *Generator) Generate(post *models.Post, collection *models.Collection) (
 
 	g.drawFooter(dc)
 
-	outputPath := g.getOutputPath(post, collection)
-	if err := os.MkdirAll(filepath.Dir(outputPath), 0755); err != nil {
+	var buf bytes.Buffer
+	if err := dc.EncodePNG(&buf); err != nil {
 		return "", err
 	}
 
-	if err := dc.SavePNG(outputPath); err != nil {
+	if err := g.out.WriteFile(g.OutputPath(post, collection), buf.Bytes()); err != nil {
 		return "", err
 	}
 
-	return g.getWebPath(post, collection), nil
+	return g.WebPath(post, collection), nil
+}
+
+// Fingerprint returns a key covering everything that is drawn on the
+// image for post, so unchanged images can be reused between builds
+func (g *Generator) Fingerprint(post *models.Post, collection *models.Collection) string {
+	var slug, name, kind string
+	if collection != nil {
+		slug, name, kind = collection.Slug, collection.Name, string(collection.Type)
+	}
+	return cache.Key(g.inputsKey, g.siteName, g.siteURL, post.Title, slug, name, kind)
 }
 
 func (g *Generator) drawBadge(dc *gg.Context, text string, x, y float64) {
@@ -195,14 +210,16 @@ func (g *Generator) drawFooter(dc *gg.Context) {
 	dc.DrawString(text, 80, imageHeight-45)
 }
 
-func (g *Generator) getOutputPath(post *models.Post, collection *models.Collection) string {
+// OutputPath returns the image path relative to the output directory
+func (g *Generator) OutputPath(post *models.Post, collection *models.Collection) string {
 	if collection != nil {
-		return filepath.Join(g.outputDir, "og", collection.Slug, post.Slug+".png")
+		return filepath.Join("og", collection.Slug, post.Slug+".png")
 	}
-	return filepath.Join(g.outputDir, "og", post.Slug+".png")
+	return filepath.Join("og", post.Slug+".png")
 }
 
-func (g *Generator) getWebPath(post *models.Post, collection *models.Collection) string {
+// WebPath returns the URL path the image is served from
+func (g *Generator) WebPath(post *models.Post, collection *models.Collection) string {
 	if collection != nil {
 		return "/og/" + collection.Slug + "/" + post.Slug + ".png"
 	}
@@ -0,0 +1,112 @@
+package output
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// ChangeOp describes what a build would do to a single output file
+type ChangeOp string
+
+const (
+	OpCreate ChangeOp = "create"
+	OpUpdate ChangeOp = "update"
+	OpDelete ChangeOp = "delete"
+)
+
+// Change is one difference between a build and an existing output directory
+type Change struct {
+	Op      ChangeOp
+	Path    string
+	Size    int64 // Size after the build (0 for deletions)
+	OldSize int64 // Size currently on disk (0 for creations)
+}
+
+// Memory collects outputs in memory without touching the disk
+type Memory struct {
+	mu    sync.Mutex
+	files map[string][]byte
+}
+
+// NewMemory creates an empty in-memory writer
+func NewMemory() *Memory {
+	return &Memory{files: make(map[string][]byte)}
+}
+
+// WriteFile stores a copy of data at rel
+func (m *Memory) WriteFile(rel string, data []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.files[filepath.Clean(rel)] = bytes.Clone(data)
+	return nil
+}
+
+// Keep always returns false: nothing exists in memory until it is written
+func (m *Memory) Keep(rel string) bool {
+	return false
+}
+
+// Diff compares the collected outputs against the directory root and
+// returns the changes sorted by path, along with the number of files
+// that would be left as they are
+func (m *Memory) Diff(root string) ([]Change, int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var changes []Change
+	unchanged := 0
+	onDisk := make(map[string]bool)
+
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		onDisk[rel] = true
+
+		data, ok := m.files[rel]
+		if !ok {
+			changes = append(changes, Change{Op: OpDelete, Path: rel, OldSize: info.Size()})
+			return nil
+		}
+
+		existing, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if bytes.Equal(existing, data) {
+			unchanged++
+			return nil
+		}
+		changes = append(changes, Change{Op: OpUpdate, Path: rel, Size: int64(len(data)), OldSize: info.Size()})
+		return nil
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	for rel, data := range m.files {
+		if !onDisk[rel] {
+			changes = append(changes, Change{Op: OpCreate, Path: rel, Size: int64(len(data))})
+		}
+	}
+
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Path < changes[j].Path
+	})
+
+	return changes, unchanged, nil
+}
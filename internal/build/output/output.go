@@ -0,0 +1,139 @@
+package output
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// Writer receives every file produced by a build.
+// Paths are relative to the output root and use the OS separator.
+type Writer interface {
+	// WriteFile stores data at the relative path rel
+	WriteFile(rel string, data []byte) error
+	// Keep marks an existing output as produced without rewriting it.
+	// It returns false if the file does not exist and must be regenerated.
+	Keep(rel string) bool
+}
+
+// Dir writes outputs to a directory on disk. Files whose content is
+// unchanged are left untouched, and everything that was not produced
+// during the build can be removed afterwards with Prune.
+type Dir struct {
+	root     string
+	mu       sync.Mutex
+	produced map[string]bool
+	written  int
+}
+
+// NewDir creates a writer rooted at dir
+func NewDir(dir string) *Dir {
+	return &Dir{
+		root:     dir,
+		produced: make(map[string]bool),
+	}
+}
+
+// WriteFile writes data to rel unless the file already holds the same bytes
+func (d *Dir) WriteFile(rel string, data []byte) error {
+	d.mark(rel)
+
+	fullPath := filepath.Join(d.root, rel)
+	if existing, err := os.ReadFile(fullPath); err == nil && bytes.Equal(existing, data) {
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(fullPath), 0755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(fullPath, data, 0644); err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	d.written++
+	d.mu.Unlock()
+	return nil
+}
+
+// Keep marks rel as produced if it exists on disk
+func (d *Dir) Keep(rel string) bool {
+	info, err := os.Stat(filepath.Join(d.root, rel))
+	if err != nil || info.IsDir() {
+		return false
+	}
+	d.mark(rel)
+	return true
+}
+
+// Written returns how many files were actually (re)written
+func (d *Dir) Written() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.written
+}
+
+// Prune deletes every file under the root that was not produced and
+// removes directories left empty. It returns the relative paths removed.
+func (d *Dir) Prune() ([]string, error) {
+	var removed []string
+	var dirs []string
+
+	err := filepath.Walk(d.root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+
+		rel, err := filepath.Rel(d.root, path)
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if rel != "." {
+				dirs = append(dirs, path)
+			}
+			return nil
+		}
+
+		if d.isProduced(rel) {
+			return nil
+		}
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+		removed = append(removed, rel)
+		return nil
+	})
+	if err != nil {
+		return removed, err
+	}
+
+	// Deepest directories first so parents become empty in turn
+	sort.Slice(dirs, func(i, j int) bool {
+		return len(dirs[i]) > len(dirs[j])
+	})
+	for _, dir := range dirs {
+		if entries, err := os.ReadDir(dir); err == nil && len(entries) == 0 {
+			os.Remove(dir)
+		}
+	}
+
+	return removed, nil
+}
+
+func (d *Dir) mark(rel string) {
+	d.mu.Lock()
+	d.produced[filepath.Clean(rel)] = true
+	d.mu.Unlock()
+}
+
+func (d *Dir) isProduced(rel string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.produced[filepath.Clean(rel)]
+}
@@ -0,0 +1,173 @@
+package build
+
+import (
+	"fmt"
+	"html/template"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"site/internal/models"
+)
+
+// taxonomies returns the site's taxonomies that have at least one term
+func (s *Site) taxonomies() []*models.Taxonomy {
+	var taxonomies []*models.Taxonomy
+	for _, t := range []*models.Taxonomy{s.Tags, s.Categories} {
+		if t != nil && len(t.Terms) > 0 {
+			taxonomies = append(taxonomies, t)
+		}
+	}
+	return taxonomies
+}
+
+// termDir returns the output directory of a term's listing page and feeds
+func termDir(taxonomy *models.Taxonomy, term *models.Term) string {
+	return filepath.Join(taxonomy.Name, term.Slug)
+}
+
+// hasTaxonomyPages reports whether the templates for tag and category
+// pages exist, since the pages are optional
+func (ts *TemplateSet) hasTaxonomyPages() bool {
+	return ts.Taxonomy != nil && ts.Term != nil
+}
+
+// generateTaxonomies creates the /tags and /categories index pages and a
+// paginated listing for every term
+func (s *Site) generateTaxonomies(ts *TemplateSet) error {
+	if !ts.hasTaxonomyPages() {
+		return nil
+	}
+
+	for _, taxonomy := range s.taxonomies() {
+		if err := s.generateTaxonomyIndex(ts.Taxonomy, taxonomy); err != nil {
+			return err
+		}
+		for _, term := range taxonomy.Terms {
+			if err := s.generateTerm(ts.Term, taxonomy, term); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// generateTaxonomyIndex creates the page listing every term of a taxonomy
+func (s *Site) generateTaxonomyIndex(tmpl *template.Template, taxonomy *models.Taxonomy) error {
+	title := taxonomyTitle(taxonomy)
+
+	data := struct {
+		PageData
+		Taxonomy *models.Taxonomy
+	}{
+		PageData: PageData{
+			Title:        title + " | " + s.Config.SiteName,
+			Description:  "Browse posts by " + taxonomySingular(taxonomy),
+			CanonicalURL: s.Config.BaseURL + taxonomy.URL(),
+			OGType:       "website",
+			OGImage:      s.getSocialImage(nil),
+			SiteName:     s.Config.SiteName,
+			Collections:  s.Collections,
+			Year:         time.Now().Year(),
+			DevMode:      s.Config.DevMode,
+			Feeds:        s.feedLinks(nil),
+			Tags:         s.Tags.Terms,
+		},
+		Taxonomy: taxonomy,
+	}
+
+	return s.renderPage(tmpl, filepath.Join(taxonomy.Name, "index.html"), data)
+}
+
+// generateTerm creates paginated listing pages for a single term
+func (s *Site) generateTerm(tmpl *template.Template, taxonomy *models.Taxonomy, term *models.Term) error {
+	termURL := taxonomy.TermURL(term)
+
+	totalPosts := len(term.Posts)
+	totalPages := (totalPosts + postsPerPage - 1) / postsPerPage
+	if totalPages == 0 {
+		totalPages = 1
+	}
+
+	for page := 1; page <= totalPages; page++ {
+		start := (page - 1) * postsPerPage
+		end := start + postsPerPage
+		if end > totalPosts {
+			end = totalPosts
+		}
+
+		var pagePosts []*models.Post
+		if start < totalPosts {
+			pagePosts = term.Posts[start:end]
+		}
+
+		data := struct {
+			PageData
+			Taxonomy    *models.Taxonomy
+			Term        *models.Term
+			TermURL     string
+			Posts       []*models.Post
+			CurrentPage int
+			TotalPages  int
+			PrevPage    int
+			NextPage    int
+			PageNumbers []int
+		}{
+			PageData: PageData{
+				Title:        term.Name + " | " + taxonomyTitle(taxonomy) + " | " + s.Config.SiteName,
+				Description:  fmt.Sprintf("Posts filed under %q", term.Name),
+				CanonicalURL: s.Config.BaseURL + termURL,
+				OGType:       "website",
+				OGImage:      s.getSocialImage(nil),
+				SiteName:     s.Config.SiteName,
+				Collections:  s.Collections,
+				Year:         time.Now().Year(),
+				DevMode:      s.Config.DevMode,
+				Feeds:        append(s.feedLinks(nil), s.feedLinksFor(term.Name, termDir(taxonomy, term))...),
+				Tags:         s.Tags.Terms,
+			},
+			Taxonomy:    taxonomy,
+			Term:        term,
+			TermURL:     termURL,
+			Posts:       pagePosts,
+			CurrentPage: page,
+			TotalPages:  totalPages,
+			PrevPage:    page - 1,
+			NextPage:    page + 1,
+			PageNumbers: buildPageNumbers(page, totalPages),
+		}
+
+		var outPath string
+		if page == 1 {
+			outPath = filepath.Join(termDir(taxonomy, term), "index.html")
+		} else {
+			outPath = filepath.Join(termDir(taxonomy, term), "page", fmt.Sprintf("%d", page), "index.html")
+		}
+
+		if err := s.renderPage(tmpl, outPath, data); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// taxonomyTitle returns the display name of a taxonomy, e.g. "Tags"
+func taxonomyTitle(taxonomy *models.Taxonomy) string {
+	if taxonomy.Name == "" {
+		return ""
+	}
+	return strings.ToUpper(taxonomy.Name[:1]) + taxonomy.Name[1:]
+}
+
+// taxonomySingular returns the singular name of a taxonomy's terms
+func taxonomySingular(taxonomy *models.Taxonomy) string {
+	switch taxonomy.Name {
+	case models.TaxonomyCategories:
+		return "category"
+	case models.TaxonomyTags:
+		return "tag"
+	}
+	return taxonomy.Name
+}
@@ -0,0 +1,117 @@
+package db
+
+import (
+	"database/sql"
+	"time"
+)
+
+// ActivityPubKey returns the PEM encoded private key of the site's actor,
+// creating it with generate the first time
+func (db *DB) ActivityPubKey(generate func() (string, error)) (string, error) {
+	var key string
+	err := db.conn.QueryRow(`SELECT private_key FROM activitypub_keys WHERE id = 1`).Scan(&key)
+	if err != sql.ErrNoRows {
+		return key, err
+	}
+
+	if key, err = generate(); err != nil {
+		return "", err
+	}
+	// Another process may have won the race; keep whichever key was first
+	if _, err := db.conn.Exec(`
+		INSERT INTO activitypub_keys (id, private_key, created_at) VALUES (1, ?, ?)
+		ON CONFLICT(id) DO NOTHING
+	`, key, time.Now()); err != nil {
+		return "", err
+	}
+	err = db.conn.QueryRow(`SELECT private_key FROM activitypub_keys WHERE id = 1`).Scan(&key)
+	return key, err
+}
+
+// AddFollower records a fediverse account following the site. Following
+// again updates its inboxes.
+func (db *DB) AddFollower(actor, inbox, sharedInbox, followID string) error {
+	_, err := db.conn.Exec(`
+		INSERT INTO activitypub_followers (actor, inbox, shared_inbox, follow_id, created_at)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(actor) DO UPDATE SET
+			inbox = excluded.inbox,
+			shared_inbox = excluded.shared_inbox,
+			follow_id = excluded.follow_id
+	`, actor, inbox, sharedInbox, followID, time.Now())
+	return err
+}
+
+// RemoveFollower forgets a follower. A followID other than "" must match
+// the Follow they were recorded with. It returns sql.ErrNoRows if there
+// was no such follower.
+func (db *DB) RemoveFollower(actor, followID string) error {
+	res, err := db.conn.Exec(`
+		DELETE FROM activitypub_followers WHERE actor = ? AND (? = '' OR follow_id = ?)
+	`, actor, followID, followID)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// RemoveInboxFollowers forgets the followers delivered to through an
+// inbox, their own or their server's shared one, and returns how many
+// there were
+func (db *DB) RemoveInboxFollowers(inbox string) (int64, error) {
+	res, err := db.conn.Exec(`
+		DELETE FROM activitypub_followers WHERE inbox = ? OR shared_inbox = ?
+	`, inbox, inbox)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// CountFollowers returns how many accounts follow the site
+func (db *DB) CountFollowers() (int, error) {
+	var count int
+	err := db.conn.QueryRow(`SELECT COUNT(*) FROM activitypub_followers`).Scan(&count)
+	return count, err
+}
+
+// FollowerInboxes returns the inboxes to deliver to so every follower sees
+// an activity once: shared inboxes where the server has one
+func (db *DB) FollowerInboxes() ([]string, error) {
+	rows, err := db.conn.Query(`
+		SELECT DISTINCT COALESCE(NULLIF(shared_inbox, ''), inbox) FROM activitypub_followers
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var inboxes []string
+	for rows.Next() {
+		var inbox string
+		if err := rows.Scan(&inbox); err != nil {
+			return nil, err
+		}
+		inboxes = append(inboxes, inbox)
+	}
+	return inboxes, rows.Err()
+}
+
+// MarkPostPublished records that a post was announced to followers and
+// reports whether it hadn't been before
+func (db *DB) MarkPostPublished(postSlug string) (bool, error) {
+	res, err := db.conn.Exec(`
+		INSERT INTO activitypub_posts (post_slug, published_at) VALUES (?, ?)
+		ON CONFLICT(post_slug) DO NOTHING
+	`, postSlug, time.Now())
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
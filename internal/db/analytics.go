@@ -0,0 +1,225 @@
+package db
+
+import (
+	"database/sql"
+
+	"site/internal/models"
+)
+
+// RecordPageViews stores counted page views
+func (db *DB) RecordPageViews(views []models.PageView) error {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(`
+		INSERT INTO analytics_views (day, path, visitor, referrer, agent) VALUES (?, ?, ?, ?, ?)
+	`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, v := range views {
+		if _, err := stmt.Exec(v.Day, v.Path, v.Visitor, v.Referrer, v.Agent); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// AnalyticsSalt returns the salt for a day's visitor hashes, creating it
+// with generate the first time
+func (db *DB) AnalyticsSalt(day string, generate func() (string, error)) (string, error) {
+	var salt string
+	err := db.conn.QueryRow(`SELECT salt FROM analytics_salts WHERE day = ?`, day).Scan(&salt)
+	if err != sql.ErrNoRows {
+		return salt, err
+	}
+
+	if salt, err = generate(); err != nil {
+		return "", err
+	}
+	if _, err := db.conn.Exec(`
+		INSERT INTO analytics_salts (day, salt) VALUES (?, ?)
+		ON CONFLICT(day) DO NOTHING
+	`, day, salt); err != nil {
+		return "", err
+	}
+	err = db.conn.QueryRow(`SELECT salt FROM analytics_salts WHERE day = ?`, day).Scan(&salt)
+	return salt, err
+}
+
+// RollupAnalytics adds the page views of days before a day to the daily
+// counts, then deletes them along with those days' salts, leaving nothing
+// that tells visitors apart. It returns how many views were rolled up.
+func (db *DB) RollupAnalytics(before string) (int64, error) {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	rollups := []string{`
+		INSERT INTO analytics_days (day, views, visitors)
+		SELECT day, COUNT(*), COUNT(DISTINCT visitor) FROM analytics_views
+		WHERE day < ? GROUP BY day
+		ON CONFLICT(day) DO UPDATE SET
+			views = views + excluded.views,
+			visitors = visitors + excluded.visitors
+	`, `
+		INSERT INTO analytics_pages (day, path, views, visitors)
+		SELECT day, path, COUNT(*), COUNT(DISTINCT visitor) FROM analytics_views
+		WHERE day < ? GROUP BY day, path
+		ON CONFLICT(day, path) DO UPDATE SET
+			views = views + excluded.views,
+			visitors = visitors + excluded.visitors
+	`, `
+		INSERT INTO analytics_referrers (day, host, views)
+		SELECT day, referrer, COUNT(*) FROM analytics_views
+		WHERE day < ? AND referrer != '' GROUP BY day, referrer
+		ON CONFLICT(day, host) DO UPDATE SET views = views + excluded.views
+	`, `
+		INSERT INTO analytics_agents (day, class, views)
+		SELECT day, agent, COUNT(*) FROM analytics_views
+		WHERE day < ? GROUP BY day, agent
+		ON CONFLICT(day, class) DO UPDATE SET views = views + excluded.views
+	`}
+	for _, query := range rollups {
+		if _, err := tx.Exec(query, before); err != nil {
+			return 0, err
+		}
+	}
+
+	res, err := tx.Exec(`DELETE FROM analytics_views WHERE day < ?`, before)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := tx.Exec(`DELETE FROM analytics_salts WHERE day < ?`, before); err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// AnalyticsStats returns the busiest pages, referrers and browser classes
+// since a day, at most limit of each, and the totals for every day. Views
+// not rolled up yet are counted too.
+func (db *DB) AnalyticsStats(since string, limit int) (*models.AnalyticsStats, error) {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	stats := &models.AnalyticsStats{
+		Since:     since,
+		Pages:     []models.PageStats{},
+		Referrers: []models.ReferrerStats{},
+		Agents:    []models.AgentStats{},
+		Days:      []models.DayStats{},
+	}
+
+	err = scanRows(tx, `
+		SELECT path, SUM(views), SUM(visitors) FROM (
+			SELECT path, views, visitors FROM analytics_pages WHERE day >= ?
+			UNION ALL
+			SELECT path, COUNT(*), COUNT(DISTINCT visitor) FROM analytics_views
+			WHERE day >= ? GROUP BY day, path
+		)
+		GROUP BY path ORDER BY SUM(views) DESC, path LIMIT ?
+	`, []any{since, since, limit}, func(rows *sql.Rows) error {
+		var p models.PageStats
+		if err := rows.Scan(&p.Path, &p.Views, &p.Visitors); err != nil {
+			return err
+		}
+		stats.Pages = append(stats.Pages, p)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = scanRows(tx, `
+		SELECT host, SUM(views) FROM (
+			SELECT host, views FROM analytics_referrers WHERE day >= ?
+			UNION ALL
+			SELECT referrer, COUNT(*) FROM analytics_views
+			WHERE day >= ? AND referrer != '' GROUP BY referrer
+		)
+		GROUP BY host ORDER BY SUM(views) DESC, host LIMIT ?
+	`, []any{since, since, limit}, func(rows *sql.Rows) error {
+		var r models.ReferrerStats
+		if err := rows.Scan(&r.Host, &r.Views); err != nil {
+			return err
+		}
+		stats.Referrers = append(stats.Referrers, r)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = scanRows(tx, `
+		SELECT class, SUM(views) FROM (
+			SELECT class, views FROM analytics_agents WHERE day >= ?
+			UNION ALL
+			SELECT agent, COUNT(*) FROM analytics_views WHERE day >= ? GROUP BY agent
+		)
+		GROUP BY class ORDER BY SUM(views) DESC, class LIMIT ?
+	`, []any{since, since, limit}, func(rows *sql.Rows) error {
+		var a models.AgentStats
+		if err := rows.Scan(&a.Class, &a.Views); err != nil {
+			return err
+		}
+		stats.Agents = append(stats.Agents, a)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = scanRows(tx, `
+		SELECT day, SUM(views), SUM(visitors) FROM (
+			SELECT day, views, visitors FROM analytics_days WHERE day >= ?
+			UNION ALL
+			SELECT day, COUNT(*), COUNT(DISTINCT visitor) FROM analytics_views
+			WHERE day >= ? GROUP BY day
+		)
+		GROUP BY day ORDER BY day
+	`, []any{since, since}, func(rows *sql.Rows) error {
+		var d models.DayStats
+		if err := rows.Scan(&d.Day, &d.Views, &d.Visitors); err != nil {
+			return err
+		}
+		stats.Days = append(stats.Days, d)
+		stats.Views += d.Views
+		stats.Visitors += d.Visitors
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return stats, nil
+}
+
+// scanRows runs a query in tx and calls scan for each row
+func scanRows(tx *sql.Tx, query string, args []any, scan func(*sql.Rows) error) error {
+	rows, err := tx.Query(query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		if err := scan(rows); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
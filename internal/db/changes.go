@@ -0,0 +1,43 @@
+package db
+
+import "sync"
+
+// Kinds of Change
+const (
+	ChangeCommentCreated = "comment.created"
+	ChangeCommentUpdated = "comment.updated"
+	ChangeCommentDeleted = "comment.deleted"
+	ChangeReactions      = "reactions"
+	ChangeWebmentions    = "webmentions"
+)
+
+// Change describes a successful mutation of a post's comments, reactions
+// or webmentions, so listeners can push live updates to readers
+type Change struct {
+	Kind      string
+	PostSlug  string
+	CommentID int64  // Comment changes only
+	UserID    string // Author of the comment, for comment changes
+	Status    string // Moderation status after the change, empty for deletions
+}
+
+type listeners struct {
+	mu  sync.RWMutex
+	fns []func(Change)
+}
+
+// OnChange registers fn to be called after every successful mutation.
+// fn runs synchronously, so it must not block.
+func (db *DB) OnChange(fn func(Change)) {
+	db.listeners.mu.Lock()
+	defer db.listeners.mu.Unlock()
+	db.listeners.fns = append(db.listeners.fns, fn)
+}
+
+func (db *DB) changed(c Change) {
+	db.listeners.mu.RLock()
+	defer db.listeners.mu.RUnlock()
+	for _, fn := range db.listeners.fns {
+		fn(c)
+	}
+}
@@ -0,0 +1,784 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// DumpVersion identifies the export format. Version 1 dumps only had
+// users, comments and reactions, and still import.
+const DumpVersion = 2
+
+// Dump is a portable copy of the data people create on the site, and of
+// what the site needs to keep talking to other servers: the ActivityPub
+// key, followers and announced posts, and the webmentions already sent.
+// Left out are sessions and sign in links (users log in again on the new
+// host), unconfirmed newsletter subscriptions (they are asked to subscribe
+// again), page view statistics (use backup to keep them) and the search
+// index (the next build reindexes posts).
+//
+// The key for hashing client IPs goes along, so anonymous feedback voters
+// are still recognised.
+//
+// The dump holds the ActivityPub private key, the IP hash key and
+// subscribers' addresses, so store it as carefully as the database.
+type Dump struct {
+	Version         int                  `json:"version"`
+	ExportedAt      time.Time            `json:"exported_at"`
+	Users           []DumpUser           `json:"users"`
+	Comments        []DumpComment        `json:"comments"`
+	Reactions       []DumpReaction       `json:"reactions"`
+	Feedback        []DumpFeedback       `json:"feedback"`
+	Webmentions     []DumpWebmention     `json:"webmentions"`
+	WebmentionsSent []DumpWebmentionSent `json:"webmentions_sent"`
+	ActivityPub     DumpActivityPub      `json:"activitypub"`
+	Subscribers     []DumpSubscriber     `json:"newsletter_subscribers"`
+	HashKey         string               `json:"hash_key,omitempty"` // "" if the server never started
+}
+
+type DumpUser struct {
+	ID            string    `json:"id"`
+	Email         string    `json:"email"`
+	EmailVerified bool      `json:"email_verified,omitempty"`
+	Name          string    `json:"name"`
+	AvatarURL     string    `json:"avatar_url,omitempty"`
+	Role          string    `json:"role"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+type DumpComment struct {
+	ID        int64      `json:"id"`
+	UserID    string     `json:"user_id"`
+	PostSlug  string     `json:"post_slug"`
+	ParentID  int64      `json:"parent_id,omitempty"`
+	Content   string     `json:"content"`
+	Status    string     `json:"status"`
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+}
+
+type DumpReaction struct {
+	UserID    string    `json:"user_id"`
+	PostSlug  string    `json:"post_slug"`
+	Emoji     string    `json:"emoji"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type DumpWebmention struct {
+	Source    string    `json:"source"`
+	Target    string    `json:"target"`
+	PostSlug  string    `json:"post_slug"`
+	Status    string    `json:"status"`
+	Title     string    `json:"title"`
+	Author    string    `json:"author"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// DumpWebmentionSent records a webmention the site sent, so it isn't sent
+// again
+type DumpWebmentionSent struct {
+	Source   string    `json:"source"`
+	Target   string    `json:"target"`
+	Endpoint string    `json:"endpoint"`
+	SentAt   time.Time `json:"sent_at"`
+}
+
+// DumpActivityPub is the site actor's state. Without the posts, a new host
+// would announce the whole back catalogue to the followers.
+type DumpActivityPub struct {
+	Key       *DumpActivityPubKey `json:"key,omitempty"` // nil if ActivityPub was never enabled
+	Followers []DumpFollower      `json:"followers"`
+	Posts     []DumpPublishedPost `json:"posts"`
+}
+
+// DumpActivityPubKey is the actor's PEM encoded private key
+type DumpActivityPubKey struct {
+	PrivateKey string    `json:"private_key"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+type DumpFollower struct {
+	Actor       string    `json:"actor"`
+	Inbox       string    `json:"inbox"`
+	SharedInbox string    `json:"shared_inbox,omitempty"`
+	FollowID    string    `json:"follow_id,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// DumpPublishedPost is a post already announced to followers
+type DumpPublishedPost struct {
+	PostSlug    string    `json:"post_slug"`
+	PublishedAt time.Time `json:"published_at"`
+}
+
+// ImportResult counts the rows restored from a dump
+type ImportResult struct {
+	Users       int
+	Comments    int
+	Reactions   int
+	Feedback    int
+	Webmentions int
+	Followers   int
+	Subscribers int
+}
+
+// Backup writes a consistent copy of the database to path using VACUUM
+// INTO, which is safe while the server is running. The copy is written
+// next to path and renamed into place, so path is never left half written.
+func (db *DB) Backup(path string) error {
+	if _, err := os.Stat(path); err == nil {
+		return fmt.Errorf("%s already exists", path)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	os.Remove(tmp)
+	if _, err := db.conn.Exec(`VACUUM INTO ?`, tmp); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Export writes a Dump as JSON, read in a single transaction so it is
+// consistent
+func (db *DB) Export(w io.Writer) error {
+	version, err := db.currentVersion()
+	if err != nil {
+		return err
+	}
+	if version != SchemaVersion() {
+		return fmt.Errorf("database schema is at version %d, run 'site db migrate' to reach version %d first", version, SchemaVersion())
+	}
+
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	dump := Dump{
+		Version:         DumpVersion,
+		ExportedAt:      time.Now().UTC(),
+		Users:           []DumpUser{},
+		Comments:        []DumpComment{},
+		Reactions:       []DumpReaction{},
+		Feedback:        []DumpFeedback{},
+		Webmentions:     []DumpWebmention{},
+		WebmentionsSent: []DumpWebmentionSent{},
+		ActivityPub: DumpActivityPub{
+			Followers: []DumpFollower{},
+			Posts:     []DumpPublishedPost{},
+		},
+		Subscribers: []DumpSubscriber{},
+	}
+
+	rows, err := tx.Query(`
+		SELECT id, email, email_verified, COALESCE(name, ''), COALESCE(avatar_url, ''), role, created_at
+		FROM users ORDER BY created_at, id
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to export users: %w", err)
+	}
+	for rows.Next() {
+		var u DumpUser
+		if err := rows.Scan(&u.ID, &u.Email, &u.EmailVerified, &u.Name, &u.AvatarURL, &u.Role, &u.CreatedAt); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to export users: %w", err)
+		}
+		dump.Users = append(dump.Users, u)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to export users: %w", err)
+	}
+
+	// By ID, so parents come before their replies on import
+	rows, err = tx.Query(`
+		SELECT id, user_id, post_slug, COALESCE(parent_id, 0), content, status, deleted_at, created_at, updated_at
+		FROM comments ORDER BY id
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to export comments: %w", err)
+	}
+	for rows.Next() {
+		var c DumpComment
+		var deletedAt sql.NullTime
+		if err := rows.Scan(&c.ID, &c.UserID, &c.PostSlug, &c.ParentID, &c.Content, &c.Status, &deletedAt, &c.CreatedAt, &c.UpdatedAt); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to export comments: %w", err)
+		}
+		if deletedAt.Valid {
+			c.DeletedAt = &deletedAt.Time
+		}
+		dump.Comments = append(dump.Comments, c)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to export comments: %w", err)
+	}
+
+	rows, err = tx.Query(`
+		SELECT user_id, post_slug, emoji, created_at FROM reactions ORDER BY id
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to export reactions: %w", err)
+	}
+	for rows.Next() {
+		var r DumpReaction
+		if err := rows.Scan(&r.UserID, &r.PostSlug, &r.Emoji, &r.CreatedAt); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to export reactions: %w", err)
+		}
+		dump.Reactions = append(dump.Reactions, r)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to export reactions: %w", err)
+	}
+
+	if err := exportTables(tx, &dump); err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(dump)
+}
+
+// exportTables reads the tables added in dump version 2
+func exportTables(tx *sql.Tx, dump *Dump) error {
+	err := scanRows(tx, `
+		SELECT post_slug, collection, voter, COALESCE(user_id, ''), helpful, reason, created_at, updated_at
+		FROM feedback ORDER BY id
+	`, nil, func(rows *sql.Rows) error {
+		var f DumpFeedback
+		if err := rows.Scan(&f.PostSlug, &f.Collection, &f.Voter, &f.UserID, &f.Helpful, &f.Reason, &f.CreatedAt, &f.UpdatedAt); err != nil {
+			return err
+		}
+		dump.Feedback = append(dump.Feedback, f)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to export feedback: %w", err)
+	}
+
+	err = scanRows(tx, `
+		SELECT source, target, post_slug, status, title, author, created_at, updated_at
+		FROM webmentions ORDER BY id
+	`, nil, func(rows *sql.Rows) error {
+		var m DumpWebmention
+		if err := rows.Scan(&m.Source, &m.Target, &m.PostSlug, &m.Status, &m.Title, &m.Author, &m.CreatedAt, &m.UpdatedAt); err != nil {
+			return err
+		}
+		dump.Webmentions = append(dump.Webmentions, m)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to export webmentions: %w", err)
+	}
+
+	err = scanRows(tx, `
+		SELECT source, target, endpoint, sent_at FROM webmentions_sent ORDER BY sent_at, source, target
+	`, nil, func(rows *sql.Rows) error {
+		var m DumpWebmentionSent
+		if err := rows.Scan(&m.Source, &m.Target, &m.Endpoint, &m.SentAt); err != nil {
+			return err
+		}
+		dump.WebmentionsSent = append(dump.WebmentionsSent, m)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to export sent webmentions: %w", err)
+	}
+
+	err = tx.QueryRow(`SELECT value FROM secrets WHERE name = ?`, HashKeySecret).Scan(&dump.HashKey)
+	if err != nil && err != sql.ErrNoRows {
+		return fmt.Errorf("failed to export hash key: %w", err)
+	}
+
+	ap := &dump.ActivityPub
+	var key DumpActivityPubKey
+	err = tx.QueryRow(`
+		SELECT private_key, created_at FROM activitypub_keys WHERE id = 1
+	`).Scan(&key.PrivateKey, &key.CreatedAt)
+	if err == nil {
+		ap.Key = &key
+	} else if err != sql.ErrNoRows {
+		return fmt.Errorf("failed to export ActivityPub key: %w", err)
+	}
+
+	err = scanRows(tx, `
+		SELECT actor, inbox, shared_inbox, follow_id, created_at FROM activitypub_followers ORDER BY created_at, actor
+	`, nil, func(rows *sql.Rows) error {
+		var f DumpFollower
+		if err := rows.Scan(&f.Actor, &f.Inbox, &f.SharedInbox, &f.FollowID, &f.CreatedAt); err != nil {
+			return err
+		}
+		ap.Followers = append(ap.Followers, f)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to export followers: %w", err)
+	}
+
+	err = scanRows(tx, `
+		SELECT post_slug, published_at FROM activitypub_posts ORDER BY published_at, post_slug
+	`, nil, func(rows *sql.Rows) error {
+		var p DumpPublishedPost
+		if err := rows.Scan(&p.PostSlug, &p.PublishedAt); err != nil {
+			return err
+		}
+		ap.Posts = append(ap.Posts, p)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to export ActivityPub posts: %w", err)
+	}
+
+	subscribers, err := exportSubscribers(tx, `confirmed = 1`, nil, true)
+	if err != nil {
+		return fmt.Errorf("failed to export newsletter subscribers: %w", err)
+	}
+	dump.Subscribers = subscribers
+	return nil
+}
+
+// exportSubscribers reads the newsletter subscriptions matching where,
+// with the posts sent to each. Unsubscribe tokens are only included with
+// withTokens, for dumps that move them to another host.
+func exportSubscribers(tx *sql.Tx, where string, args []any, withTokens bool) ([]DumpSubscriber, error) {
+	subscribers := []DumpSubscriber{}
+	var ids []int64
+	err := scanRows(tx, `
+		SELECT id, email, series, confirmed, unsubscribe_token, created_at, confirmed_at
+		FROM newsletter_subscribers WHERE `+where+` ORDER BY id
+	`, args, func(rows *sql.Rows) error {
+		var id int64
+		sub := DumpSubscriber{Sent: []DumpNewsletterSent{}}
+		var confirmedAt sql.NullTime
+		if err := rows.Scan(&id, &sub.Email, &sub.Series, &sub.Confirmed, &sub.UnsubscribeToken, &sub.CreatedAt, &confirmedAt); err != nil {
+			return err
+		}
+		if confirmedAt.Valid {
+			sub.ConfirmedAt = &confirmedAt.Time
+		}
+		if !withTokens {
+			sub.UnsubscribeToken = ""
+		}
+		ids = append(ids, id)
+		subscribers = append(subscribers, sub)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for i, id := range ids {
+		sub := &subscribers[i]
+		err := scanRows(tx, `
+			SELECT post_slug, sent_at FROM newsletter_sent WHERE subscriber_id = ? ORDER BY sent_at, post_slug
+		`, []any{id}, func(rows *sql.Rows) error {
+			var sent DumpNewsletterSent
+			if err := rows.Scan(&sent.PostSlug, &sent.SentAt); err != nil {
+				return err
+			}
+			sub.Sent = append(sub.Sent, sent)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return subscribers, nil
+}
+
+// Import restores a dump in a single transaction. Rows are upserted by
+// their IDs, so importing the same dump again changes nothing.
+func (db *DB) Import(r io.Reader) (*ImportResult, error) {
+	var dump Dump
+	if err := json.NewDecoder(r).Decode(&dump); err != nil {
+		return nil, fmt.Errorf("failed to read dump: %w", err)
+	}
+	if dump.Version < 1 || dump.Version > DumpVersion {
+		return nil, fmt.Errorf("unsupported dump version %d (expected %d or older)", dump.Version, DumpVersion)
+	}
+
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	result := &ImportResult{}
+
+	for _, u := range dump.Users {
+		// Older dumps don't say, but email sign in always verifies
+		verified := u.EmailVerified || strings.HasPrefix(u.ID, "email:")
+		if _, err := tx.Exec(`
+			INSERT INTO users (id, email, email_verified, name, avatar_url, role, created_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?)
+			ON CONFLICT(id) DO UPDATE SET
+				email = excluded.email,
+				email_verified = excluded.email_verified,
+				name = excluded.name,
+				avatar_url = excluded.avatar_url,
+				role = excluded.role,
+				created_at = excluded.created_at
+		`, u.ID, u.Email, verified, u.Name, u.AvatarURL, u.Role, u.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to import user %s: %w", u.ID, err)
+		}
+		result.Users++
+	}
+
+	for _, c := range dump.Comments {
+		var parentID sql.NullInt64
+		if c.ParentID != 0 {
+			parentID = sql.NullInt64{Int64: c.ParentID, Valid: true}
+		}
+		var deletedAt sql.NullTime
+		if c.DeletedAt != nil {
+			deletedAt = sql.NullTime{Time: *c.DeletedAt, Valid: true}
+		}
+
+		if _, err := tx.Exec(`
+			INSERT INTO comments (id, user_id, post_slug, parent_id, content, status, deleted_at, created_at, updated_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+			ON CONFLICT(id) DO UPDATE SET
+				user_id = excluded.user_id,
+				post_slug = excluded.post_slug,
+				parent_id = excluded.parent_id,
+				content = excluded.content,
+				status = excluded.status,
+				deleted_at = excluded.deleted_at,
+				created_at = excluded.created_at,
+				updated_at = excluded.updated_at
+		`, c.ID, c.UserID, c.PostSlug, parentID, c.Content, c.Status, deletedAt, c.CreatedAt, c.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("failed to import comment %d: %w", c.ID, err)
+		}
+		result.Comments++
+	}
+
+	for _, r := range dump.Reactions {
+		if _, err := tx.Exec(`
+			INSERT INTO reactions (user_id, post_slug, emoji, created_at)
+			VALUES (?, ?, ?, ?)
+			ON CONFLICT(user_id, post_slug, emoji) DO NOTHING
+		`, r.UserID, r.PostSlug, r.Emoji, r.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to import reaction: %w", err)
+		}
+		result.Reactions++
+	}
+
+	if err := importTables(tx, &dump, result); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// importTables restores the tables added in dump version 2. Rows are
+// matched on their natural keys, since nothing refers to their IDs.
+func importTables(tx *sql.Tx, dump *Dump, result *ImportResult) error {
+	for _, f := range dump.Feedback {
+		if _, err := tx.Exec(`
+			INSERT INTO feedback (post_slug, collection, voter, user_id, helpful, reason, created_at, updated_at)
+			VALUES (?, ?, ?, NULLIF(?, ''), ?, ?, ?, ?)
+			ON CONFLICT(post_slug, voter) DO UPDATE SET
+				collection = excluded.collection,
+				user_id = excluded.user_id,
+				helpful = excluded.helpful,
+				reason = excluded.reason,
+				created_at = excluded.created_at,
+				updated_at = excluded.updated_at
+		`, f.PostSlug, f.Collection, f.Voter, f.UserID, f.Helpful, f.Reason, f.CreatedAt, f.UpdatedAt); err != nil {
+			return fmt.Errorf("failed to import feedback on %s: %w", f.PostSlug, err)
+		}
+		result.Feedback++
+	}
+
+	for _, m := range dump.Webmentions {
+		if _, err := tx.Exec(`
+			INSERT INTO webmentions (source, target, post_slug, status, title, author, created_at, updated_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+			ON CONFLICT(source, target) DO UPDATE SET
+				post_slug = excluded.post_slug,
+				status = excluded.status,
+				title = excluded.title,
+				author = excluded.author,
+				created_at = excluded.created_at,
+				updated_at = excluded.updated_at
+		`, m.Source, m.Target, m.PostSlug, m.Status, m.Title, m.Author, m.CreatedAt, m.UpdatedAt); err != nil {
+			return fmt.Errorf("failed to import webmention from %s: %w", m.Source, err)
+		}
+		result.Webmentions++
+	}
+
+	for _, m := range dump.WebmentionsSent {
+		if _, err := tx.Exec(`
+			INSERT INTO webmentions_sent (source, target, endpoint, sent_at) VALUES (?, ?, ?, ?)
+			ON CONFLICT(source, target) DO NOTHING
+		`, m.Source, m.Target, m.Endpoint, m.SentAt); err != nil {
+			return fmt.Errorf("failed to import sent webmention: %w", err)
+		}
+	}
+
+	if dump.HashKey != "" {
+		if _, err := tx.Exec(`
+			INSERT INTO secrets (name, value, created_at) VALUES (?, ?, ?)
+			ON CONFLICT(name) DO UPDATE SET value = excluded.value
+		`, HashKeySecret, dump.HashKey, time.Now()); err != nil {
+			return fmt.Errorf("failed to import hash key: %w", err)
+		}
+	}
+
+	// The dumped key replaces one the new host generated, so followers
+	// keep verifying the same actor
+	ap := dump.ActivityPub
+	if ap.Key != nil {
+		if _, err := tx.Exec(`
+			INSERT INTO activitypub_keys (id, private_key, created_at) VALUES (1, ?, ?)
+			ON CONFLICT(id) DO UPDATE SET private_key = excluded.private_key, created_at = excluded.created_at
+		`, ap.Key.PrivateKey, ap.Key.CreatedAt); err != nil {
+			return fmt.Errorf("failed to import ActivityPub key: %w", err)
+		}
+	}
+	for _, f := range ap.Followers {
+		if _, err := tx.Exec(`
+			INSERT INTO activitypub_followers (actor, inbox, shared_inbox, follow_id, created_at)
+			VALUES (?, ?, ?, ?, ?)
+			ON CONFLICT(actor) DO UPDATE SET
+				inbox = excluded.inbox,
+				shared_inbox = excluded.shared_inbox,
+				follow_id = excluded.follow_id,
+				created_at = excluded.created_at
+		`, f.Actor, f.Inbox, f.SharedInbox, f.FollowID, f.CreatedAt); err != nil {
+			return fmt.Errorf("failed to import follower %s: %w", f.Actor, err)
+		}
+		result.Followers++
+	}
+	for _, p := range ap.Posts {
+		if _, err := tx.Exec(`
+			INSERT INTO activitypub_posts (post_slug, published_at) VALUES (?, ?)
+			ON CONFLICT(post_slug) DO NOTHING
+		`, p.PostSlug, p.PublishedAt); err != nil {
+			return fmt.Errorf("failed to import ActivityPub post %s: %w", p.PostSlug, err)
+		}
+	}
+
+	for _, sub := range dump.Subscribers {
+		if sub.UnsubscribeToken == "" {
+			return fmt.Errorf("newsletter subscriber %s has no unsubscribe token", sub.Email)
+		}
+		var id int64
+		err := tx.QueryRow(`
+			INSERT INTO newsletter_subscribers (email, series, confirmed, unsubscribe_token, created_at, confirm_sent_at, confirmed_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?)
+			ON CONFLICT(email, series) DO UPDATE SET
+				confirmed = excluded.confirmed,
+				confirm_token_hash = NULL,
+				unsubscribe_token = excluded.unsubscribe_token,
+				created_at = excluded.created_at,
+				confirmed_at = excluded.confirmed_at
+			RETURNING id
+		`, sub.Email, sub.Series, sub.Confirmed, sub.UnsubscribeToken, sub.CreatedAt, sub.CreatedAt, sub.ConfirmedAt).Scan(&id)
+		if err != nil {
+			return fmt.Errorf("failed to import newsletter subscriber %s: %w", sub.Email, err)
+		}
+		for _, sent := range sub.Sent {
+			if _, err := tx.Exec(`
+				INSERT INTO newsletter_sent (subscriber_id, post_slug, sent_at) VALUES (?, ?, ?)
+				ON CONFLICT(subscriber_id, post_slug) DO NOTHING
+			`, id, sent.PostSlug, sent.SentAt); err != nil {
+				return fmt.Errorf("failed to import sent newsletter: %w", err)
+			}
+		}
+		result.Subscribers++
+	}
+	return nil
+}
+
+// UserDump is everything stored about one user, for data access requests
+type UserDump struct {
+	ExportedAt time.Time      `json:"exported_at"`
+	User       DumpUser       `json:"user"`
+	Comments   []DumpComment  `json:"comments"`
+	Reactions  []DumpReaction `json:"reactions"`
+	Feedback   []DumpFeedback `json:"feedback"`
+	Sessions   []DumpSession  `json:"sessions"`
+	// Newsletter subscriptions of the user's email address
+	Newsletter []DumpSubscriber `json:"newsletter"`
+}
+
+// DumpFeedback is a vote on whether a docs page was helpful. Voter and
+// UserID are only filled in full dumps.
+type DumpFeedback struct {
+	PostSlug   string    `json:"post_slug"`
+	Collection string    `json:"collection"`
+	Voter      string    `json:"voter,omitempty"`
+	UserID     string    `json:"user_id,omitempty"`
+	Helpful    bool      `json:"helpful"`
+	Reason     string    `json:"reason"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// DumpSubscriber is a newsletter subscription with the posts mailed to it.
+// UnsubscribeToken is only filled in full dumps, so links in emails
+// already sent keep working on a new host.
+type DumpSubscriber struct {
+	Email            string               `json:"email"`
+	Series           string               `json:"series"`
+	Confirmed        bool                 `json:"confirmed"`
+	UnsubscribeToken string               `json:"unsubscribe_token,omitempty"`
+	CreatedAt        time.Time            `json:"created_at"`
+	ConfirmedAt      *time.Time           `json:"confirmed_at,omitempty"`
+	Sent             []DumpNewsletterSent `json:"sent"`
+}
+
+type DumpNewsletterSent struct {
+	PostSlug string    `json:"post_slug"`
+	SentAt   time.Time `json:"sent_at"`
+}
+
+// DumpSession describes a login session without its token
+type DumpSession struct {
+	CreatedAt  time.Time `json:"created_at"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	UserAgent  string    `json:"user_agent"`
+}
+
+// ExportUser returns everything stored about a user, or nil if there is
+// no such user
+func (db *DB) ExportUser(userID string) (*UserDump, error) {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	dump := &UserDump{
+		ExportedAt: time.Now().UTC(),
+		Comments:   []DumpComment{},
+		Reactions:  []DumpReaction{},
+		Feedback:   []DumpFeedback{},
+		Sessions:   []DumpSession{},
+		Newsletter: []DumpSubscriber{},
+	}
+
+	u := &dump.User
+	err = tx.QueryRow(`
+		SELECT id, email, email_verified, COALESCE(name, ''), COALESCE(avatar_url, ''), role, created_at
+		FROM users WHERE id = ?
+	`, userID).Scan(&u.ID, &u.Email, &u.EmailVerified, &u.Name, &u.AvatarURL, &u.Role, &u.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := tx.Query(`
+		SELECT id, user_id, post_slug, COALESCE(parent_id, 0), content, status, deleted_at, created_at, updated_at
+		FROM comments WHERE user_id = ? AND deleted_at IS NULL ORDER BY id
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var c DumpComment
+		var deletedAt sql.NullTime
+		if err := rows.Scan(&c.ID, &c.UserID, &c.PostSlug, &c.ParentID, &c.Content, &c.Status, &deletedAt, &c.CreatedAt, &c.UpdatedAt); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		dump.Comments = append(dump.Comments, c)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = tx.Query(`
+		SELECT user_id, post_slug, emoji, created_at FROM reactions WHERE user_id = ? ORDER BY id
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var r DumpReaction
+		if err := rows.Scan(&r.UserID, &r.PostSlug, &r.Emoji, &r.CreatedAt); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		dump.Reactions = append(dump.Reactions, r)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = tx.Query(`
+		SELECT post_slug, collection, helpful, reason, created_at, updated_at FROM feedback
+		WHERE user_id = ? ORDER BY id
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var f DumpFeedback
+		if err := rows.Scan(&f.PostSlug, &f.Collection, &f.Helpful, &f.Reason, &f.CreatedAt, &f.UpdatedAt); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		dump.Feedback = append(dump.Feedback, f)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = tx.Query(`
+		SELECT created_at, last_seen_at, expires_at, user_agent FROM sessions
+		WHERE user_id = ? AND replaced_by IS NULL ORDER BY created_at
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var sess DumpSession
+		if err := rows.Scan(&sess.CreatedAt, &sess.LastSeenAt, &sess.ExpiresAt, &sess.UserAgent); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		dump.Sessions = append(dump.Sessions, sess)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if u.Email != "" {
+		subscribers, err := exportSubscribers(tx, `email = lower(?)`, []any{u.Email}, false)
+		if err != nil {
+			return nil, err
+		}
+		dump.Newsletter = subscribers
+	}
+
+	return dump, nil
+}
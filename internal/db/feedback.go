@@ -0,0 +1,109 @@
+package db
+
+import (
+	"database/sql"
+	"time"
+
+	"site/internal/models"
+)
+
+// RecordFeedback stores a vote on a docs page, replacing the voter's
+// earlier vote on it
+func (db *DB) RecordFeedback(f *models.Feedback) error {
+	now := time.Now()
+	_, err := db.conn.Exec(`
+		INSERT INTO feedback (post_slug, collection, voter, user_id, helpful, reason, created_at, updated_at)
+		VALUES (?, ?, ?, NULLIF(?, ''), ?, ?, ?, ?)
+		ON CONFLICT(post_slug, voter) DO UPDATE SET
+			collection = excluded.collection,
+			helpful = excluded.helpful,
+			reason = excluded.reason,
+			updated_at = excluded.updated_at
+	`, f.PostSlug, f.Collection, f.Voter, f.UserID, f.Helpful, f.Reason, now, now)
+	return err
+}
+
+// GetFeedback returns a voter's vote on a page, or nil if they haven't
+// voted
+func (db *DB) GetFeedback(postSlug, voter string) (*models.Feedback, error) {
+	var f models.Feedback
+	var userID sql.NullString
+	err := db.conn.QueryRow(`
+		SELECT id, post_slug, collection, voter, user_id, helpful, reason, created_at, updated_at
+		FROM feedback WHERE post_slug = ? AND voter = ?
+	`, postSlug, voter).Scan(&f.ID, &f.PostSlug, &f.Collection, &f.Voter, &userID, &f.Helpful, &f.Reason, &f.CreatedAt, &f.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	f.UserID = userID.String
+	return &f, nil
+}
+
+// FeedbackReport counts the votes cast or changed since a time, by docs
+// collection and page, keeping up to reasons of each page's latest
+// reasons. An empty collection reports every collection.
+func (db *DB) FeedbackReport(since time.Time, collection string, reasons int) ([]models.CollectionFeedback, error) {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	report := []models.CollectionFeedback{}
+	pages := make(map[string]*models.PageFeedback)
+
+	err = scanRows(tx, `
+		SELECT collection, post_slug, SUM(helpful), SUM(1 - helpful) FROM feedback
+		WHERE updated_at >= ? AND (? = '' OR collection = ?)
+		GROUP BY collection, post_slug
+		ORDER BY collection, SUM(1 - helpful) DESC, SUM(helpful), post_slug
+	`, []any{since, collection, collection}, func(rows *sql.Rows) error {
+		var name string
+		p := models.PageFeedback{Reasons: []models.FeedbackReason{}}
+		if err := rows.Scan(&name, &p.Post, &p.Yes, &p.No); err != nil {
+			return err
+		}
+		if len(report) == 0 || report[len(report)-1].Collection != name {
+			report = append(report, models.CollectionFeedback{Collection: name})
+		}
+		c := &report[len(report)-1]
+		c.Yes += p.Yes
+		c.No += p.No
+		c.Pages = append(c.Pages, p)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Pages are only appended above, so pointers into them are stable now
+	for i := range report {
+		for j := range report[i].Pages {
+			pages[report[i].Pages[j].Post] = &report[i].Pages[j]
+		}
+	}
+
+	err = scanRows(tx, `
+		SELECT post_slug, helpful, reason, updated_at FROM feedback
+		WHERE updated_at >= ? AND (? = '' OR collection = ?) AND reason != ''
+		ORDER BY updated_at DESC
+	`, []any{since, collection, collection}, func(rows *sql.Rows) error {
+		var postSlug string
+		var r models.FeedbackReason
+		if err := rows.Scan(&postSlug, &r.Helpful, &r.Reason, &r.UpdatedAt); err != nil {
+			return err
+		}
+		if p := pages[postSlug]; p != nil && len(p.Reasons) < reasons {
+			p.Reasons = append(p.Reasons, r)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return report, nil
+}
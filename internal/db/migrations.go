@@ -0,0 +1,421 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// migration is one numbered schema change. Migrations run in order, each
+// in its own transaction, and are recorded in schema_migrations.
+type migration struct {
+	version int
+	name    string
+	up      func(tx *sql.Tx) error
+}
+
+// migrations is the schema history. Append new migrations with the next
+// version number; never edit or reorder ones that have shipped.
+var migrations = []migration{
+	{1, "initial schema", execSQL(`
+		CREATE TABLE IF NOT EXISTS users (
+			id TEXT PRIMARY KEY,
+			email TEXT NOT NULL,
+			name TEXT,
+			avatar_url TEXT,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		);
+
+		CREATE TABLE IF NOT EXISTS reactions (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id TEXT NOT NULL REFERENCES users(id),
+			post_slug TEXT NOT NULL,
+			emoji TEXT NOT NULL,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE(user_id, post_slug, emoji)
+		);
+
+		CREATE INDEX IF NOT EXISTS idx_reactions_post ON reactions(post_slug);
+
+		CREATE TABLE IF NOT EXISTS sessions (
+			token TEXT PRIMARY KEY,
+			user_id TEXT NOT NULL REFERENCES users(id),
+			expires_at DATETIME NOT NULL
+		);
+
+		CREATE INDEX IF NOT EXISTS idx_sessions_expires ON sessions(expires_at);
+
+		CREATE TABLE IF NOT EXISTS comments (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id TEXT NOT NULL REFERENCES users(id),
+			post_slug TEXT NOT NULL,
+			content TEXT NOT NULL,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		);
+
+		CREATE INDEX IF NOT EXISTS idx_comments_post ON comments(post_slug);
+		CREATE INDEX IF NOT EXISTS idx_comments_created ON comments(post_slug, created_at DESC);
+
+		CREATE VIRTUAL TABLE IF NOT EXISTS search_index USING fts5(
+			slug UNINDEXED,
+			collection_slug UNINDEXED,
+			title,
+			description,
+			content,
+			type UNINDEXED,
+			url UNINDEXED,
+			date UNINDEXED
+		);
+	`)},
+	// Databases from before versioned migrations may already have the
+	// columns below, so these migrations only add what is missing
+	{2, "user roles and comment moderation", func(tx *sql.Tx) error {
+		if err := addColumn(tx, "users", "role", "TEXT NOT NULL DEFAULT 'user'"); err != nil {
+			return err
+		}
+		if err := addColumn(tx, "comments", "status", "TEXT NOT NULL DEFAULT 'approved'"); err != nil {
+			return err
+		}
+		_, err := tx.Exec(`CREATE INDEX IF NOT EXISTS idx_comments_status ON comments(status, created_at DESC)`)
+		return err
+	}},
+	{3, "threaded comments", func(tx *sql.Tx) error {
+		if err := addColumn(tx, "comments", "parent_id", "INTEGER REFERENCES comments(id)"); err != nil {
+			return err
+		}
+		if err := addColumn(tx, "comments", "deleted_at", "DATETIME"); err != nil {
+			return err
+		}
+		_, err := tx.Exec(`CREATE INDEX IF NOT EXISTS idx_comments_parent ON comments(parent_id)`)
+		return err
+	}},
+	{4, "session metadata", func(tx *sql.Tx) error {
+		columns := []struct{ name, def string }{
+			{"created_at", "DATETIME"},
+			{"last_seen_at", "DATETIME"},
+			{"user_agent", "TEXT NOT NULL DEFAULT ''"},
+			{"ip_hash", "TEXT NOT NULL DEFAULT ''"},
+			{"replaced_by", "TEXT"},
+		}
+		for _, c := range columns {
+			if err := addColumn(tx, "sessions", c.name, c.def); err != nil {
+				return err
+			}
+		}
+		// Existing sessions have no history, so they start from the upgrade
+		_, err := tx.Exec(`
+			UPDATE sessions SET created_at = CURRENT_TIMESTAMP WHERE created_at IS NULL;
+			UPDATE sessions SET last_seen_at = CURRENT_TIMESTAMP WHERE last_seen_at IS NULL;
+			CREATE INDEX IF NOT EXISTS idx_sessions_user ON sessions(user_id);
+		`)
+		return err
+	}},
+	{5, "email sign in links", execSQL(`
+		CREATE TABLE IF NOT EXISTS login_tokens (
+			token_hash TEXT PRIMARY KEY,
+			email TEXT NOT NULL,
+			redirect TEXT NOT NULL DEFAULT '/',
+			created_at DATETIME NOT NULL,
+			expires_at DATETIME NOT NULL
+		);
+
+		CREATE INDEX IF NOT EXISTS idx_login_tokens_email ON login_tokens(email, created_at);
+		CREATE INDEX IF NOT EXISTS idx_login_tokens_expires ON login_tokens(expires_at);
+	`)},
+	{6, "webmentions", execSQL(`
+		CREATE TABLE IF NOT EXISTS webmentions (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			source TEXT NOT NULL,
+			target TEXT NOT NULL,
+			post_slug TEXT NOT NULL,
+			status TEXT NOT NULL DEFAULT 'queued',
+			title TEXT NOT NULL DEFAULT '',
+			author TEXT NOT NULL DEFAULT '',
+			created_at DATETIME NOT NULL,
+			updated_at DATETIME NOT NULL,
+			UNIQUE(source, target)
+		);
+
+		CREATE INDEX IF NOT EXISTS idx_webmentions_post ON webmentions(post_slug, status);
+		CREATE INDEX IF NOT EXISTS idx_webmentions_status ON webmentions(status, created_at DESC);
+
+		CREATE TABLE IF NOT EXISTS webmentions_sent (
+			source TEXT NOT NULL,
+			target TEXT NOT NULL,
+			endpoint TEXT NOT NULL,
+			sent_at DATETIME NOT NULL,
+			PRIMARY KEY (source, target)
+		);
+	`)},
+	{7, "activitypub", execSQL(`
+		CREATE TABLE IF NOT EXISTS activitypub_keys (
+			id INTEGER PRIMARY KEY CHECK (id = 1),
+			private_key TEXT NOT NULL,
+			created_at DATETIME NOT NULL
+		);
+
+		CREATE TABLE IF NOT EXISTS activitypub_followers (
+			actor TEXT PRIMARY KEY,
+			inbox TEXT NOT NULL,
+			shared_inbox TEXT NOT NULL DEFAULT '',
+			follow_id TEXT NOT NULL DEFAULT '',
+			created_at DATETIME NOT NULL
+		);
+
+		CREATE TABLE IF NOT EXISTS activitypub_posts (
+			post_slug TEXT PRIMARY KEY,
+			published_at DATETIME NOT NULL
+		);
+	`)},
+	{8, "analytics", execSQL(`
+		CREATE TABLE IF NOT EXISTS analytics_views (
+			day TEXT NOT NULL,
+			path TEXT NOT NULL,
+			visitor TEXT NOT NULL,
+			referrer TEXT NOT NULL DEFAULT '',
+			agent TEXT NOT NULL
+		);
+
+		CREATE INDEX IF NOT EXISTS idx_analytics_views_day ON analytics_views(day);
+
+		CREATE TABLE IF NOT EXISTS analytics_salts (
+			day TEXT PRIMARY KEY,
+			salt TEXT NOT NULL
+		);
+
+		CREATE TABLE IF NOT EXISTS analytics_days (
+			day TEXT PRIMARY KEY,
+			views INTEGER NOT NULL,
+			visitors INTEGER NOT NULL
+		);
+
+		CREATE TABLE IF NOT EXISTS analytics_pages (
+			day TEXT NOT NULL,
+			path TEXT NOT NULL,
+			views INTEGER NOT NULL,
+			visitors INTEGER NOT NULL,
+			PRIMARY KEY (day, path)
+		);
+
+		CREATE TABLE IF NOT EXISTS analytics_referrers (
+			day TEXT NOT NULL,
+			host TEXT NOT NULL,
+			views INTEGER NOT NULL,
+			PRIMARY KEY (day, host)
+		);
+
+		CREATE TABLE IF NOT EXISTS analytics_agents (
+			day TEXT NOT NULL,
+			class TEXT NOT NULL,
+			views INTEGER NOT NULL,
+			PRIMARY KEY (day, class)
+		);
+	`)},
+	{9, "feedback", execSQL(`
+		CREATE TABLE IF NOT EXISTS feedback (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			post_slug TEXT NOT NULL,
+			collection TEXT NOT NULL,
+			voter TEXT NOT NULL,
+			user_id TEXT REFERENCES users(id),
+			helpful INTEGER NOT NULL,
+			reason TEXT NOT NULL DEFAULT '',
+			created_at DATETIME NOT NULL,
+			updated_at DATETIME NOT NULL,
+			UNIQUE(post_slug, voter)
+		);
+
+		CREATE INDEX IF NOT EXISTS idx_feedback_collection ON feedback(collection, updated_at);
+		CREATE INDEX IF NOT EXISTS idx_feedback_user ON feedback(user_id);
+	`)},
+	{10, "newsletter", execSQL(`
+		CREATE TABLE IF NOT EXISTS newsletter_subscribers (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			email TEXT NOT NULL,
+			series TEXT NOT NULL DEFAULT '',
+			confirmed INTEGER NOT NULL DEFAULT 0,
+			confirm_token_hash TEXT UNIQUE,
+			unsubscribe_token TEXT NOT NULL UNIQUE,
+			created_at DATETIME NOT NULL,
+			confirm_sent_at DATETIME NOT NULL,
+			confirmed_at DATETIME,
+			UNIQUE(email, series)
+		);
+
+		CREATE TABLE IF NOT EXISTS newsletter_sent (
+			subscriber_id INTEGER NOT NULL REFERENCES newsletter_subscribers(id),
+			post_slug TEXT NOT NULL,
+			sent_at DATETIME NOT NULL,
+			PRIMARY KEY (subscriber_id, post_slug)
+		);
+
+		CREATE INDEX IF NOT EXISTS idx_newsletter_subscribers_pending ON newsletter_subscribers(confirmed, confirm_sent_at);
+	`)},
+	// The admins list only matches verified emails. Email sign in proves
+	// the address; other users are verified again at their next sign in.
+	{11, "email verification", func(tx *sql.Tx) error {
+		if err := addColumn(tx, "users", "email_verified", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+			return err
+		}
+		_, err := tx.Exec(`UPDATE users SET email_verified = 1 WHERE id LIKE 'email:%'`)
+		return err
+	}},
+	// Used sign in links are kept until they expire so they still count
+	// towards the send limit
+	{12, "used sign in links", func(tx *sql.Tx) error {
+		return addColumn(tx, "login_tokens", "used_at", "DATETIME")
+	}},
+	{13, "server secrets", execSQL(`
+		CREATE TABLE IF NOT EXISTS secrets (
+			name TEXT PRIMARY KEY,
+			value TEXT NOT NULL,
+			created_at DATETIME NOT NULL
+		);
+	`)},
+}
+
+// SchemaVersion is the schema version this binary migrates databases to
+func SchemaVersion() int {
+	return migrations[len(migrations)-1].version
+}
+
+// MigrationStatus describes a migration and whether it has been applied
+type MigrationStatus struct {
+	Version   int
+	Name      string
+	Applied   bool
+	AppliedAt time.Time
+	Unknown   bool // Applied by a newer binary
+}
+
+// Migrate applies pending migrations and returns how many ran. It refuses
+// to touch a database whose schema is newer than this binary.
+func (db *DB) Migrate() (int, error) {
+	current, err := db.currentVersion()
+	if err != nil {
+		return 0, err
+	}
+	if current > SchemaVersion() {
+		return 0, fmt.Errorf("database schema version %d is newer than this binary supports (%d), upgrade the binary", current, SchemaVersion())
+	}
+
+	applied := 0
+	for _, m := range migrations {
+		if m.version <= current {
+			continue
+		}
+		if err := db.apply(m); err != nil {
+			return applied, fmt.Errorf("failed to apply migration %d (%s): %w", m.version, m.name, err)
+		}
+		applied++
+	}
+	return applied, nil
+}
+
+func (db *DB) apply(m migration) error {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := m.up(tx); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`
+		INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)
+	`, m.version, m.name, time.Now()); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// MigrationStatus lists every known migration, plus any the database
+// records that this binary doesn't know about
+func (db *DB) MigrationStatus() ([]MigrationStatus, error) {
+	if err := db.ensureMigrationsTable(); err != nil {
+		return nil, err
+	}
+
+	rows, err := db.conn.Query(`SELECT version, name, applied_at FROM schema_migrations ORDER BY version`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := make(map[int]MigrationStatus)
+	for rows.Next() {
+		var s MigrationStatus
+		if err := rows.Scan(&s.Version, &s.Name, &s.AppliedAt); err != nil {
+			return nil, err
+		}
+		s.Applied = true
+		applied[s.Version] = s
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var statuses []MigrationStatus
+	for _, m := range migrations {
+		s, ok := applied[m.version]
+		if !ok {
+			s = MigrationStatus{Version: m.version, Name: m.name}
+		}
+		statuses = append(statuses, s)
+		delete(applied, m.version)
+	}
+	for version := SchemaVersion() + 1; len(applied) > 0; version++ {
+		if s, ok := applied[version]; ok {
+			s.Unknown = true
+			statuses = append(statuses, s)
+			delete(applied, version)
+		}
+	}
+	return statuses, nil
+}
+
+// currentVersion returns the highest applied migration, 0 for a new database
+func (db *DB) currentVersion() (int, error) {
+	if err := db.ensureMigrationsTable(); err != nil {
+		return 0, err
+	}
+
+	var version int
+	err := db.conn.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&version)
+	return version, err
+}
+
+func (db *DB) ensureMigrationsTable() error {
+	_, err := db.conn.Exec(`
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version INTEGER PRIMARY KEY,
+			name TEXT NOT NULL,
+			applied_at DATETIME NOT NULL
+		)
+	`)
+	return err
+}
+
+// execSQL returns a migration step that runs a batch of statements
+func execSQL(query string) func(tx *sql.Tx) error {
+	return func(tx *sql.Tx) error {
+		_, err := tx.Exec(query)
+		return err
+	}
+}
+
+// addColumn adds a column to an existing table unless it is already there
+func addColumn(tx *sql.Tx, table, column, definition string) error {
+	var exists bool
+	err := tx.QueryRow(`
+		SELECT EXISTS(SELECT 1 FROM pragma_table_info(?) WHERE name = ?)
+	`, table, column).Scan(&exists)
+	if err != nil || exists {
+		return err
+	}
+
+	_, err = tx.Exec(`ALTER TABLE ` + table + ` ADD COLUMN ` + column + ` ` + definition)
+	return err
+}
@@ -0,0 +1,165 @@
+package db
+
+import (
+	"database/sql"
+	"time"
+
+	"site/internal/models"
+)
+
+const subscriberColumns = `id, email, series, confirmed, unsubscribe_token, created_at, confirm_sent_at, confirmed_at`
+
+// scanSubscriber reads a row selected with subscriberColumns
+func scanSubscriber(row interface{ Scan(...any) error }) (*models.Subscriber, error) {
+	var s models.Subscriber
+	var confirmedAt sql.NullTime
+	err := row.Scan(&s.ID, &s.Email, &s.Series, &s.Confirmed, &s.UnsubscribeToken, &s.CreatedAt, &s.ConfirmSentAt, &confirmedAt)
+	if err != nil {
+		return nil, err
+	}
+	s.ConfirmedAt = confirmedAt.Time
+	return &s, nil
+}
+
+// GetSubscriber returns an address's subscription to a series ("" for the
+// whole site), or nil if there is none
+func (db *DB) GetSubscriber(email, series string) (*models.Subscriber, error) {
+	s, err := scanSubscriber(db.conn.QueryRow(`
+		SELECT `+subscriberColumns+` FROM newsletter_subscribers WHERE email = ? AND series = ?
+	`, email, series))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return s, err
+}
+
+// SubscribeNewsletter stores an unconfirmed subscription, or gives an
+// unconfirmed one a new confirmation token. Confirmed subscriptions are
+// left alone.
+func (db *DB) SubscribeNewsletter(email, series, confirmTokenHash, unsubscribeToken string) error {
+	now := time.Now()
+	_, err := db.conn.Exec(`
+		INSERT INTO newsletter_subscribers (email, series, confirm_token_hash, unsubscribe_token, created_at, confirm_sent_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT(email, series) DO UPDATE SET
+			confirm_token_hash = excluded.confirm_token_hash,
+			confirm_sent_at = excluded.confirm_sent_at
+		WHERE confirmed = 0
+	`, email, series, confirmTokenHash, unsubscribeToken, now, now)
+	return err
+}
+
+// ConfirmSubscriber confirms the subscription a confirmation token was
+// sent for, if it was sent after sentAfter. It returns nil if the token
+// doesn't exist, was already used or has expired.
+func (db *DB) ConfirmSubscriber(tokenHash string, sentAfter time.Time) (*models.Subscriber, error) {
+	s, err := scanSubscriber(db.conn.QueryRow(`
+		UPDATE newsletter_subscribers SET confirmed = 1, confirmed_at = ?, confirm_token_hash = NULL
+		WHERE confirm_token_hash = ? AND confirmed = 0 AND confirm_sent_at > ?
+		RETURNING `+subscriberColumns+`
+	`, time.Now(), tokenHash, sentAfter))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return s, err
+}
+
+// Unsubscribe deletes the subscription an unsubscribe token belongs to,
+// along with the record of what was sent to it. It returns nil if there
+// is no such subscription.
+func (db *DB) Unsubscribe(token string) (*models.Subscriber, error) {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	s, err := scanSubscriber(tx.QueryRow(`
+		SELECT `+subscriberColumns+` FROM newsletter_subscribers WHERE unsubscribe_token = ?
+	`, token))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := tx.Exec(`DELETE FROM newsletter_sent WHERE subscriber_id = ?`, s.ID); err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec(`DELETE FROM newsletter_subscribers WHERE id = ?`, s.ID); err != nil {
+		return nil, err
+	}
+	return s, tx.Commit()
+}
+
+// DeleteUnconfirmedSubscribers forgets addresses whose confirmation link
+// was sent before a time and never used
+func (db *DB) DeleteUnconfirmedSubscribers(before time.Time) error {
+	_, err := db.conn.Exec(`
+		DELETE FROM newsletter_subscribers WHERE confirmed = 0 AND confirm_sent_at < ?
+	`, before)
+	return err
+}
+
+// ConfirmedSubscribers lists the subscriptions that receive newsletters
+func (db *DB) ConfirmedSubscribers() ([]models.Subscriber, error) {
+	rows, err := db.conn.Query(`
+		SELECT ` + subscriberColumns + ` FROM newsletter_subscribers WHERE confirmed = 1 ORDER BY id
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var subscribers []models.Subscriber
+	for rows.Next() {
+		s, err := scanSubscriber(rows)
+		if err != nil {
+			return nil, err
+		}
+		subscribers = append(subscribers, *s)
+	}
+	return subscribers, rows.Err()
+}
+
+// NewsletterSent returns the IDs of the posts already mailed to a
+// subscriber
+func (db *DB) NewsletterSent(subscriberID int64) (map[string]bool, error) {
+	rows, err := db.conn.Query(`
+		SELECT post_slug FROM newsletter_sent WHERE subscriber_id = ?
+	`, subscriberID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sent := make(map[string]bool)
+	for rows.Next() {
+		var postSlug string
+		if err := rows.Scan(&postSlug); err != nil {
+			return nil, err
+		}
+		sent[postSlug] = true
+	}
+	return sent, rows.Err()
+}
+
+// RecordNewsletterSent records that posts were mailed to a subscriber
+func (db *DB) RecordNewsletterSent(subscriberID int64, postSlugs []string) error {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	for _, postSlug := range postSlugs {
+		if _, err := tx.Exec(`
+			INSERT OR IGNORE INTO newsletter_sent (subscriber_id, post_slug, sent_at) VALUES (?, ?, ?)
+		`, subscriberID, postSlug, now); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
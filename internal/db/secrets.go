@@ -0,0 +1,33 @@
+package db
+
+import (
+	"database/sql"
+	"time"
+)
+
+// HashKeySecret names the key for hashing client IPs in sessions and
+// anonymous feedback votes
+const HashKeySecret = "hash_key"
+
+// Secret returns a server secret by name, creating it with generate the
+// first time, so it stays the same across restarts
+func (db *DB) Secret(name string, generate func() (string, error)) (string, error) {
+	var value string
+	err := db.conn.QueryRow(`SELECT value FROM secrets WHERE name = ?`, name).Scan(&value)
+	if err != sql.ErrNoRows {
+		return value, err
+	}
+
+	if value, err = generate(); err != nil {
+		return "", err
+	}
+	// Another process may have won the race; keep whichever was first
+	if _, err := db.conn.Exec(`
+		INSERT INTO secrets (name, value, created_at) VALUES (?, ?, ?)
+		ON CONFLICT(name) DO NOTHING
+	`, name, value, time.Now()); err != nil {
+		return "", err
+	}
+	err = db.conn.QueryRow(`SELECT value FROM secrets WHERE name = ?`, name).Scan(&value)
+	return value, err
+}
@@ -2,9 +2,13 @@ package db
 
 import (
 	"database/sql"
+	"fmt"
+	"net/url"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
+	"sync"
 	"time"
 
 	"site/internal/models"
@@ -13,21 +17,57 @@ import (
 )
 
 type DB struct {
-	conn *sql.DB
+	conn      *sql.DB
+	listeners listeners
+	stmts     map[string]*sql.Stmt // Prepared hot queries, by query text
+	stmtsLock sync.Mutex
 }
 
+// DefaultPath is where the database lives unless configured otherwise
+const DefaultPath = "data/sqlite.db"
+
+// Connection settings. WAL lets readers proceed while a rebuild writes,
+// and the busy timeout makes writers queue for the lock instead of failing.
+const (
+	busyTimeout  = 5 * time.Second
+	maxOpenConns = 8
+	connMaxIdle  = 5 * time.Minute
+)
+
+// New opens the database at path and applies pending migrations
 func New(path string) (*DB, error) {
+	db, err := Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := db.Migrate(); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return db, nil
+}
+
+// Open opens the database at path without migrating it
+func Open(path string) (*DB, error) {
 	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
 		return nil, err
 	}
 
-	conn, err := sql.Open("sqlite", path)
+	conn, err := sql.Open("sqlite", dsn(path))
 	if err != nil {
 		return nil, err
 	}
+	conn.SetMaxOpenConns(maxOpenConns)
+	conn.SetMaxIdleConns(maxOpenConns)
+	conn.SetConnMaxIdleTime(connMaxIdle)
+
+	db := &DB{conn: conn, stmts: make(map[string]*sql.Stmt)}
 
-	db := &DB{conn: conn}
-	if err := db.init(); err != nil {
+	// Fail here rather than on the first query if the file can't be opened
+	var journalMode string
+	if err := conn.QueryRow(`PRAGMA journal_mode`).Scan(&journalMode); err != nil {
 		conn.Close()
 		return nil, err
 	}
@@ -35,85 +75,114 @@ func New(path string) (*DB, error) {
 	return db, nil
 }
 
-func (db *DB) init() error {
-	schema := `
-		CREATE TABLE IF NOT EXISTS users (
-			id TEXT PRIMARY KEY,
-			email TEXT NOT NULL,
-			name TEXT,
-			avatar_url TEXT,
-			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
-		);
-
-		CREATE TABLE IF NOT EXISTS reactions (
-			id INTEGER PRIMARY KEY AUTOINCREMENT,
-			user_id TEXT NOT NULL REFERENCES users(id),
-			post_slug TEXT NOT NULL,
-			emoji TEXT NOT NULL,
-			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-			UNIQUE(user_id, post_slug, emoji)
-		);
-
-		CREATE INDEX IF NOT EXISTS idx_reactions_post ON reactions(post_slug);
-
-		CREATE TABLE IF NOT EXISTS sessions (
-			token TEXT PRIMARY KEY,
-			user_id TEXT NOT NULL REFERENCES users(id),
-			expires_at DATETIME NOT NULL
-		);
-
-		CREATE INDEX IF NOT EXISTS idx_sessions_expires ON sessions(expires_at);
-
-		CREATE TABLE IF NOT EXISTS comments (
-			id INTEGER PRIMARY KEY AUTOINCREMENT,
-			user_id TEXT NOT NULL REFERENCES users(id),
-			post_slug TEXT NOT NULL,
-			content TEXT NOT NULL,
-			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
-		);
-
-		CREATE INDEX IF NOT EXISTS idx_comments_post ON comments(post_slug);
-		CREATE INDEX IF NOT EXISTS idx_comments_created ON comments(post_slug, created_at DESC);
-
-		CREATE VIRTUAL TABLE IF NOT EXISTS search_index USING fts5(
-			slug UNINDEXED,
-			collection_slug UNINDEXED,
-			title,
-			description,
-			content,
-			type UNINDEXED,
-			url UNINDEXED,
-			date UNINDEXED
-		);
-	`
-
-	_, err := db.conn.Exec(schema)
-	return err
+// dsn returns the connection string for the database at path. The pragmas
+// are applied to every connection in the pool, since foreign keys and the
+// busy timeout are per-connection settings.
+func dsn(path string) string {
+	params := url.Values{}
+	params.Add("_pragma", "journal_mode(WAL)")
+	params.Add("_pragma", fmt.Sprintf("busy_timeout(%d)", busyTimeout.Milliseconds()))
+	params.Add("_pragma", "foreign_keys(ON)")
+	params.Add("_pragma", "synchronous(NORMAL)")
+	// Take the write lock when a transaction starts, so two transactions
+	// can't both read and then deadlock upgrading to write
+	params.Set("_txlock", "immediate")
+	return "file:" + path + "?" + params.Encode()
+}
+
+// stmt returns a cached prepared statement for a hot query
+func (db *DB) stmt(query string) (*sql.Stmt, error) {
+	db.stmtsLock.Lock()
+	defer db.stmtsLock.Unlock()
+
+	if stmt, ok := db.stmts[query]; ok {
+		return stmt, nil
+	}
+	stmt, err := db.conn.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	db.stmts[query] = stmt
+	return stmt, nil
+}
+
+// queryRow runs a hot single-row query through the statement cache
+func (db *DB) queryRow(query string, args ...any) *sql.Row {
+	stmt, err := db.stmt(query)
+	if err != nil {
+		// Let the row carry the error
+		return db.conn.QueryRow(query, args...)
+	}
+	return stmt.QueryRow(args...)
+}
+
+// query runs a hot query through the statement cache
+func (db *DB) query(query string, args ...any) (*sql.Rows, error) {
+	stmt, err := db.stmt(query)
+	if err != nil {
+		return nil, err
+	}
+	return stmt.Query(args...)
 }
 
 func (db *DB) Close() error {
+	db.stmtsLock.Lock()
+	for _, stmt := range db.stmts {
+		stmt.Close()
+	}
+	db.stmts = nil
+	db.stmtsLock.Unlock()
+
 	return db.conn.Close()
 }
 
 func (db *DB) CreateOrUpdateUser(user *models.User) error {
+	role := user.Role
+	if role == "" {
+		role = models.RoleUser
+	}
 	_, err := db.conn.Exec(`
-		INSERT INTO users (id, email, name, avatar_url, created_at)
-		VALUES (?, ?, ?, ?, ?)
+		INSERT INTO users (id, email, email_verified, name, avatar_url, role, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
 		ON CONFLICT(id) DO UPDATE SET
 			email = excluded.email,
+			email_verified = excluded.email_verified,
 			name = excluded.name,
-			avatar_url = excluded.avatar_url
-	`, user.ID, user.Email, user.Name, user.AvatarURL, time.Now())
+			avatar_url = excluded.avatar_url,
+			role = excluded.role
+	`, user.ID, user.Email, user.EmailVerified, user.Name, user.AvatarURL, role, time.Now())
 	return err
 }
 
+// SyncAdmins gives the admin role to users whose ID or verified email is
+// listed and the user role to everyone else
+func (db *DB) SyncAdmins(admins []string) error {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`UPDATE users SET role = ?`, models.RoleUser); err != nil {
+		return err
+	}
+	for _, admin := range admins {
+		if _, err := tx.Exec(`
+			UPDATE users SET role = ? WHERE id = ? OR (email_verified AND lower(email) = lower(?))
+		`, models.RoleAdmin, admin, admin); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
 func (db *DB) GetUser(id string) (*models.User, error) {
 	var user models.User
 	var avatarURL sql.NullString
-	err := db.conn.QueryRow(`
-		SELECT id, email, name, avatar_url, created_at FROM users WHERE id = ?
-	`, id).Scan(&user.ID, &user.Email, &user.Name, &avatarURL, &user.CreatedAt)
+	err := db.queryRow(`
+		SELECT id, email, email_verified, name, avatar_url, role, created_at FROM users WHERE id = ?
+	`, id).Scan(&user.ID, &user.Email, &user.EmailVerified, &user.Name, &avatarURL, &user.Role, &user.CreatedAt)
 	if avatarURL.Valid {
 		user.AvatarURL = avatarURL.String
 	}
@@ -126,46 +195,200 @@ func (db *DB) GetUser(id string) (*models.User, error) {
 	return &user, nil
 }
 
-func (db *DB) CreateSession(token, userID string, expiresAt time.Time) error {
+func (db *DB) CreateSession(sess *models.Session) error {
 	_, err := db.conn.Exec(`
-		INSERT INTO sessions (token, user_id, expires_at)
-		VALUES (?, ?, ?)
-	`, token, userID, expiresAt)
+		INSERT INTO sessions (token, user_id, created_at, last_seen_at, expires_at, user_agent, ip_hash)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, sess.Token, sess.UserID, sess.CreatedAt, sess.LastSeenAt, sess.ExpiresAt, sess.UserAgent, sess.IPHash)
 	return err
 }
 
-func (db *DB) GetSession(token string) (string, error) {
-	var userID string
-	var expiresAt time.Time
-	err := db.conn.QueryRow(`
-		SELECT user_id, expires_at FROM sessions WHERE token = ?
-	`, token).Scan(&userID, &expiresAt)
+const sessionColumns = `token, user_id, created_at, last_seen_at, expires_at, user_agent, ip_hash, replaced_by IS NOT NULL`
+
+func scanSession(row interface{ Scan(...any) error }) (*models.Session, error) {
+	var sess models.Session
+	err := row.Scan(&sess.Token, &sess.UserID, &sess.CreatedAt, &sess.LastSeenAt, &sess.ExpiresAt, &sess.UserAgent, &sess.IPHash, &sess.Rotated)
+	if err != nil {
+		return nil, err
+	}
+	return &sess, nil
+}
+
+// GetSession returns the session for a token, or nil if it doesn't exist
+// or has expired
+func (db *DB) GetSession(token string) (*models.Session, error) {
+	sess, err := scanSession(db.queryRow(`SELECT `+sessionColumns+` FROM sessions WHERE token = ?`, token))
 	if err == sql.ErrNoRows {
-		return "", nil
+		return nil, nil
 	}
 	if err != nil {
-		return "", err
+		return nil, err
 	}
-	if time.Now().After(expiresAt) {
+	if time.Now().After(sess.ExpiresAt) {
 		db.DeleteSession(token)
-		return "", nil
+		return nil, nil
 	}
-	return userID, nil
+	return sess, nil
 }
 
+// ListSessions returns a user's current sessions, most recently used first.
+// Tokens replaced by rotation are left out.
+func (db *DB) ListSessions(userID string) ([]models.Session, error) {
+	rows, err := db.conn.Query(`
+		SELECT `+sessionColumns+` FROM sessions
+		WHERE user_id = ? AND replaced_by IS NULL AND expires_at > ?
+		ORDER BY last_seen_at DESC
+	`, userID, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sessions []models.Session
+	for rows.Next() {
+		sess, err := scanSession(rows)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, *sess)
+	}
+	return sessions, rows.Err()
+}
+
+// TouchSession records that a session was used
+func (db *DB) TouchSession(token string, seenAt time.Time) error {
+	_, err := db.conn.Exec(`UPDATE sessions SET last_seen_at = ? WHERE token = ?`, seenAt, token)
+	return err
+}
+
+// RotateSession replaces a session's token with newToken, valid until
+// expiresAt. The old token keeps working until graceUntil so requests
+// already in flight with it succeed. It returns sql.ErrNoRows if the
+// session is gone or was already rotated.
+func (db *DB) RotateSession(oldToken, newToken string, expiresAt, graceUntil time.Time) error {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(`
+		UPDATE sessions SET replaced_by = ?, expires_at = MIN(expires_at, ?)
+		WHERE token = ? AND replaced_by IS NULL
+	`, newToken, graceUntil, oldToken)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return sql.ErrNoRows
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO sessions (token, user_id, created_at, last_seen_at, expires_at, user_agent, ip_hash)
+		SELECT ?, user_id, created_at, ?, ?, user_agent, ip_hash FROM sessions WHERE token = ?
+	`, newToken, time.Now(), expiresAt, oldToken)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// DeleteSession signs a token out, along with the token it replaced
 func (db *DB) DeleteSession(token string) error {
-	_, err := db.conn.Exec(`DELETE FROM sessions WHERE token = ?`, token)
+	_, err := db.conn.Exec(`DELETE FROM sessions WHERE token = ? OR replaced_by = ?`, token, token)
 	return err
 }
 
-func (db *DB) CleanExpiredSessions() error {
-	_, err := db.conn.Exec(`DELETE FROM sessions WHERE expires_at < ?`, time.Now())
+// DeleteUserSession signs out one of a user's sessions. It returns
+// sql.ErrNoRows if the user has no such session.
+func (db *DB) DeleteUserSession(userID, token string) error {
+	res, err := db.conn.Exec(`
+		DELETE FROM sessions WHERE user_id = ? AND (token = ? OR replaced_by = ?)
+	`, userID, token, token)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// DeleteOtherSessions signs a user out everywhere except keepToken and
+// returns how many sessions were removed
+func (db *DB) DeleteOtherSessions(userID, keepToken string) (int64, error) {
+	res, err := db.conn.Exec(`
+		DELETE FROM sessions
+		WHERE user_id = ? AND token != ? AND COALESCE(replaced_by, '') != ?
+	`, userID, keepToken, keepToken)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// CleanExpiredSessions removes expired sessions and sign in links and
+// returns how many sessions were removed
+func (db *DB) CleanExpiredSessions() (int64, error) {
+	now := time.Now()
+	if _, err := db.conn.Exec(`DELETE FROM login_tokens WHERE expires_at < ?`, now); err != nil {
+		return 0, err
+	}
+	res, err := db.conn.Exec(`DELETE FROM sessions WHERE expires_at < ?`, now)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// CreateLoginToken stores an emailed sign in link by the hash of its token
+func (db *DB) CreateLoginToken(tokenHash, email, redirect string, expiresAt time.Time) error {
+	_, err := db.conn.Exec(`
+		INSERT INTO login_tokens (token_hash, email, redirect, created_at, expires_at)
+		VALUES (?, ?, ?, ?, ?)
+	`, tokenHash, email, redirect, time.Now(), expiresAt)
 	return err
 }
 
+// ConsumeLoginToken marks a sign in link used and returns the email and
+// redirect it was issued for. It returns empty strings if the link doesn't
+// exist, was already used or has expired. The row stays until it expires,
+// so using a link doesn't free up a send for CountLoginTokens.
+func (db *DB) ConsumeLoginToken(tokenHash string) (email, redirect string, err error) {
+	var expiresAt time.Time
+	err = db.conn.QueryRow(`
+		UPDATE login_tokens SET used_at = ? WHERE token_hash = ? AND used_at IS NULL
+		RETURNING email, redirect, expires_at
+	`, time.Now(), tokenHash).Scan(&email, &redirect, &expiresAt)
+	if err == sql.ErrNoRows {
+		return "", "", nil
+	}
+	if err != nil {
+		return "", "", err
+	}
+	if time.Now().After(expiresAt) {
+		return "", "", nil
+	}
+	return email, redirect, nil
+}
+
+// CountLoginTokens returns how many sign in links were sent to an email
+// since a time, whether or not they were used
+func (db *DB) CountLoginTokens(email string, since time.Time) (int, error) {
+	var count int
+	err := db.conn.QueryRow(`
+		SELECT COUNT(*) FROM login_tokens WHERE email = ? AND created_at > ?
+	`, email, since).Scan(&count)
+	return count, err
+}
+
 func (db *DB) AddReaction(userID, postSlug, emoji string) (bool, error) {
 	var exists bool
-	err := db.conn.QueryRow(`
+	err := db.queryRow(`
 		SELECT 1 FROM reactions WHERE user_id = ? AND post_slug = ? AND emoji = ?
 	`, userID, postSlug, emoji).Scan(&exists)
 
@@ -174,7 +397,11 @@ func (db *DB) AddReaction(userID, postSlug, emoji string) (bool, error) {
 			INSERT INTO reactions (user_id, post_slug, emoji, created_at)
 			VALUES (?, ?, ?, ?)
 		`, userID, postSlug, emoji, time.Now())
-		return true, err
+		if err != nil {
+			return false, err
+		}
+		db.changed(Change{Kind: ChangeReactions, PostSlug: postSlug})
+		return true, nil
 	}
 	if err != nil {
 		return false, err
@@ -183,11 +410,15 @@ func (db *DB) AddReaction(userID, postSlug, emoji string) (bool, error) {
 	_, err = db.conn.Exec(`
 		DELETE FROM reactions WHERE user_id = ? AND post_slug = ? AND emoji = ?
 	`, userID, postSlug, emoji)
-	return false, err
+	if err != nil {
+		return false, err
+	}
+	db.changed(Change{Kind: ChangeReactions, PostSlug: postSlug})
+	return false, nil
 }
 
 func (db *DB) GetReactionCounts(postSlug string) ([]models.ReactionCount, error) {
-	rows, err := db.conn.Query(`
+	rows, err := db.query(`
 		SELECT emoji, COUNT(*) as count
 		FROM reactions
 		WHERE post_slug = ?
@@ -223,7 +454,7 @@ func (db *DB) GetReactionCounts(postSlug string) ([]models.ReactionCount, error)
 }
 
 func (db *DB) getReactionUsers(postSlug, emoji string, limit int) ([]string, error) {
-	rows, err := db.conn.Query(`
+	rows, err := db.query(`
 		SELECT u.name
 		FROM reactions r
 		JOIN users u ON r.user_id = u.id
@@ -249,7 +480,7 @@ func (db *DB) getReactionUsers(postSlug, emoji string, limit int) ([]string, err
 }
 
 func (db *DB) GetUserReactions(userID, postSlug string) ([]string, error) {
-	rows, err := db.conn.Query(`
+	rows, err := db.query(`
 		SELECT emoji FROM reactions WHERE user_id = ? AND post_slug = ?
 	`, userID, postSlug)
 	if err != nil {
@@ -305,7 +536,7 @@ func (db *DB) Search(query string, limit int) ([]SearchResult, error) {
 
 	ftsQuery := buildFuzzyQuery(query)
 
-	rows, err := db.conn.Query(`
+	rows, err := db.query(`
 		SELECT
 			slug,
 			collection_slug,
@@ -364,12 +595,19 @@ func escapeFTSToken(token string) string {
 
 // Comment methods
 
-func (db *DB) CreateComment(userID, postSlug, content string) (*models.Comment, error) {
+// CreateComment adds a comment. A zero parentID makes it a top-level
+// comment; callers check the parent is a visible comment on the same post.
+func (db *DB) CreateComment(userID, postSlug string, parentID int64, content, status string) (*models.Comment, error) {
 	now := time.Now()
+	var parent sql.NullInt64
+	if parentID != 0 {
+		parent = sql.NullInt64{Int64: parentID, Valid: true}
+	}
+
 	result, err := db.conn.Exec(`
-		INSERT INTO comments (user_id, post_slug, content, created_at, updated_at)
-		VALUES (?, ?, ?, ?, ?)
-	`, userID, postSlug, content, now, now)
+		INSERT INTO comments (user_id, post_slug, parent_id, content, status, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, userID, postSlug, parent, content, status, now, now)
 	if err != nil {
 		return nil, err
 	}
@@ -379,34 +617,101 @@ func (db *DB) CreateComment(userID, postSlug, content string) (*models.Comment,
 		return nil, err
 	}
 
+	db.changed(Change{Kind: ChangeCommentCreated, PostSlug: postSlug, CommentID: id, UserID: userID, Status: status})
+
 	return &models.Comment{
 		ID:        id,
 		UserID:    userID,
 		PostSlug:  postSlug,
+		ParentID:  parentID,
 		Content:   content,
+		Status:    status,
 		CreatedAt: now,
 		UpdatedAt: now,
 	}, nil
 }
 
-func (db *DB) GetComments(postSlug string) ([]models.CommentWithUser, error) {
-	rows, err := db.conn.Query(`
-		SELECT c.id, c.user_id, c.post_slug, c.content, c.created_at, c.updated_at,
-		       u.name, COALESCE(u.avatar_url, '')
+// GetComments returns the approved comments on a post, plus the viewer's
+// own comments that are still awaiting moderation, in thread order
+func (db *DB) GetComments(postSlug, viewerID string) ([]models.CommentWithUser, error) {
+	rows, err := db.query(`
+		SELECT `+commentColumns+`
 		FROM comments c
 		JOIN users u ON c.user_id = u.id
 		WHERE c.post_slug = ?
+		  AND (c.status = ? OR (c.status = ? AND c.user_id = ?))
+		ORDER BY c.created_at, c.id
+	`, postSlug, models.CommentApproved, models.CommentPending, viewerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	comments, err := scanComments(rows)
+	if err != nil {
+		return nil, err
+	}
+	return threadComments(comments), nil
+}
+
+// threadComments orders comments so every reply follows its parent:
+// top-level comments newest first, replies oldest first beneath them.
+// Replies to hidden comments are dropped, as are tombstones with nothing
+// left to hold together. Comments must be in creation order.
+func threadComments(comments []models.CommentWithUser) []models.CommentWithUser {
+	children := make(map[int64][]models.CommentWithUser)
+	for _, c := range comments {
+		children[c.ParentID] = append(children[c.ParentID], c)
+	}
+	slices.Reverse(children[0])
+
+	var walk func(parentID int64, depth int) []models.CommentWithUser
+	walk = func(parentID int64, depth int) []models.CommentWithUser {
+		var thread []models.CommentWithUser
+		for _, c := range children[parentID] {
+			replies := walk(c.ID, depth+1)
+			if c.Deleted && len(replies) == 0 {
+				continue
+			}
+			c.Depth = depth
+			thread = append(thread, c)
+			thread = append(thread, replies...)
+		}
+		return thread
+	}
+
+	return walk(0, 0)
+}
+
+// ListCommentsByStatus returns the most recent comments with a moderation
+// status across all posts
+func (db *DB) ListCommentsByStatus(status string, limit int) ([]models.CommentWithUser, error) {
+	rows, err := db.conn.Query(`
+		SELECT `+commentColumns+`
+		FROM comments c
+		JOIN users u ON c.user_id = u.id
+		WHERE c.status = ? AND c.deleted_at IS NULL
 		ORDER BY c.created_at DESC
-	`, postSlug)
+		LIMIT ?
+	`, status, limit)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
+	return scanComments(rows)
+}
+
+// commentColumns are the columns scanComments reads, from comments c
+// joined with users u
+const commentColumns = `c.id, c.user_id, c.post_slug, COALESCE(c.parent_id, 0), c.content, c.status,
+		       c.deleted_at IS NOT NULL, c.created_at, c.updated_at, u.name, COALESCE(u.avatar_url, '')`
+
+func scanComments(rows *sql.Rows) ([]models.CommentWithUser, error) {
 	var comments []models.CommentWithUser
 	for rows.Next() {
 		var c models.CommentWithUser
-		if err := rows.Scan(&c.ID, &c.UserID, &c.PostSlug, &c.Content, &c.CreatedAt, &c.UpdatedAt, &c.UserName, &c.UserAvatar); err != nil {
+		if err := rows.Scan(&c.ID, &c.UserID, &c.PostSlug, &c.ParentID, &c.Content, &c.Status, &c.Deleted, &c.CreatedAt, &c.UpdatedAt, &c.UserName, &c.UserAvatar); err != nil {
 			return nil, err
 		}
 		comments = append(comments, c)
@@ -415,12 +720,35 @@ func (db *DB) GetComments(postSlug string) ([]models.CommentWithUser, error) {
 	return comments, rows.Err()
 }
 
+// HasRecentComment reports whether a user posted a comment with exactly
+// this content, on any post, since the given time
+func (db *DB) HasRecentComment(userID, content string, since time.Time) (bool, error) {
+	var exists bool
+	err := db.conn.QueryRow(`
+		SELECT EXISTS(
+			SELECT 1 FROM comments
+			WHERE user_id = ? AND content = ? AND created_at >= ? AND deleted_at IS NULL
+		)
+	`, userID, content, since).Scan(&exists)
+	return exists, err
+}
+
+// HasApprovedComment reports whether a user has had a comment approved
+func (db *DB) HasApprovedComment(userID string) (bool, error) {
+	var exists bool
+	err := db.conn.QueryRow(`
+		SELECT EXISTS(SELECT 1 FROM comments WHERE user_id = ? AND status = ?)
+	`, userID, models.CommentApproved).Scan(&exists)
+	return exists, err
+}
+
 func (db *DB) GetComment(id int64) (*models.Comment, error) {
 	var c models.Comment
 	err := db.conn.QueryRow(`
-		SELECT id, user_id, post_slug, content, created_at, updated_at
+		SELECT id, user_id, post_slug, COALESCE(parent_id, 0), content, status,
+		       deleted_at IS NOT NULL, created_at, updated_at
 		FROM comments WHERE id = ?
-	`, id).Scan(&c.ID, &c.UserID, &c.PostSlug, &c.Content, &c.CreatedAt, &c.UpdatedAt)
+	`, id).Scan(&c.ID, &c.UserID, &c.PostSlug, &c.ParentID, &c.Content, &c.Status, &c.Deleted, &c.CreatedAt, &c.UpdatedAt)
 	if err == sql.ErrNoRows {
 		return nil, nil
 	}
@@ -430,56 +758,325 @@ func (db *DB) GetComment(id int64) (*models.Comment, error) {
 	return &c, nil
 }
 
+// CommentDepth returns how many ancestors a comment has
+func (db *DB) CommentDepth(id int64) (int, error) {
+	var depth int
+	err := db.conn.QueryRow(`
+		WITH RECURSIVE ancestors(id, parent_id) AS (
+			SELECT id, parent_id FROM comments WHERE id = ?
+			UNION ALL
+			SELECT c.id, c.parent_id FROM comments c JOIN ancestors a ON c.id = a.parent_id
+		)
+		SELECT COUNT(*) - 1 FROM ancestors
+	`, id).Scan(&depth)
+	return depth, err
+}
+
 func (db *DB) UpdateComment(id int64, userID, content string) error {
-	result, err := db.conn.Exec(`
+	var postSlug, status string
+	err := db.conn.QueryRow(`
 		UPDATE comments SET content = ?, updated_at = ?
-		WHERE id = ? AND user_id = ?
-	`, content, time.Now(), id, userID)
+		WHERE id = ? AND user_id = ? AND deleted_at IS NULL
+		RETURNING post_slug, status
+	`, content, time.Now(), id, userID).Scan(&postSlug, &status)
 	if err != nil {
 		return err
 	}
 
-	rows, err := result.RowsAffected()
+	db.changed(Change{Kind: ChangeCommentUpdated, PostSlug: postSlug, CommentID: id, UserID: userID, Status: status})
+	return nil
+}
+
+func (db *DB) DeleteComment(id int64, userID string) error {
+	var postSlug string
+	var parentID sql.NullInt64
+	err := db.conn.QueryRow(`
+		SELECT post_slug, parent_id FROM comments WHERE id = ? AND user_id = ? AND deleted_at IS NULL
+	`, id, userID).Scan(&postSlug, &parentID)
 	if err != nil {
 		return err
 	}
-	if rows == 0 {
-		return sql.ErrNoRows
+	return db.removeComment(id, postSlug, parentID)
+}
+
+// SetCommentStatus moderates a comment
+func (db *DB) SetCommentStatus(id int64, status string) error {
+	var postSlug, userID string
+	err := db.conn.QueryRow(`
+		UPDATE comments SET status = ? WHERE id = ?
+		RETURNING post_slug, user_id
+	`, status, id).Scan(&postSlug, &userID)
+	if err != nil {
+		return err
 	}
+
+	db.changed(Change{Kind: ChangeCommentUpdated, PostSlug: postSlug, CommentID: id, UserID: userID, Status: status})
 	return nil
 }
 
-func (db *DB) DeleteComment(id int64, userID string) error {
-	result, err := db.conn.Exec(`
-		DELETE FROM comments WHERE id = ? AND user_id = ?
-	`, id, userID)
+// DeleteAnyComment deletes a comment regardless of its author
+func (db *DB) DeleteAnyComment(id int64) error {
+	var postSlug string
+	var parentID sql.NullInt64
+	err := db.conn.QueryRow(`
+		SELECT post_slug, parent_id FROM comments WHERE id = ? AND deleted_at IS NULL
+	`, id).Scan(&postSlug, &parentID)
 	if err != nil {
 		return err
 	}
+	return db.removeComment(id, postSlug, parentID)
+}
 
-	rows, err := result.RowsAffected()
+// removeComment deletes a comment and reports the change
+func (db *DB) removeComment(id int64, postSlug string, parentID sql.NullInt64) error {
+	tx, err := db.conn.Begin()
 	if err != nil {
 		return err
 	}
-	if rows == 0 {
-		return sql.ErrNoRows
+	defer tx.Rollback()
+
+	if err := deleteOrTombstone(tx, id, parentID); err != nil {
+		return err
 	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	db.changed(Change{Kind: ChangeCommentDeleted, PostSlug: postSlug, CommentID: id})
 	return nil
 }
 
-// CleanupUserData removes all data for a user (comments, reactions, sessions)
+// deleteOrTombstone deletes a comment. A comment with replies becomes a
+// tombstone instead, so deleting it doesn't take the replies with it, and
+// tombstones are removed once their last reply is gone.
+func deleteOrTombstone(tx *sql.Tx, id int64, parentID sql.NullInt64) error {
+	var hasReplies bool
+	err := tx.QueryRow(`
+		SELECT EXISTS(SELECT 1 FROM comments WHERE parent_id = ?)
+	`, id).Scan(&hasReplies)
+	if err != nil {
+		return err
+	}
+
+	if hasReplies {
+		_, err := tx.Exec(`
+			UPDATE comments SET content = '', deleted_at = ? WHERE id = ?
+		`, time.Now(), id)
+		return err
+	}
+
+	if _, err := tx.Exec(`DELETE FROM comments WHERE id = ?`, id); err != nil {
+		return err
+	}
+
+	// Walk up, removing tombstones this comment was the last reply to
+	for parentID.Valid {
+		var deleted bool
+		var grandparentID sql.NullInt64
+		err := tx.QueryRow(`
+			SELECT deleted_at IS NOT NULL, parent_id FROM comments WHERE id = ?
+		`, parentID.Int64).Scan(&deleted, &grandparentID)
+		if err == sql.ErrNoRows {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if !deleted {
+			return nil
+		}
+
+		result, err := tx.Exec(`
+			DELETE FROM comments
+			WHERE id = ? AND NOT EXISTS (SELECT 1 FROM comments WHERE parent_id = ?)
+		`, parentID.Int64, parentID.Int64)
+		if err != nil {
+			return err
+		}
+		if n, err := result.RowsAffected(); err != nil || n == 0 {
+			return err
+		}
+		parentID = grandparentID
+	}
+	return nil
+}
+
+// CleanupUserData removes all data for a user (comments, reactions, docs
+// feedback, sessions) in one transaction. Comments that others replied to
+// are left as tombstones.
 func (db *DB) CleanupUserData(userID string) error {
-	_, err := db.conn.Exec(`DELETE FROM comments WHERE user_id = ?`, userID)
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	changes, err := cleanupUserData(tx, userID)
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	for _, c := range changes {
+		db.changed(c)
+	}
+	return nil
+}
+
+// cleanupUserData does the work of CleanupUserData in tx and returns the
+// changes to report once it commits
+func cleanupUserData(tx *sql.Tx, userID string) ([]Change, error) {
+	type comment struct {
+		id       int64
+		postSlug string
+		parentID sql.NullInt64
+	}
+	var comments []comment
+	err := scanRows(tx, `
+		SELECT id, post_slug, parent_id FROM comments
+		WHERE user_id = ? AND deleted_at IS NULL
+		ORDER BY id DESC
+	`, []any{userID}, func(rows *sql.Rows) error {
+		var c comment
+		if err := rows.Scan(&c.id, &c.postSlug, &c.parentID); err != nil {
+			return err
+		}
+		comments = append(comments, c)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Newest first, so a user's replies go before the comments they answer
+	var changes []Change
+	for _, c := range comments {
+		if err := deleteOrTombstone(tx, c.id, c.parentID); err != nil {
+			return nil, err
+		}
+		changes = append(changes, Change{Kind: ChangeCommentDeleted, PostSlug: c.postSlug, CommentID: c.id})
+	}
+
+	reacted, err := postsWith(tx, "reactions", userID)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec(`DELETE FROM reactions WHERE user_id = ?`, userID); err != nil {
+		return nil, err
+	}
+	for _, postSlug := range reacted {
+		changes = append(changes, Change{Kind: ChangeReactions, PostSlug: postSlug})
+	}
+
+	if _, err := tx.Exec(`DELETE FROM feedback WHERE user_id = ?`, userID); err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec(`DELETE FROM sessions WHERE user_id = ?`, userID); err != nil {
+		return nil, err
+	}
+	return changes, nil
+}
+
+// DeletedUserID is the placeholder author of comments kept after their
+// author deleted their account
+const DeletedUserID = "deleted"
+
+// DeleteUser removes a user's account with their reactions, docs feedback,
+// sessions, and the newsletter subscriptions and sign in links of their
+// email address, in one transaction. Their comments are deleted or, with
+// anonymize, kept and attributed to the DeletedUserID placeholder. Deleted
+// comments that others replied to stay as tombstones, which are also
+// handed to the placeholder.
+func (db *DB) DeleteUser(userID string, anonymize bool) error {
+	tx, err := db.conn.Begin()
 	if err != nil {
 		return err
 	}
-	_, err = db.conn.Exec(`DELETE FROM reactions WHERE user_id = ?`, userID)
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		INSERT INTO users (id, email, name, role) VALUES (?, '', 'Deleted user', ?)
+		ON CONFLICT(id) DO NOTHING
+	`, DeletedUserID, models.RoleUser)
 	if err != nil {
 		return err
 	}
-	_, err = db.conn.Exec(`DELETE FROM sessions WHERE user_id = ?`, userID)
+
+	var changes []Change
+	if anonymize {
+		err := scanRows(tx, `
+			UPDATE comments SET user_id = ? WHERE user_id = ?
+			RETURNING id, post_slug, status, deleted_at IS NOT NULL
+		`, []any{DeletedUserID, userID}, func(rows *sql.Rows) error {
+			c := Change{Kind: ChangeCommentUpdated, UserID: DeletedUserID}
+			var deleted bool
+			if err := rows.Scan(&c.CommentID, &c.PostSlug, &c.Status, &deleted); err != nil {
+				return err
+			}
+			if !deleted {
+				changes = append(changes, c)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	cleaned, err := cleanupUserData(tx, userID)
 	if err != nil {
 		return err
 	}
+	changes = append(changes, cleaned...)
+
+	if _, err := tx.Exec(`UPDATE comments SET user_id = ? WHERE user_id = ?`, DeletedUserID, userID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`
+		DELETE FROM newsletter_sent WHERE subscriber_id IN (
+			SELECT s.id FROM newsletter_subscribers s JOIN users u ON s.email = lower(u.email)
+			WHERE u.id = ? AND u.email != ''
+		)
+	`, userID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`
+		DELETE FROM newsletter_subscribers WHERE email IN (
+			SELECT lower(email) FROM users WHERE id = ? AND email != ''
+		)
+	`, userID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`
+		DELETE FROM login_tokens WHERE email IN (
+			SELECT lower(email) FROM users WHERE id = ? AND email != ''
+		)
+	`, userID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM users WHERE id = ?`, userID); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	for _, c := range changes {
+		db.changed(c)
+	}
 	return nil
 }
+
+// postsWith returns the posts a user has rows on in table
+func postsWith(tx *sql.Tx, table, userID string) ([]string, error) {
+	var posts []string
+	err := scanRows(tx, `SELECT DISTINCT post_slug FROM `+table+` WHERE user_id = ?`, []any{userID}, func(rows *sql.Rows) error {
+		var postSlug string
+		if err := rows.Scan(&postSlug); err != nil {
+			return err
+		}
+		posts = append(posts, postSlug)
+		return nil
+	})
+	return posts, err
+}
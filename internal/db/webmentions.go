@@ -0,0 +1,161 @@
+package db
+
+import (
+	"database/sql"
+	"time"
+
+	"site/internal/models"
+)
+
+// webmentionColumns are the columns scanWebmention reads
+const webmentionColumns = `id, source, target, post_slug, status, title, author, created_at, updated_at`
+
+func scanWebmention(row interface{ Scan(...any) error }) (*models.Webmention, error) {
+	var m models.Webmention
+	err := row.Scan(&m.ID, &m.Source, &m.Target, &m.PostSlug, &m.Status, &m.Title, &m.Author, &m.CreatedAt, &m.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+func scanWebmentions(rows *sql.Rows) ([]models.Webmention, error) {
+	defer rows.Close()
+
+	var mentions []models.Webmention
+	for rows.Next() {
+		m, err := scanWebmention(rows)
+		if err != nil {
+			return nil, err
+		}
+		mentions = append(mentions, *m)
+	}
+	return mentions, rows.Err()
+}
+
+// QueueWebmention records a received webmention for verification and
+// returns its ID. A source that sends the same target again keeps its
+// existing row and moderation status.
+func (db *DB) QueueWebmention(source, target, postSlug string) (int64, error) {
+	now := time.Now()
+	var id int64
+	err := db.conn.QueryRow(`
+		INSERT INTO webmentions (source, target, post_slug, status, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT(source, target) DO UPDATE SET updated_at = excluded.updated_at
+		RETURNING id
+	`, source, target, postSlug, models.WebmentionQueued, now, now).Scan(&id)
+	return id, err
+}
+
+// GetWebmention returns a webmention by ID, or nil if there is none
+func (db *DB) GetWebmention(id int64) (*models.Webmention, error) {
+	m, err := scanWebmention(db.conn.QueryRow(`
+		SELECT `+webmentionColumns+` FROM webmentions WHERE id = ?
+	`, id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return m, err
+}
+
+// GetWebmentions returns a post's approved webmentions, oldest first
+func (db *DB) GetWebmentions(postSlug string) ([]models.Webmention, error) {
+	rows, err := db.query(`
+		SELECT `+webmentionColumns+` FROM webmentions
+		WHERE post_slug = ? AND status = ?
+		ORDER BY created_at ASC
+	`, postSlug, models.CommentApproved)
+	if err != nil {
+		return nil, err
+	}
+	return scanWebmentions(rows)
+}
+
+// ListWebmentionsByStatus returns the most recent webmentions with a status
+// across all posts
+func (db *DB) ListWebmentionsByStatus(status string, limit int) ([]models.Webmention, error) {
+	rows, err := db.conn.Query(`
+		SELECT `+webmentionColumns+` FROM webmentions
+		WHERE status = ?
+		ORDER BY created_at DESC
+		LIMIT ?
+	`, status, limit)
+	if err != nil {
+		return nil, err
+	}
+	return scanWebmentions(rows)
+}
+
+// VerifyWebmention saves what the source says about itself. A newly
+// received webmention moves to status; one that was already moderated
+// keeps its status.
+func (db *DB) VerifyWebmention(id int64, title, author, status string) error {
+	var postSlug string
+	err := db.conn.QueryRow(`
+		UPDATE webmentions
+		SET title = ?, author = ?, updated_at = ?,
+		    status = CASE WHEN status = ? THEN ? ELSE status END
+		WHERE id = ?
+		RETURNING post_slug, status
+	`, title, author, time.Now(), models.WebmentionQueued, status, id).Scan(&postSlug, &status)
+	if err != nil {
+		return err
+	}
+
+	db.changed(Change{Kind: ChangeWebmentions, PostSlug: postSlug, Status: status})
+	return nil
+}
+
+// SetWebmentionStatus moderates a webmention. It returns sql.ErrNoRows if
+// there is no such webmention.
+func (db *DB) SetWebmentionStatus(id int64, status string) error {
+	var postSlug string
+	err := db.conn.QueryRow(`
+		UPDATE webmentions SET status = ? WHERE id = ?
+		RETURNING post_slug
+	`, status, id).Scan(&postSlug)
+	if err != nil {
+		return err
+	}
+
+	db.changed(Change{Kind: ChangeWebmentions, PostSlug: postSlug, Status: status})
+	return nil
+}
+
+// DeleteWebmention removes a webmention. It returns sql.ErrNoRows if there
+// is no such webmention.
+func (db *DB) DeleteWebmention(id int64) error {
+	var postSlug string
+	err := db.conn.QueryRow(`
+		DELETE FROM webmentions WHERE id = ?
+		RETURNING post_slug
+	`, id).Scan(&postSlug)
+	if err != nil {
+		return err
+	}
+
+	db.changed(Change{Kind: ChangeWebmentions, PostSlug: postSlug})
+	return nil
+}
+
+// WebmentionSent reports whether a webmention from source to target was
+// already sent
+func (db *DB) WebmentionSent(source, target string) (bool, error) {
+	var sent bool
+	err := db.conn.QueryRow(`
+		SELECT EXISTS(SELECT 1 FROM webmentions_sent WHERE source = ? AND target = ?)
+	`, source, target).Scan(&sent)
+	return sent, err
+}
+
+// RecordWebmentionSent remembers that endpoint accepted a webmention from
+// source to target
+func (db *DB) RecordWebmentionSent(source, target, endpoint string) error {
+	_, err := db.conn.Exec(`
+		INSERT INTO webmentions_sent (source, target, endpoint, sent_at)
+		VALUES (?, ?, ?, ?)
+		ON CONFLICT(source, target) DO UPDATE SET endpoint = excluded.endpoint, sent_at = excluded.sent_at
+	`, source, target, endpoint, time.Now())
+	return err
+}
@@ -0,0 +1,42 @@
+package mail
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// File writes each message to an .eml file in Dir instead of sending it,
+// for development and tests
+type File struct {
+	Dir string
+}
+
+func (f *File) Send(ctx context.Context, msg Message) error {
+	data, err := Format(msg)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(f.Dir, 0755); err != nil {
+		return err
+	}
+
+	name := fmt.Sprintf("%s.eml", time.Now().Format("20060102-150405.000000000"))
+	path := filepath.Join(f.Dir, name)
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		return err
+	}
+	log.Printf("Wrote email to %s: %s", msg.To, path)
+	return nil
+}
+
+// Log prints each message to the server log instead of sending it
+type Log struct{}
+
+func (Log) Send(ctx context.Context, msg Message) error {
+	log.Printf("Email to %s: %s\n%s", msg.To, msg.Subject, msg.Body)
+	return nil
+}
@@ -0,0 +1,94 @@
+package mail
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strconv"
+	"time"
+)
+
+// SMTP sends mail through an SMTP server. Port 465 uses implicit TLS;
+// other ports upgrade with STARTTLS when the server offers it, which is
+// required before authenticating.
+type SMTP struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+}
+
+// smtpTimeout bounds a delivery when ctx has no deadline
+const smtpTimeout = 30 * time.Second
+
+func (s *SMTP) Send(ctx context.Context, msg Message) error {
+	data, err := Format(msg)
+	if err != nil {
+		return err
+	}
+	from, _ := mail.ParseAddress(msg.From)
+	to, _ := mail.ParseAddress(msg.To)
+
+	port := s.Port
+	if port == 0 {
+		port = 587
+	}
+	addr := net.JoinHostPort(s.Host, strconv.Itoa(port))
+
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(smtpTimeout)
+	}
+	dialer := &net.Dialer{Deadline: deadline}
+	tlsConfig := &tls.Config{ServerName: s.Host}
+
+	var conn net.Conn
+	if port == 465 {
+		conn, err = (&tls.Dialer{NetDialer: dialer, Config: tlsConfig}).DialContext(ctx, "tcp", addr)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", addr)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to connect to %s: %w", addr, err)
+	}
+	conn.SetDeadline(deadline)
+
+	client, err := smtp.NewClient(conn, s.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok && port != 465 {
+		if err := client.StartTLS(tlsConfig); err != nil {
+			return fmt.Errorf("failed to start TLS: %w", err)
+		}
+	}
+	if s.Username != "" {
+		if err := client.Auth(smtp.PlainAuth("", s.Username, s.Password, s.Host)); err != nil {
+			return fmt.Errorf("failed to authenticate: %w", err)
+		}
+	}
+
+	if err := client.Mail(from.Address); err != nil {
+		return err
+	}
+	if err := client.Rcpt(to.Address); err != nil {
+		return err
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(data); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
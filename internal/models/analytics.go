@@ -0,0 +1,47 @@
+package models
+
+// PageView is one counted view of a page. Visitor is a hash keyed with the
+// day's salt, kept only until the day is rolled up.
+type PageView struct {
+	Day      string // UTC, YYYY-MM-DD
+	Path     string
+	Visitor  string
+	Referrer string // Referring host, "" for direct visits
+	Agent    string // Browser class, see the analytics package
+}
+
+// PageStats counts the views of one page. Visitors are unique per day, so
+// over several days they add up the daily counts.
+type PageStats struct {
+	Path     string `json:"path"`
+	Views    int    `json:"views"`
+	Visitors int    `json:"visitors"`
+}
+
+type ReferrerStats struct {
+	Host  string `json:"host"`
+	Views int    `json:"views"`
+}
+
+type AgentStats struct {
+	Class string `json:"class"`
+	Views int    `json:"views"`
+}
+
+// DayStats counts a day's views across the site
+type DayStats struct {
+	Day      string `json:"day"`
+	Views    int    `json:"views"`
+	Visitors int    `json:"visitors"`
+}
+
+// AnalyticsStats summarises the views since a day
+type AnalyticsStats struct {
+	Since     string          `json:"since"`
+	Views     int             `json:"views"`
+	Visitors  int             `json:"visitors"`
+	Pages     []PageStats     `json:"pages"`
+	Referrers []ReferrerStats `json:"referrers"`
+	Agents    []AgentStats    `json:"agents"`
+	Days      []DayStats      `json:"days"`
+}
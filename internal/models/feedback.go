@@ -0,0 +1,42 @@
+package models
+
+import "time"
+
+// Feedback is a "was this page helpful?" vote on a docs page. Voter is
+// the signed in user, or a hash for anonymous readers, so each gets one
+// vote per page that they can change.
+type Feedback struct {
+	ID         int64
+	PostSlug   string
+	Collection string
+	Voter      string
+	UserID     string // "" for anonymous votes
+	Helpful    bool
+	Reason     string
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+}
+
+// FeedbackReason is a vote that came with a reason
+type FeedbackReason struct {
+	Helpful   bool      `json:"helpful"`
+	Reason    string    `json:"reason"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// PageFeedback counts the votes on a page, with its latest reasons
+type PageFeedback struct {
+	Post    string           `json:"post"`
+	Yes     int              `json:"yes"`
+	No      int              `json:"no"`
+	Reasons []FeedbackReason `json:"reasons"`
+}
+
+// CollectionFeedback counts the votes across a docs collection. Pages with
+// the most "no" votes come first.
+type CollectionFeedback struct {
+	Collection string         `json:"collection"`
+	Yes        int            `json:"yes"`
+	No         int            `json:"no"`
+	Pages      []PageFeedback `json:"pages"`
+}
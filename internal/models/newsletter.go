@@ -0,0 +1,16 @@
+package models
+
+import "time"
+
+// Subscriber gets new posts by email, from the whole site or from one
+// series. They only get mail once they confirm the address.
+type Subscriber struct {
+	ID               int64
+	Email            string
+	Series           string // Collection slug, "" for every series
+	Confirmed        bool
+	UnsubscribeToken string
+	CreatedAt        time.Time
+	ConfirmSentAt    time.Time
+	ConfirmedAt      time.Time
+}
@@ -1,6 +1,10 @@
 package models
 
-import "time"
+import (
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
 
 type Post struct {
 	Title       string
@@ -9,6 +13,10 @@ type Post struct {
 	Updated     time.Time
 	Draft       bool
 	Order       int
+	Tags        []string
+	Categories  []string
+	Comments    bool
+	Reactions   bool
 
 	Slug           string
 	CollectionSlug string
@@ -23,17 +31,55 @@ type Post struct {
 	NextPost *Post
 }
 
+// ID identifies the post to the comments and reactions API
+func (p *Post) ID() string {
+	return p.CollectionSlug + "/" + p.Slug
+}
+
 type TOCItem struct {
-	Level int
-	ID    string
-	Text  string
+	Level    int
+	ID       string
+	Text     string
+	Children []TOCItem
 }
 
 type PostFrontmatter struct {
-	Title       string `yaml:"title"`
-	Description string `yaml:"description"`
-	Date        string `yaml:"date"`
-	Updated     string `yaml:"updated"`
-	Draft       bool   `yaml:"draft"`
-	Order       int    `yaml:"order"`
+	Title       string     `yaml:"title"`
+	Description string     `yaml:"description"`
+	Date        string     `yaml:"date"`
+	Updated     string     `yaml:"updated"`
+	Draft       bool       `yaml:"draft"`
+	Order       int        `yaml:"order"`
+	Tags        StringList `yaml:"tags"`
+	Categories  StringList `yaml:"categories"`
+	TOCMinDepth int        `yaml:"toc_min_depth"`
+	TOCMaxDepth int        `yaml:"toc_max_depth"`
+	Comments    *bool      `yaml:"comments"`  // Defaults to true
+	Reactions   *bool      `yaml:"reactions"` // Defaults to true
+}
+
+// StringList is a front matter list that may also be written as a single
+// value, e.g. "tags: go" for "tags: [go]"
+type StringList []string
+
+func (l *StringList) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind != yaml.ScalarNode {
+		var list []string
+		if err := value.Decode(&list); err != nil {
+			return err
+		}
+		*l = list
+		return nil
+	}
+
+	var item string
+	if err := value.Decode(&item); err != nil {
+		return err
+	}
+	if value.Tag == "!!null" || item == "" {
+		*l = nil
+		return nil
+	}
+	*l = StringList{item}
+	return nil
 }
@@ -6,11 +6,23 @@ import (
 )
 
 type User struct {
-	ID        string
-	Email     string
-	Name      string
-	AvatarURL string
-	CreatedAt time.Time
+	ID            string
+	Email         string
+	EmailVerified bool // The sign in provider verified Email, so it can match the admins list
+	Name          string
+	AvatarURL     string
+	Role          string
+	CreatedAt     time.Time
+}
+
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin"
+)
+
+// IsAdmin reports whether the user can moderate comments
+func (u *User) IsAdmin() bool {
+	return u != nil && u.Role == RoleAdmin
 }
 
 type Reaction struct {
@@ -37,13 +49,38 @@ type Comment struct {
 	ID        int64
 	UserID    string
 	PostSlug  string
+	ParentID  int64 // 0 for top-level comments
 	Content   string
+	Status    string
+	Deleted   bool // Tombstone kept so replies stay in their thread
 	CreatedAt time.Time
 	UpdatedAt time.Time
 }
 
+// MaxCommentDepth is how many levels replies nest below a top-level
+// comment. Replies to a comment at this depth become its siblings.
+const MaxCommentDepth = 3
+
+// Comment moderation statuses. Only approved comments are shown publicly.
+const (
+	CommentPending  = "pending"
+	CommentApproved = "approved"
+	CommentRejected = "rejected"
+	CommentSpam     = "spam"
+)
+
+// IsValidCommentStatus reports whether s is a moderation status
+func IsValidCommentStatus(s string) bool {
+	switch s {
+	case CommentPending, CommentApproved, CommentRejected, CommentSpam:
+		return true
+	}
+	return false
+}
+
 type CommentWithUser struct {
 	Comment
 	UserName   string
 	UserAvatar string
+	Depth      int // Nesting level in the thread, 0 for top-level comments
 }
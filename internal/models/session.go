@@ -0,0 +1,16 @@
+package models
+
+import "time"
+
+// Session is a signed-in browser. The token is only ever sent to that
+// browser in the session cookie.
+type Session struct {
+	Token      string
+	UserID     string
+	CreatedAt  time.Time // When the user signed in, kept across rotations
+	LastSeenAt time.Time
+	ExpiresAt  time.Time
+	UserAgent  string
+	IPHash     string // Keyed hash of the client IP, never the IP itself
+	Rotated    bool   // Replaced by a newer token, valid only briefly
+}
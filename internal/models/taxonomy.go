@@ -0,0 +1,140 @@
+package models
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Taxonomy names. Each is also the top-level URL segment of its listing
+// pages, so no collection may use it.
+const (
+	TaxonomyTags       = "tags"
+	TaxonomyCategories = "categories"
+)
+
+// Term is a single tag or category and the posts filed under it
+type Term struct {
+	Name  string
+	Slug  string
+	Posts []*Post // Newest first
+}
+
+// Taxonomy groups posts by the terms listed in one frontmatter field
+type Taxonomy struct {
+	Name   string // URL segment and display name, e.g. "tags"
+	Terms  []*Term
+	bySlug map[string]*Term
+
+	// Warnings lists terms written differently that share a slug, e.g.
+	// "C" and "C++", whose posts end up on one page
+	Warnings []string
+}
+
+// NewTaxonomy builds a taxonomy from every post in collections, using
+// terms to pick the relevant frontmatter list from each post
+func NewTaxonomy(name string, collections []*Collection, terms func(*Post) []string) *Taxonomy {
+	t := &Taxonomy{Name: name, bySlug: make(map[string]*Term)}
+	spellings := make(map[string]map[string]string) // Slug to names by their lowercase form
+
+	for _, collection := range collections {
+		for _, post := range collection.Posts {
+			seen := make(map[string]bool)
+			for _, termName := range terms(post) {
+				slug := TermSlug(termName)
+				if slug == "" {
+					continue
+				}
+				if spellings[slug] == nil {
+					spellings[slug] = make(map[string]string)
+				}
+				if key := strings.ToLower(strings.TrimSpace(termName)); spellings[slug][key] == "" {
+					spellings[slug][key] = strings.TrimSpace(termName)
+				}
+				if seen[slug] {
+					continue
+				}
+				seen[slug] = true
+
+				term, ok := t.bySlug[slug]
+				if !ok {
+					term = &Term{Name: strings.TrimSpace(termName), Slug: slug}
+					t.bySlug[slug] = term
+					t.Terms = append(t.Terms, term)
+				}
+				term.Posts = append(term.Posts, post)
+			}
+		}
+	}
+
+	for _, term := range t.Terms {
+		sort.SliceStable(term.Posts, func(i, j int) bool {
+			return term.Posts[i].Date.After(term.Posts[j].Date)
+		})
+	}
+
+	// Case is ignored, but other differences are probably distinct terms
+	for slug, names := range spellings {
+		if len(names) < 2 {
+			continue
+		}
+		quoted := make([]string, 0, len(names))
+		for _, n := range names {
+			quoted = append(quoted, strconv.Quote(n))
+		}
+		sort.Strings(quoted)
+		t.Warnings = append(t.Warnings, fmt.Sprintf("%s %s share the URL %s and are listed together", name, strings.Join(quoted, ", "), t.TermURL(t.bySlug[slug])))
+	}
+	sort.Strings(t.Warnings)
+
+	// Most used first, then alphabetical
+	sort.Slice(t.Terms, func(i, j int) bool {
+		if len(t.Terms[i].Posts) != len(t.Terms[j].Posts) {
+			return len(t.Terms[i].Posts) > len(t.Terms[j].Posts)
+		}
+		return t.Terms[i].Slug < t.Terms[j].Slug
+	})
+
+	return t
+}
+
+// Term looks up a term by slug
+func (t *Taxonomy) Term(slug string) *Term {
+	if t == nil {
+		return nil
+	}
+	return t.bySlug[slug]
+}
+
+// URL returns the path of the taxonomy's index page
+func (t *Taxonomy) URL() string {
+	return "/" + t.Name
+}
+
+// TermURL returns the path of the listing page for a term
+func (t *Taxonomy) TermURL(term *Term) string {
+	return "/" + t.Name + "/" + term.Slug
+}
+
+// TermSlug converts a tag or category name to its URL form:
+// lowercase letters and digits separated by single hyphens
+func TermSlug(name string) string {
+	var b strings.Builder
+	pendingHyphen := false
+
+	for _, r := range strings.ToLower(strings.TrimSpace(name)) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if pendingHyphen && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			pendingHyphen = false
+			b.WriteRune(r)
+			continue
+		}
+		pendingHyphen = true
+	}
+
+	return b.String()
+}
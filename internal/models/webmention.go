@@ -0,0 +1,21 @@
+package models
+
+import "time"
+
+// WebmentionQueued is the status of a received webmention whose source
+// hasn't been checked yet. Verified webmentions move to a comment
+// moderation status.
+const WebmentionQueued = "queued"
+
+// Webmention records that another page links to one of our posts
+type Webmention struct {
+	ID        int64
+	Source    string // The page linking to the post
+	Target    string // The post URL the source links to
+	PostSlug  string
+	Status    string
+	Title     string // Title of the source page, once verified
+	Author    string
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
@@ -0,0 +1,60 @@
+package server
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+)
+
+// handleMeExport returns everything stored about the current user as a
+// JSON download
+func (s *Server) handleMeExport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	user := s.getSessionUser(r)
+	if user == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	dump, err := s.db.ExportUser(user.ID)
+	if err != nil || dump == nil {
+		http.Error(w, "Failed to export data", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", `attachment; filename="my-data.json"`)
+	w.Header().Set("Cache-Control", "no-store")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(dump)
+}
+
+// deleteAccount removes the current user and their data, then clears the
+// session cookie
+func (s *Server) deleteAccount(w http.ResponseWriter, r *http.Request) {
+	user := s.getSessionUser(r)
+	if user == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if user.ID == devUserID {
+		http.Error(w, "The dev user cannot be deleted", http.StatusBadRequest)
+		return
+	}
+
+	anonymize := s.config.Comments.OnAccountDelete == AccountAnonymizeComments
+	if err := s.db.DeleteUser(user.ID, anonymize); err != nil {
+		log.Printf("Failed to delete user %s: %v", user.ID, err)
+		http.Error(w, "Failed to delete account", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("Deleted account %s", user.ID)
+
+	s.clearSessionCookie(w)
+	w.WriteHeader(http.StatusNoContent)
+}
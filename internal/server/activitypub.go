@@ -0,0 +1,527 @@
+package server
+
+import (
+	"context"
+	"crypto/rsa"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"site/internal/activitypub"
+	"site/internal/build/manifest"
+)
+
+const (
+	apTimeout         = 10 * time.Second // Time allowed for each request to another server
+	apKeyCacheTTL     = 24 * time.Hour   // How long fetched public keys are trusted
+	apKeyCacheSize    = 1000
+	maxInboxBody      = 1 << 20
+	apOutboxLimit     = 20 // Most recent posts listed in the outbox
+	defaultAPUsername = "blog"
+)
+
+// apRetries are the waits before each retry of a failed delivery
+var apRetries = []time.Duration{time.Minute, 10 * time.Minute, time.Hour}
+
+// activityPub is the site's fediverse actor
+type activityPub struct {
+	username string
+	actorID  string
+	signer   *activitypub.Signer
+	pubKey   string // PEM, for the actor document
+
+	keys     map[string]remoteKey // Followers' public keys by key ID
+	keysLock sync.Mutex
+}
+
+type remoteKey struct {
+	owner   string
+	key     *rsa.PublicKey
+	fetched time.Time
+}
+
+// newActivityPub loads the actor's key, creating one on first start
+func (s *Server) newActivityPub() (*activityPub, error) {
+	pemKey, err := s.db.ActivityPubKey(activitypub.GenerateKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load actor key: %w", err)
+	}
+	key, err := activitypub.ParsePrivateKey(pemKey)
+	if err != nil {
+		return nil, err
+	}
+	pubKey, err := activitypub.EncodePublicKey(&key.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	username := s.config.ActivityPub.Username
+	if username == "" {
+		username = defaultAPUsername
+	}
+	actorID := s.config.BaseURL + "/ap/actor"
+	return &activityPub{
+		username: username,
+		actorID:  actorID,
+		signer:   &activitypub.Signer{KeyID: actorID + "#main-key", Key: key},
+		pubKey:   pubKey,
+		keys:     make(map[string]remoteKey),
+	}, nil
+}
+
+// writeActivityJSON writes an ActivityPub document
+func writeActivityJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", activitypub.ContentType)
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	json.NewEncoder(w).Encode(v)
+}
+
+// handleWebFinger resolves @username@host to the actor:
+// GET /.well-known/webfinger?resource=acct:username@host
+func (s *Server) handleWebFinger(w http.ResponseWriter, r *http.Request) {
+	host := s.config.BaseURL
+	if u, err := url.Parse(s.config.BaseURL); err == nil {
+		host = u.Host
+	}
+	acct := "acct:" + s.ap.username + "@" + host
+
+	resource := r.URL.Query().Get("resource")
+	if !strings.EqualFold(resource, acct) && resource != s.ap.actorID {
+		http.Error(w, "Resource not found", http.StatusNotFound)
+		return
+	}
+
+	type link struct {
+		Rel  string `json:"rel"`
+		Type string `json:"type"`
+		Href string `json:"href"`
+	}
+	w.Header().Set("Content-Type", "application/jrd+json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	json.NewEncoder(w).Encode(struct {
+		Subject string   `json:"subject"`
+		Aliases []string `json:"aliases"`
+		Links   []link   `json:"links"`
+	}{
+		Subject: acct,
+		Aliases: []string{s.ap.actorID},
+		Links: []link{
+			{Rel: "self", Type: activitypub.ContentType, Href: s.ap.actorID},
+			{Rel: "http://webfinger.net/rel/profile-page", Type: "text/html", Href: s.config.BaseURL + "/profile"},
+		},
+	})
+}
+
+// handleActor serves the site's actor, built from the profile in site.yml
+func (s *Server) handleActor(w http.ResponseWriter, r *http.Request) {
+	profile := s.config.Profile
+	name := s.config.ActivityPub.Name
+	if name == "" {
+		name = s.ap.username
+	}
+
+	actor := activitypub.Actor{
+		Context:           activitypub.Context,
+		ID:                s.ap.actorID,
+		Type:              "Person",
+		PreferredUsername: s.ap.username,
+		Name:              name,
+		URL:               s.config.BaseURL,
+		Inbox:             s.config.BaseURL + "/ap/inbox",
+		Outbox:            s.config.BaseURL + "/ap/outbox",
+		Followers:         s.config.BaseURL + "/ap/followers",
+		PublicKey: activitypub.PublicKey{
+			ID:           s.ap.signer.KeyID,
+			Owner:        s.ap.actorID,
+			PublicKeyPem: s.ap.pubKey,
+		},
+	}
+	if profile.Bio != "" {
+		actor.Summary = "<p>" + html.EscapeString(profile.Bio) + "</p>"
+	}
+	if profile.Photo != "" {
+		photo := profile.Photo
+		if strings.HasPrefix(photo, "/") {
+			photo = s.config.BaseURL + photo
+		}
+		actor.Icon = &activitypub.Image{Type: "Image", URL: photo}
+	}
+	for _, field := range []struct{ name, url string }{{"GitHub", profile.GitHub}, {"LinkedIn", profile.LinkedIn}} {
+		if field.url == "" {
+			continue
+		}
+		actor.Attachment = append(actor.Attachment, activitypub.PropertyValue{
+			Type:  "PropertyValue",
+			Name:  field.name,
+			Value: fmt.Sprintf(`<a href="%s" rel="me nofollow noopener" target="_blank">%s</a>`, html.EscapeString(field.url), html.EscapeString(field.url)),
+		})
+	}
+
+	writeActivityJSON(w, actor)
+}
+
+// seriesPost is a dated post announced to followers
+type seriesPost struct {
+	id string
+	manifest.Post
+}
+
+// seriesPosts returns the dated posts from the manifest, newest first
+func (s *Server) seriesPosts() []seriesPost {
+	s.postsLock.RLock()
+	var posts []seriesPost
+	if s.posts != nil {
+		for id, p := range s.posts.Posts {
+			if p.Series {
+				posts = append(posts, seriesPost{id, p})
+			}
+		}
+	}
+	s.postsLock.RUnlock()
+
+	sort.Slice(posts, func(i, j int) bool {
+		if !posts[i].Date.Equal(posts[j].Date) {
+			return posts[i].Date.After(posts[j].Date)
+		}
+		return posts[i].id < posts[j].id
+	})
+	return posts
+}
+
+// note describes a post as a Note linking to it
+func (s *Server) note(post seriesPost) activitypub.Note {
+	postURL := s.config.BaseURL + "/" + post.id
+	content := fmt.Sprintf(`<p><a href="%s">%s</a></p>`, html.EscapeString(postURL), html.EscapeString(post.Title))
+	if post.Description != "" {
+		content += "<p>" + html.EscapeString(post.Description) + "</p>"
+	}
+
+	n := activitypub.Note{
+		ID:           s.config.BaseURL + "/ap/posts/" + post.id,
+		Type:         "Note",
+		AttributedTo: s.ap.actorID,
+		Content:      content,
+		URL:          postURL,
+		To:           []string{activitypub.Public},
+		Cc:           []string{s.config.BaseURL + "/ap/followers"},
+	}
+	if !post.Date.IsZero() {
+		n.Published = post.Date.UTC().Format(time.RFC3339)
+	}
+	return n
+}
+
+// create wraps a post's Note in the activity that publishes it
+func (s *Server) create(post seriesPost) activitypub.Activity {
+	n := s.note(post)
+	return activitypub.Activity{
+		ID:        n.ID + "#create",
+		Type:      "Create",
+		Actor:     s.ap.actorID,
+		Object:    n,
+		Published: n.Published,
+		To:        n.To,
+		Cc:        n.Cc,
+	}
+}
+
+// handleOutbox lists the most recent posts: GET /ap/outbox
+func (s *Server) handleOutbox(w http.ResponseWriter, r *http.Request) {
+	posts := s.seriesPosts()
+	items := make([]activitypub.Activity, 0, apOutboxLimit)
+	for i := 0; i < len(posts) && i < apOutboxLimit; i++ {
+		items = append(items, s.create(posts[i]))
+	}
+
+	writeActivityJSON(w, activitypub.OrderedCollection{
+		Context:      activitypub.Context,
+		ID:           s.config.BaseURL + "/ap/outbox",
+		Type:         "OrderedCollection",
+		TotalItems:   len(posts),
+		OrderedItems: items,
+	})
+}
+
+// handleFollowers reports how many accounts follow the site, but not who:
+// GET /ap/followers
+func (s *Server) handleFollowers(w http.ResponseWriter, r *http.Request) {
+	count, err := s.db.CountFollowers()
+	if err != nil {
+		http.Error(w, "Failed to count followers", http.StatusInternalServerError)
+		return
+	}
+
+	writeActivityJSON(w, activitypub.OrderedCollection{
+		Context:    activitypub.Context,
+		ID:         s.config.BaseURL + "/ap/followers",
+		Type:       "OrderedCollection",
+		TotalItems: count,
+	})
+}
+
+// handleNote serves a post's Note: GET /ap/posts/{collection/slug}
+func (s *Server) handleNote(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/ap/posts/")
+	post, ok := s.lookupPost(id)
+	if !ok || !post.Series {
+		http.NotFound(w, r)
+		return
+	}
+
+	n := s.note(seriesPost{id, post})
+	n.Context = activitypub.Context
+	writeActivityJSON(w, n)
+}
+
+// handleInbox accepts signed activities: POST /ap/inbox. Follow and
+// Undo Follow change the followers; everything else is ignored.
+func (s *Server) handleInbox(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxInboxBody))
+	if err != nil {
+		http.Error(w, "Request too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	var activity activitypub.Activity
+	if err := json.Unmarshal(body, &activity); err != nil || activity.Actor == "" {
+		http.Error(w, "Invalid activity", http.StatusBadRequest)
+		return
+	}
+
+	owner, err := s.verifyInboxSignature(r, body)
+	if errors.Is(err, activitypub.ErrGone) && activity.Type == "Delete" &&
+		activitypub.ObjectID(activity.Object) == activity.Actor {
+		// A deleted account's key is deleted with it, so its Delete can't
+		// be verified; check that the actor is really gone instead
+		s.removeGoneFollower(r.Context(), activity.Actor)
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+	if err != nil {
+		log.Printf("Rejected %s from %s: %v", activity.Type, activity.Actor, err)
+		http.Error(w, "Invalid signature", http.StatusUnauthorized)
+		return
+	}
+	if owner != activity.Actor {
+		http.Error(w, "Signature does not match actor", http.StatusUnauthorized)
+		return
+	}
+
+	switch activity.Type {
+	case "Follow":
+		if activitypub.ObjectID(activity.Object) != s.ap.actorID {
+			http.Error(w, "Unknown actor", http.StatusBadRequest)
+			return
+		}
+		if err := s.addFollower(r.Context(), activity, body); err != nil {
+			log.Printf("Failed to accept follow from %s: %v", activity.Actor, err)
+			http.Error(w, "Failed to accept follow", http.StatusBadGateway)
+			return
+		}
+		log.Printf("New follower: %s", activity.Actor)
+
+	case "Undo":
+		// The Follow being undone is usually embedded; a bare ID has to
+		// match the one the follower sent
+		var followID string
+		switch activitypub.ObjectType(activity.Object) {
+		case "Follow":
+		case "":
+			followID = activitypub.ObjectID(activity.Object)
+		default:
+			w.WriteHeader(http.StatusAccepted)
+			return
+		}
+		err := s.db.RemoveFollower(activity.Actor, followID)
+		if err == nil {
+			log.Printf("Lost follower: %s", activity.Actor)
+		} else if err != sql.ErrNoRows {
+			http.Error(w, "Failed to remove follower", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// removeGoneFollower forgets a follower if their actor document answers
+// 410 Gone
+func (s *Server) removeGoneFollower(ctx context.Context, actor string) {
+	ctx, cancel := context.WithTimeout(ctx, apTimeout)
+	defer cancel()
+
+	_, err := activitypub.FetchActor(ctx, s.remoteClient, actor, s.ap.signer)
+	if !errors.Is(err, activitypub.ErrGone) {
+		return
+	}
+	if err := s.db.RemoveFollower(actor, ""); err == nil {
+		log.Printf("Lost follower: %s (deleted)", actor)
+	} else if err != sql.ErrNoRows {
+		log.Printf("Failed to remove follower %s: %v", actor, err)
+	}
+}
+
+// addFollower saves a follower and accepts their Follow
+func (s *Server) addFollower(ctx context.Context, follow activitypub.Activity, body []byte) error {
+	ctx, cancel := context.WithTimeout(ctx, apTimeout)
+	defer cancel()
+
+	follower, err := activitypub.FetchActor(ctx, s.remoteClient, follow.Actor, s.ap.signer)
+	if err != nil {
+		return err
+	}
+	sharedInbox := ""
+	if follower.Endpoints != nil {
+		sharedInbox = follower.Endpoints.SharedInbox
+	}
+	if err := s.db.AddFollower(follower.ID, follower.Inbox, sharedInbox, follow.ID); err != nil {
+		return err
+	}
+
+	nonce, err := generateToken(12)
+	if err != nil {
+		return err
+	}
+	go s.deliver(activitypub.Activity{
+		Context: activitypub.Context,
+		ID:      s.ap.actorID + "#accepts/" + nonce,
+		Type:    "Accept",
+		Actor:   s.ap.actorID,
+		Object:  json.RawMessage(body),
+	}, follower.Inbox)
+	return nil
+}
+
+// verifyInboxSignature checks an inbox request's HTTP signature and
+// returns the actor that signed it
+func (s *Server) verifyInboxSignature(r *http.Request, body []byte) (string, error) {
+	sig, err := activitypub.ParseSignature(r, body)
+	if err != nil {
+		return "", err
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), apTimeout)
+	defer cancel()
+
+	key, err := s.remoteKey(ctx, sig.KeyID, false)
+	if err != nil {
+		return "", err
+	}
+	if err := sig.Verify(key.key); err != nil {
+		// The key may have been rotated since it was cached
+		if key, err = s.remoteKey(ctx, sig.KeyID, true); err != nil {
+			return "", err
+		}
+		if err := sig.Verify(key.key); err != nil {
+			return "", err
+		}
+	}
+	return key.owner, nil
+}
+
+// remoteKey returns a signer's public key, from the cache unless refresh
+// is set or it has expired
+func (s *Server) remoteKey(ctx context.Context, keyID string, refresh bool) (remoteKey, error) {
+	s.ap.keysLock.Lock()
+	cached, ok := s.ap.keys[keyID]
+	s.ap.keysLock.Unlock()
+	if ok && !refresh && time.Since(cached.fetched) < apKeyCacheTTL {
+		return cached, nil
+	}
+
+	owner, key, err := activitypub.FetchKey(ctx, s.remoteClient, keyID, s.ap.signer)
+	if err != nil {
+		return remoteKey{}, err
+	}
+	fetched := remoteKey{owner: owner, key: key, fetched: time.Now()}
+
+	s.ap.keysLock.Lock()
+	if len(s.ap.keys) >= apKeyCacheSize {
+		clear(s.ap.keys)
+	}
+	s.ap.keys[keyID] = fetched
+	s.ap.keysLock.Unlock()
+	return fetched, nil
+}
+
+// publishNewPosts announces posts that followers haven't been sent yet.
+// Each post is only ever announced once, so the first load after enabling
+// ActivityPub, when nobody follows yet, marks the existing posts as sent.
+func (s *Server) publishNewPosts() {
+	if s.ap == nil {
+		return
+	}
+
+	inboxes, err := s.db.FollowerInboxes()
+	if err != nil {
+		log.Printf("Failed to load followers: %v", err)
+		return
+	}
+
+	posts := s.seriesPosts()
+	// Oldest first, so followers see new posts in order
+	for i := len(posts) - 1; i >= 0; i-- {
+		isNew, err := s.db.MarkPostPublished(posts[i].id)
+		if err != nil {
+			log.Printf("Failed to record published post: %v", err)
+			return
+		}
+		if !isNew || len(inboxes) == 0 {
+			continue
+		}
+
+		log.Printf("Sending %s to %d inboxes", posts[i].id, len(inboxes))
+		activity := s.create(posts[i])
+		activity.Context = activitypub.Context
+		for _, inbox := range inboxes {
+			go s.deliver(activity, inbox)
+		}
+	}
+}
+
+// deliver posts an activity to an inbox, retrying a few times if the
+// server is unreachable. Followers behind an inbox that answers 410 Gone
+// are removed. Deliveries still pending at shutdown are lost.
+func (s *Server) deliver(activity activitypub.Activity, inbox string) {
+	for attempt := 0; ; attempt++ {
+		ctx, cancel := context.WithTimeout(context.Background(), apTimeout)
+		err := activitypub.Deliver(ctx, s.remoteClient, inbox, activity, s.ap.signer)
+		cancel()
+		if err == nil {
+			return
+		}
+
+		if errors.Is(err, activitypub.ErrGone) {
+			n, err := s.db.RemoveInboxFollowers(inbox)
+			if err != nil {
+				log.Printf("Failed to remove followers of %s: %v", inbox, err)
+			} else {
+				log.Printf("Inbox %s is gone, removed %d followers", inbox, n)
+			}
+			return
+		}
+
+		if attempt == len(apRetries) {
+			log.Printf("Giving up delivering %s to %s: %v", activity.Type, inbox, err)
+			return
+		}
+		log.Printf("Failed to deliver %s to %s, retrying in %v: %v", activity.Type, inbox, apRetries[attempt], err)
+		time.Sleep(apRetries[attempt])
+	}
+}
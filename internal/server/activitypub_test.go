@@ -0,0 +1,360 @@
+package server
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"site/internal/activitypub"
+	"site/internal/db"
+)
+
+const testBaseURL = "http://site.test"
+
+// newTestServer returns a server with a fresh database and nothing else
+// set up
+func newTestServer(t *testing.T) *Server {
+	t.Helper()
+	database, err := db.New(filepath.Join(t.TempDir(), "site.db"))
+	if err != nil {
+		t.Fatalf("db.New: %v", err)
+	}
+	t.Cleanup(func() { database.Close() })
+
+	return &Server{
+		config:       Config{BaseURL: testBaseURL},
+		db:           database,
+		remoteClient: &http.Client{Timeout: 5 * time.Second},
+	}
+}
+
+// newTestAPServer returns a server with ActivityPub enabled
+func newTestAPServer(t *testing.T) *Server {
+	t.Helper()
+	s := newTestServer(t)
+	s.config.ActivityPub = ActivityPubConfig{Enabled: true}
+	ap, err := s.newActivityPub()
+	if err != nil {
+		t.Fatalf("newActivityPub: %v", err)
+	}
+	s.ap = ap
+	return s
+}
+
+// fakeInstance is a fediverse server hosting actors that follow the site.
+// It serves their actor documents and records what is delivered to their
+// inboxes.
+type fakeInstance struct {
+	t   *testing.T
+	srv *httptest.Server
+
+	mu          sync.Mutex
+	keys        map[string]*rsa.PrivateKey // Current key of each actor
+	gone        map[string]bool            // Actors deleted, answering 410
+	inboxStatus int                        // Status inboxes answer with
+
+	delivered chan *http.Request // Requests to inboxes, with their bodies in bodies
+	bodies    chan []byte
+}
+
+func newFakeInstance(t *testing.T, users ...string) *fakeInstance {
+	t.Helper()
+	f := &fakeInstance{
+		t:           t,
+		keys:        make(map[string]*rsa.PrivateKey),
+		gone:        make(map[string]bool),
+		inboxStatus: http.StatusAccepted,
+		delivered:   make(chan *http.Request, 10),
+		bodies:      make(chan []byte, 10),
+	}
+	for _, user := range users {
+		f.keys[user] = newRSAKey(t)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /users/{user}", func(w http.ResponseWriter, r *http.Request) {
+		user := r.PathValue("user")
+		f.mu.Lock()
+		key, gone := f.keys[user], f.gone[user]
+		f.mu.Unlock()
+		if gone {
+			http.Error(w, "Gone", http.StatusGone)
+			return
+		}
+		if key == nil {
+			http.NotFound(w, r)
+			return
+		}
+		pem, err := activitypub.EncodePublicKey(&key.PublicKey)
+		if err != nil {
+			t.Error(err)
+		}
+		w.Header().Set("Content-Type", activitypub.ContentType)
+		json.NewEncoder(w).Encode(activitypub.Actor{
+			ID:    f.actor(user),
+			Type:  "Person",
+			Inbox: f.inbox(user),
+			PublicKey: activitypub.PublicKey{
+				ID:           f.keyID(user),
+				Owner:        f.actor(user),
+				PublicKeyPem: pem,
+			},
+		})
+	})
+	mux.HandleFunc("POST /users/{user}/inbox", func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		f.delivered <- r
+		f.bodies <- body
+		f.mu.Lock()
+		status := f.inboxStatus
+		f.mu.Unlock()
+		w.WriteHeader(status)
+	})
+
+	f.srv = httptest.NewServer(mux)
+	t.Cleanup(f.srv.Close)
+	return f
+}
+
+func newRSAKey(t *testing.T) *rsa.PrivateKey {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func (f *fakeInstance) actor(user string) string { return f.srv.URL + "/users/" + user }
+func (f *fakeInstance) inbox(user string) string { return f.actor(user) + "/inbox" }
+func (f *fakeInstance) keyID(user string) string { return f.actor(user) + "#main-key" }
+
+func (f *fakeInstance) key(user string) *rsa.PrivateKey {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.keys[user]
+}
+
+func (f *fakeInstance) setInboxStatus(status int) {
+	f.mu.Lock()
+	f.inboxStatus = status
+	f.mu.Unlock()
+}
+
+// post sends an activity to the site's inbox signed with key under keyID
+// and returns the response status
+func (f *fakeInstance) post(s *Server, activity activitypub.Activity, keyID string, key *rsa.PrivateKey, tamper func([]byte) []byte) int {
+	f.t.Helper()
+	body, err := json.Marshal(activity)
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	req := httptest.NewRequest(http.MethodPost, testBaseURL+"/ap/inbox", bytes.NewReader(body))
+	req.Header.Set("Content-Type", activitypub.ContentType)
+	signer := &activitypub.Signer{KeyID: keyID, Key: key}
+	if err := signer.Sign(req, body); err != nil {
+		f.t.Fatal(err)
+	}
+	if tamper != nil {
+		body = tamper(body)
+		req.Body = io.NopCloser(bytes.NewReader(body))
+		req.ContentLength = int64(len(body))
+	}
+
+	rec := httptest.NewRecorder()
+	s.handleInbox(rec, req)
+	return rec.Code
+}
+
+// follow sends user's Follow signed with their current key
+func (f *fakeInstance) follow(s *Server, user string) int {
+	return f.post(s, activitypub.Activity{
+		ID:     f.actor(user) + "#follows/1",
+		Type:   "Follow",
+		Actor:  f.actor(user),
+		Object: s.ap.actorID,
+	}, f.keyID(user), f.key(user), nil)
+}
+
+// expectAccept waits for the site to accept a follow and checks the
+// delivery was signed with the site's key
+func (f *fakeInstance) expectAccept(s *Server, user string) {
+	f.t.Helper()
+	select {
+	case r := <-f.delivered:
+		body := <-f.bodies
+		if r.URL.Path != "/users/"+user+"/inbox" {
+			f.t.Errorf("delivered to %s, want %s's inbox", r.URL.Path, user)
+		}
+		sig, err := activitypub.ParseSignature(r, body)
+		if err != nil {
+			f.t.Fatalf("delivery signature: %v", err)
+		}
+		if sig.KeyID != s.ap.signer.KeyID {
+			f.t.Errorf("delivery signed by %s", sig.KeyID)
+		}
+		if err := sig.Verify(&s.ap.signer.Key.PublicKey); err != nil {
+			f.t.Errorf("delivery signature: %v", err)
+		}
+		var accept activitypub.Activity
+		if err := json.Unmarshal(body, &accept); err != nil {
+			f.t.Fatal(err)
+		}
+		if accept.Type != "Accept" || accept.Actor != s.ap.actorID ||
+			activitypub.ObjectID(accept.Object) != f.actor(user)+"#follows/1" {
+			f.t.Errorf("unexpected delivery %s", body)
+		}
+	case <-time.After(5 * time.Second):
+		f.t.Fatal("follow was not accepted")
+	}
+}
+
+func countFollowers(t *testing.T, s *Server) int {
+	t.Helper()
+	n, err := s.db.CountFollowers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestInboxFollowAndUndo(t *testing.T) {
+	s := newTestAPServer(t)
+	f := newFakeInstance(t, "alice", "bob", "mallory")
+
+	if code := f.follow(s, "alice"); code != http.StatusAccepted {
+		t.Fatalf("follow: got %d", code)
+	}
+	f.expectAccept(s, "alice")
+	if n := countFollowers(t, s); n != 1 {
+		t.Fatalf("followers after follow = %d, want 1", n)
+	}
+
+	// Signed with a key that isn't bob's
+	code := f.post(s, activitypub.Activity{
+		ID: f.actor("bob") + "#follows/1", Type: "Follow", Actor: f.actor("bob"), Object: s.ap.actorID,
+	}, f.keyID("bob"), newRSAKey(t), nil)
+	if code != http.StatusUnauthorized {
+		t.Errorf("bad signature: got %d, want 401", code)
+	}
+
+	// Body swapped after signing, so the Digest doesn't match
+	code = f.post(s, activitypub.Activity{
+		ID: f.actor("bob") + "#follows/1", Type: "Follow", Actor: f.actor("bob"), Object: s.ap.actorID,
+	}, f.keyID("bob"), f.key("bob"), func(body []byte) []byte {
+		return bytes.Replace(body, []byte("follows/1"), []byte("follows/2"), 1)
+	})
+	if code != http.StatusUnauthorized {
+		t.Errorf("wrong digest: got %d, want 401", code)
+	}
+	if n := countFollowers(t, s); n != 1 {
+		t.Fatalf("followers after rejected follows = %d, want 1", n)
+	}
+
+	// Mallory's valid signature can't undo alice's follow
+	undo := activitypub.Activity{
+		ID:    f.actor("alice") + "#undo/1",
+		Type:  "Undo",
+		Actor: f.actor("alice"),
+		Object: map[string]any{
+			"id": f.actor("alice") + "#follows/1", "type": "Follow", "actor": f.actor("alice"), "object": s.ap.actorID,
+		},
+	}
+	if code := f.post(s, undo, f.keyID("mallory"), f.key("mallory"), nil); code != http.StatusUnauthorized {
+		t.Errorf("undo signed by another actor: got %d, want 401", code)
+	}
+	if n := countFollowers(t, s); n != 1 {
+		t.Fatalf("followers after forged undo = %d, want 1", n)
+	}
+
+	if code := f.post(s, undo, f.keyID("alice"), f.key("alice"), nil); code != http.StatusAccepted {
+		t.Errorf("undo: got %d", code)
+	}
+	if n := countFollowers(t, s); n != 0 {
+		t.Fatalf("followers after undo = %d, want 0", n)
+	}
+}
+
+func TestInboxKeyRotation(t *testing.T) {
+	s := newTestAPServer(t)
+	f := newFakeInstance(t, "alice")
+
+	if code := f.follow(s, "alice"); code != http.StatusAccepted {
+		t.Fatalf("follow: got %d", code)
+	}
+	f.expectAccept(s, "alice")
+
+	// The site has alice's old key cached; a signature with her new key
+	// makes it fetch the key again
+	f.mu.Lock()
+	f.keys["alice"] = newRSAKey(t)
+	f.mu.Unlock()
+
+	undo := activitypub.Activity{
+		ID: f.actor("alice") + "#undo/1", Type: "Undo", Actor: f.actor("alice"), Object: f.actor("alice") + "#follows/1",
+	}
+	if code := f.post(s, undo, f.keyID("alice"), f.key("alice"), nil); code != http.StatusAccepted {
+		t.Fatalf("undo with rotated key: got %d", code)
+	}
+	if n := countFollowers(t, s); n != 0 {
+		t.Fatalf("followers after undo = %d, want 0", n)
+	}
+}
+
+func TestDeliverRemovesGoneInbox(t *testing.T) {
+	s := newTestAPServer(t)
+	f := newFakeInstance(t, "alice")
+
+	if code := f.follow(s, "alice"); code != http.StatusAccepted {
+		t.Fatalf("follow: got %d", code)
+	}
+	f.expectAccept(s, "alice")
+
+	f.setInboxStatus(http.StatusGone)
+	s.deliver(activitypub.Activity{ID: s.ap.actorID + "#test", Type: "Create", Actor: s.ap.actorID}, f.inbox("alice"))
+	if n := countFollowers(t, s); n != 0 {
+		t.Fatalf("followers after 410 = %d, want 0", n)
+	}
+}
+
+func TestInboxDeleteOfGoneActor(t *testing.T) {
+	s := newTestAPServer(t)
+	f := newFakeInstance(t, "alice", "bob")
+
+	for _, user := range []string{"alice", "bob"} {
+		if code := f.follow(s, user); code != http.StatusAccepted {
+			t.Fatalf("follow %s: got %d", user, code)
+		}
+		f.expectAccept(s, user)
+	}
+
+	// Bob still exists, so an unverifiable Delete claiming to be him is
+	// refused
+	deleteBob := activitypub.Activity{ID: f.actor("bob") + "#delete", Type: "Delete", Actor: f.actor("bob"), Object: f.actor("bob")}
+	if code := f.post(s, deleteBob, f.keyID("bob"), newRSAKey(t), nil); code != http.StatusUnauthorized {
+		t.Errorf("forged delete: got %d, want 401", code)
+	}
+
+	// Alice's account is deleted along with her key
+	key := f.key("alice")
+	f.mu.Lock()
+	f.gone["alice"] = true
+	f.mu.Unlock()
+	s.ap.keys = make(map[string]remoteKey)
+
+	deleteAlice := activitypub.Activity{ID: f.actor("alice") + "#delete", Type: "Delete", Actor: f.actor("alice"), Object: f.actor("alice")}
+	if code := f.post(s, deleteAlice, f.keyID("alice"), key, nil); code != http.StatusAccepted {
+		t.Errorf("delete: got %d", code)
+	}
+	if n := countFollowers(t, s); n != 1 {
+		t.Fatalf("followers after delete = %d, want 1", n)
+	}
+}
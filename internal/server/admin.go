@@ -0,0 +1,175 @@
+package server
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"site/internal/models"
+)
+
+// adminListLimit caps the comments returned by the moderation queue
+const adminListLimit = 200
+
+// roleFor returns the role a user gets from the admins list in site.yml.
+// Entries match the provider-qualified user ID, or the email once the sign
+// in provider has verified it.
+func (s *Server) roleFor(user *models.User) string {
+	for _, admin := range s.config.Admins {
+		if admin == user.ID || (user.EmailVerified && user.Email != "" && strings.EqualFold(admin, user.Email)) {
+			return models.RoleAdmin
+		}
+	}
+	return models.RoleUser
+}
+
+// commentStatus returns the moderation status a new comment starts with
+func (s *Server) commentStatus(user *models.User) (string, error) {
+	if user.IsAdmin() {
+		return models.CommentApproved, nil
+	}
+
+	switch s.config.Comments.Moderation {
+	case ModerationAll:
+		return models.CommentPending, nil
+	case ModerationFirst:
+		trusted, err := s.db.HasApprovedComment(user.ID)
+		if err != nil {
+			return "", err
+		}
+		if !trusted {
+			return models.CommentPending, nil
+		}
+	case "", ModerationNone:
+	default:
+		log.Printf("Unknown comment moderation policy %q, publishing immediately", s.config.Comments.Moderation)
+	}
+	return models.CommentApproved, nil
+}
+
+// requireAdmin writes an error and returns nil unless the request comes
+// from an admin
+func (s *Server) requireAdmin(w http.ResponseWriter, r *http.Request) *models.User {
+	user := s.getSessionUser(r)
+	if user == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return nil
+	}
+	if !user.IsAdmin() {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return nil
+	}
+	return user
+}
+
+type adminCommentResponse struct {
+	commentResponse
+	Post string `json:"post"`
+}
+
+// handleAdminComments lists comments by moderation status:
+// GET /api/admin/comments?status=pending
+func (s *Server) handleAdminComments(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.requireAdmin(w, r) == nil {
+		return
+	}
+
+	status := r.URL.Query().Get("status")
+	if status == "" {
+		status = models.CommentPending
+	}
+	if !models.IsValidCommentStatus(status) {
+		http.Error(w, "Invalid status", http.StatusBadRequest)
+		return
+	}
+
+	comments, err := s.db.ListCommentsByStatus(status, adminListLimit)
+	if err != nil {
+		http.Error(w, "Failed to list comments", http.StatusInternalServerError)
+		return
+	}
+
+	response := make([]adminCommentResponse, 0, len(comments))
+	for _, c := range comments {
+		response = append(response, adminCommentResponse{
+			commentResponse: commentResponse{
+				ID:          c.ID,
+				Content:     c.Content,
+				ContentHTML: renderCommentMarkdown(c.Content),
+				CreatedAt:   c.CreatedAt.Format(time.RFC3339),
+				UpdatedAt:   c.UpdatedAt.Format(time.RFC3339),
+				UserID:      c.UserID,
+				UserName:    c.UserName,
+				UserAvatar:  c.UserAvatar,
+				Status:      c.Status,
+			},
+			Post: c.PostSlug,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// moderationActions maps admin actions to the status they set
+var moderationActions = map[string]string{
+	"approve": models.CommentApproved,
+	"reject":  models.CommentRejected,
+	"spam":    models.CommentSpam,
+}
+
+// handleAdminComment moderates a single comment:
+// POST /api/admin/comments/123/{approve,reject,spam} or
+// DELETE /api/admin/comments/123
+func (s *Server) handleAdminComment(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/api/admin/comments/")
+	idPart, action, _ := strings.Cut(path, "/")
+	id, err := strconv.ParseInt(idPart, 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid comment ID", http.StatusBadRequest)
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodDelete && action == "":
+		if s.requireAdmin(w, r) == nil {
+			return
+		}
+		s.writeModerationResult(w, s.db.DeleteAnyComment(id))
+		return
+
+	case r.Method == http.MethodPost:
+		status, ok := moderationActions[action]
+		if !ok {
+			http.Error(w, "Unknown action", http.StatusNotFound)
+			return
+		}
+		if s.requireAdmin(w, r) == nil {
+			return
+		}
+		s.writeModerationResult(w, s.db.SetCommentStatus(id, status))
+		return
+	}
+
+	http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+}
+
+func (s *Server) writeModerationResult(w http.ResponseWriter, err error) {
+	if err == sql.ErrNoRows {
+		http.Error(w, "Comment not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to moderate comment", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,189 @@
+package server
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"site/internal/analytics"
+	"site/internal/models"
+)
+
+const (
+	analyticsQueueSize   = 1000             // Views waiting to be saved before new ones are dropped
+	analyticsBatchSize   = 100              // Views saved in one transaction
+	analyticsFlushEvery  = 10 * time.Second // How often queued views are saved
+	analyticsRollupEvery = time.Hour        // How often finished days are rolled up
+	maxAnalyticsPath     = 512
+	defaultStatsDays     = 30
+	defaultStatsLimit    = 10
+	maxStatsLimit        = 100
+)
+
+// pageHit is a view waiting to be saved. The client's address is only
+// held in memory until its visitor hash is computed.
+type pageHit struct {
+	time      time.Time
+	path      string
+	ip        string
+	userAgent string
+	referrer  string
+	agent     string
+}
+
+// countPageView queues a view of an HTML page. Nothing is counted when
+// analytics are off, for bots and prefetches, or when the browser opted
+// out of tracking.
+func (s *Server) countPageView(r *http.Request) {
+	if s.pageViews == nil || r.Method != http.MethodGet || analytics.OptedOut(r) || analytics.Prefetch(r) {
+		return
+	}
+	agent := analytics.Classify(r.UserAgent())
+	if agent == analytics.ClassBot {
+		return
+	}
+
+	ownHost := r.Host
+	if base, err := url.Parse(s.config.BaseURL); err == nil && base.Host != "" {
+		ownHost = base.Host
+	}
+
+	hit := pageHit{
+		time:      time.Now(),
+		path:      analyticsPath(r.URL.Path),
+		ip:        s.clientIP(r),
+		userAgent: r.UserAgent(),
+		referrer:  analytics.ReferrerHost(r.Referer(), ownHost),
+		agent:     agent,
+	}
+	select {
+	case s.pageViews <- hit:
+	default:
+		// Dropping a view beats slowing down the page
+	}
+}
+
+// analyticsPath returns the path a page is counted under, so /blog/post,
+// /blog/post/ and /blog/post/index.html are one page
+func analyticsPath(p string) string {
+	p = path.Clean("/" + p)
+	p = strings.TrimSuffix(p, "/index.html")
+	p = strings.TrimSuffix(p, ".html")
+	if p == "" {
+		p = "/"
+	}
+	return truncate(p, maxAnalyticsPath)
+}
+
+// recordPageViews saves queued views in batches and rolls up finished
+// days, until stop is closed
+func (s *Server) recordPageViews(stop <-chan struct{}) {
+	flush := time.NewTicker(analyticsFlushEvery)
+	defer flush.Stop()
+	rollup := time.NewTicker(analyticsRollupEvery)
+	defer rollup.Stop()
+
+	var batch []models.PageView
+	var saltDay, salt string
+
+	save := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := s.db.RecordPageViews(batch); err != nil {
+			log.Printf("Failed to save %d page views: %v", len(batch), err)
+		}
+		batch = nil
+	}
+
+	s.rollupAnalytics()
+	for {
+		select {
+		case hit := <-s.pageViews:
+			day := analytics.Day(hit.time)
+			if day != saltDay {
+				var err error
+				if salt, err = s.db.AnalyticsSalt(day, analytics.NewSalt); err != nil {
+					log.Printf("Failed to load analytics salt: %v", err)
+					continue
+				}
+				saltDay = day
+			}
+
+			batch = append(batch, models.PageView{
+				Day:      day,
+				Path:     hit.path,
+				Visitor:  analytics.Visitor(salt, hit.ip, hit.userAgent),
+				Referrer: hit.referrer,
+				Agent:    hit.agent,
+			})
+			if len(batch) >= analyticsBatchSize {
+				save()
+			}
+		case <-flush.C:
+			save()
+		case <-rollup.C:
+			save()
+			s.rollupAnalytics()
+		case <-stop:
+			save()
+			return
+		}
+	}
+}
+
+// rollupAnalytics folds the views of days before today into the daily
+// counts, dropping their visitor hashes and salts
+func (s *Server) rollupAnalytics() {
+	n, err := s.db.RollupAnalytics(analytics.Day(time.Now()))
+	if err != nil {
+		log.Printf("Failed to roll up page views: %v", err)
+	} else if n > 0 {
+		log.Printf("Rolled up %d page views", n)
+	}
+}
+
+// handleAdminStats reports the busiest pages, referrers and browser
+// classes, and daily totals: GET /api/admin/stats?days=30&limit=10
+func (s *Server) handleAdminStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.requireAdmin(w, r) == nil {
+		return
+	}
+
+	days, limit := defaultStatsDays, defaultStatsLimit
+	if v := r.URL.Query().Get("days"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			http.Error(w, "Invalid days", http.StatusBadRequest)
+			return
+		}
+		days = n
+	}
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxStatsLimit {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	stats, err := s.db.AnalyticsStats(analytics.Since(days), limit)
+	if err != nil {
+		log.Printf("Failed to read stats: %v", err)
+		http.Error(w, "Failed to read stats", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stats)
+}
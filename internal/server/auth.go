@@ -1,20 +1,21 @@
 package server
 
 import (
+	"context"
 	"crypto/rand"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"log"
 	"net/http"
 	"os"
-	"strconv"
+	"strings"
 	"time"
 
+	"site/internal/auth"
 	"site/internal/models"
 
 	"golang.org/x/oauth2"
-	"golang.org/x/oauth2/github"
-	"golang.org/x/oauth2/google"
 )
 
 const (
@@ -22,50 +23,95 @@ const (
 	sessionDuration   = 30 * 24 * time.Hour // 30 days
 )
 
-// getOAuthConfig returns the Google OAuth2 config
-func (s *Server) getOAuthConfig() *oauth2.Config {
-	return &oauth2.Config{
-		ClientID:     os.Getenv("GOOGLE_CLIENT_ID"),
-		ClientSecret: os.Getenv("GOOGLE_CLIENT_SECRET"),
-		RedirectURL:  s.config.BaseURL + "/auth/google/callback",
-		Scopes: []string{
-			"https://www.googleapis.com/auth/userinfo.email",
-			"https://www.googleapis.com/auth/userinfo.profile",
-		},
-		Endpoint: google.Endpoint,
+// authHTTPClient makes requests to identity providers
+var authHTTPClient = &http.Client{Timeout: 10 * time.Second}
+
+// loadAuthProviders registers Google and GitHub when their credentials are
+// in the environment, then the OpenID Connect providers in site.yml. A
+// provider whose discovery fails is logged and left out.
+func (s *Server) loadAuthProviders() {
+	callback := func(id string) string {
+		return s.config.BaseURL + "/auth/" + id + "/callback"
+	}
+
+	if id := os.Getenv("GOOGLE_CLIENT_ID"); id != "" {
+		s.addAuthProvider(auth.NewGoogle(id, os.Getenv("GOOGLE_CLIENT_SECRET"), callback("google")))
+	}
+	if id := os.Getenv("GITHUB_CLIENT_ID"); id != "" {
+		s.addAuthProvider(auth.NewGitHub(id, os.Getenv("GITHUB_CLIENT_SECRET"), callback("github")))
+	}
+
+	for _, cfg := range s.config.Auth.OIDC {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		ctx = context.WithValue(ctx, oauth2.HTTPClient, authHTTPClient)
+		provider, err := auth.NewOIDC(ctx, cfg, os.Getenv(cfg.ClientSecretEnv), callback(cfg.ID))
+		cancel()
+		if err != nil {
+			log.Printf("Skipping sign in provider %q: %v", cfg.ID, err)
+			continue
+		}
+		s.addAuthProvider(provider)
 	}
 }
 
-// handleGoogleAuth initiates the Google OAuth flow
-func (s *Server) handleGoogleAuth(w http.ResponseWriter, r *http.Request) {
-	cfg := s.getOAuthConfig()
-	if cfg.ClientID == "" {
-		http.Error(w, "OAuth not configured", http.StatusServiceUnavailable)
+// addAuthProvider registers a provider unless its ID is taken
+func (s *Server) addAuthProvider(p auth.Provider) {
+	if p.ID() == "logout" || p.ID() == "email" || s.authProvider(p.ID()) != nil {
+		log.Printf("Skipping sign in provider %q: id already in use", p.ID())
 		return
 	}
+	s.providers = append(s.providers, p)
+}
 
-	// Store redirect URL in state (simple approach)
-	redirect := r.URL.Query().Get("redirect")
-	if redirect == "" {
-		redirect = "/"
+// authProvider returns the registered provider with an ID, or nil
+func (s *Server) authProvider(id string) auth.Provider {
+	for _, p := range s.providers {
+		if p.ID() == id {
+			return p
+		}
 	}
+	return nil
+}
 
-	state := base64.URLEncoding.EncodeToString([]byte(redirect))
-	url := cfg.AuthCodeURL(state, oauth2.AccessTypeOffline)
+// handleAuth starts signing in with a provider at /auth/{provider} and
+// finishes at /auth/{provider}/callback
+func (s *Server) handleAuth(w http.ResponseWriter, r *http.Request) {
+	id, rest, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/auth/"), "/")
+	provider := s.authProvider(id)
+	if provider == nil {
+		http.Error(w, "Unknown sign in provider", http.StatusNotFound)
+		return
+	}
 
-	http.Redirect(w, r, url, http.StatusTemporaryRedirect)
+	switch rest {
+	case "":
+		s.startLogin(w, r, provider)
+	case "callback":
+		s.finishLogin(w, r, provider)
+	default:
+		http.NotFound(w, r)
+	}
 }
 
-// handleGoogleCallback handles the OAuth callback
-func (s *Server) handleGoogleCallback(w http.ResponseWriter, r *http.Request) {
-	cfg := s.getOAuthConfig()
+// startLogin sends the browser to the provider
+func (s *Server) startLogin(w http.ResponseWriter, r *http.Request, provider auth.Provider) {
+	state, err := s.newOAuthState(w, r.URL.Query().Get("redirect"))
+	if err != nil {
+		http.Error(w, "Failed to start login", http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, provider.AuthURL(state), http.StatusTemporaryRedirect)
+}
 
-	// Get the redirect URL from state
-	state := r.URL.Query().Get("state")
-	redirectBytes, _ := base64.URLEncoding.DecodeString(state)
-	redirect := string(redirectBytes)
-	if redirect == "" {
-		redirect = "/"
+// finishLogin handles the provider's callback: it signs the user in and
+// returns them to the page they started from
+func (s *Server) finishLogin(w http.ResponseWriter, r *http.Request, provider auth.Provider) {
+	// Verify the state before touching the code
+	redirect, err := s.consumeOAuthState(w, r)
+	if err != nil {
+		http.Error(w, "Invalid login state, please try again", http.StatusBadRequest)
+		return
 	}
 
 	code := r.URL.Query().Get("code")
@@ -74,70 +120,40 @@ func (s *Server) handleGoogleCallback(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Exchange code for token
-	token, err := cfg.Exchange(r.Context(), code)
+	ctx := context.WithValue(r.Context(), oauth2.HTTPClient, authHTTPClient)
+	token, err := provider.Exchange(ctx, code)
 	if err != nil {
+		log.Printf("Failed to exchange %s code: %v", provider.ID(), err)
 		http.Error(w, "Failed to exchange token", http.StatusInternalServerError)
 		return
 	}
 
-	// Get user info
-	client := cfg.Client(r.Context(), token)
-	resp, err := client.Get("https://www.googleapis.com/oauth2/v2/userinfo")
+	user, err := provider.Profile(ctx, token)
 	if err != nil {
+		log.Printf("Failed to get %s profile: %v", provider.ID(), err)
 		http.Error(w, "Failed to get user info", http.StatusInternalServerError)
 		return
 	}
-	defer resp.Body.Close()
 
-	var userInfo struct {
-		ID      string `json:"id"`
-		Email   string `json:"email"`
-		Name    string `json:"name"`
-		Picture string `json:"picture"`
-	}
-	if err := json.NewDecoder(resp.Body).Decode(&userInfo); err != nil {
-		http.Error(w, "Failed to decode user info", http.StatusInternalServerError)
-		return
-	}
+	s.signIn(w, r, user, redirect, http.StatusTemporaryRedirect)
+}
 
+// signIn saves a user who has proven who they are, starts their session
+// and redirects them back to where they were
+func (s *Server) signIn(w http.ResponseWriter, r *http.Request, user *models.User, redirect string, code int) {
 	// Create or update user
-	user := &models.User{
-		ID:        "google:" + userInfo.ID,
-		Email:     userInfo.Email,
-		Name:      userInfo.Name,
-		AvatarURL: userInfo.Picture,
-		CreatedAt: time.Now(),
-	}
+	user.Role = s.roleFor(user)
 	if err := s.db.CreateOrUpdateUser(user); err != nil {
 		http.Error(w, "Failed to save user", http.StatusInternalServerError)
 		return
 	}
 
-	// Create session
-	sessionToken, err := generateToken(32)
-	if err != nil {
+	if err := s.startSession(w, r, user.ID); err != nil {
 		http.Error(w, "Failed to create session", http.StatusInternalServerError)
 		return
 	}
 
-	expiresAt := time.Now().Add(sessionDuration)
-	if err := s.db.CreateSession(sessionToken, user.ID, expiresAt); err != nil {
-		http.Error(w, "Failed to save session", http.StatusInternalServerError)
-		return
-	}
-
-	// Set session cookie
-	http.SetCookie(w, &http.Cookie{
-		Name:     sessionCookieName,
-		Value:    sessionToken,
-		Path:     "/",
-		Expires:  expiresAt,
-		HttpOnly: true,
-		SameSite: http.SameSiteLaxMode,
-	})
-
-	http.Redirect(w, r, redirect, http.StatusTemporaryRedirect)
+	http.Redirect(w, r, redirect, code)
 }
 
 // handleLogout clears the session
@@ -148,19 +164,9 @@ func (s *Server) handleLogout(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Clear cookie
-	http.SetCookie(w, &http.Cookie{
-		Name:     sessionCookieName,
-		Value:    "",
-		Path:     "/",
-		Expires:  time.Unix(0, 0),
-		HttpOnly: true,
-		SameSite: http.SameSiteLaxMode,
-	})
-
-	redirect := r.URL.Query().Get("redirect")
-	if redirect == "" {
-		redirect = "/"
-	}
+	s.clearSessionCookie(w)
+
+	redirect := safeRedirect(r.URL.Query().Get("redirect"))
 	http.Redirect(w, r, redirect, http.StatusTemporaryRedirect)
 }
 
@@ -175,8 +181,8 @@ func (s *Server) getSessionUser(r *http.Request) *models.User {
 		return nil
 	}
 
-	userID, err := s.db.GetSession(cookie.Value)
-	if err != nil || userID == "" {
+	sess, err := s.db.GetSession(cookie.Value)
+	if err != nil || sess == nil {
 		// In dev mode, return the ephemeral dev user if session invalid
 		if s.config.DevMode && s.devUser != nil {
 			return s.devUser
@@ -184,7 +190,7 @@ func (s *Server) getSessionUser(r *http.Request) *models.User {
 		return nil
 	}
 
-	user, err := s.db.GetUser(userID)
+	user, err := s.db.GetUser(sess.UserID)
 	if err != nil {
 		return nil
 	}
@@ -201,157 +207,22 @@ func generateToken(length int) (string, error) {
 	return base64.URLEncoding.EncodeToString(bytes), nil
 }
 
-// getGitHubOAuthConfig returns the GitHub OAuth2 config
-func (s *Server) getGitHubOAuthConfig() *oauth2.Config {
-	return &oauth2.Config{
-		ClientID:     os.Getenv("GITHUB_CLIENT_ID"),
-		ClientSecret: os.Getenv("GITHUB_CLIENT_SECRET"),
-		RedirectURL:  s.config.BaseURL + "/auth/github/callback",
-		Scopes:       []string{"user:email"},
-		Endpoint:     github.Endpoint,
-	}
-}
-
-// handleGitHubAuth initiates the GitHub OAuth flow
-func (s *Server) handleGitHubAuth(w http.ResponseWriter, r *http.Request) {
-	cfg := s.getGitHubOAuthConfig()
-	if cfg.ClientID == "" {
-		http.Error(w, "GitHub OAuth not configured", http.StatusServiceUnavailable)
-		return
-	}
-
-	redirect := r.URL.Query().Get("redirect")
-	if redirect == "" {
-		redirect = "/"
-	}
-
-	state := base64.URLEncoding.EncodeToString([]byte(redirect))
-	url := cfg.AuthCodeURL(state, oauth2.AccessTypeOffline)
-
-	http.Redirect(w, r, url, http.StatusTemporaryRedirect)
-}
-
-// handleGitHubCallback handles the GitHub OAuth callback
-func (s *Server) handleGitHubCallback(w http.ResponseWriter, r *http.Request) {
-	cfg := s.getGitHubOAuthConfig()
-
-	state := r.URL.Query().Get("state")
-	redirectBytes, _ := base64.URLEncoding.DecodeString(state)
-	redirect := string(redirectBytes)
-	if redirect == "" {
-		redirect = "/"
-	}
-
-	code := r.URL.Query().Get("code")
-	if code == "" {
-		http.Error(w, "Missing code", http.StatusBadRequest)
-		return
-	}
-
-	token, err := cfg.Exchange(r.Context(), code)
-	if err != nil {
-		http.Error(w, "Failed to exchange token", http.StatusInternalServerError)
-		return
-	}
-
-	client := cfg.Client(r.Context(), token)
-	resp, err := client.Get("https://api.github.com/user")
-	if err != nil {
-		http.Error(w, "Failed to get user info", http.StatusInternalServerError)
-		return
-	}
-	defer resp.Body.Close()
-
-	var userInfo struct {
-		ID        int64  `json:"id"`
-		Login     string `json:"login"`
-		Name      string `json:"name"`
-		Email     string `json:"email"`
-		AvatarURL string `json:"avatar_url"`
-	}
-	if err := json.NewDecoder(resp.Body).Decode(&userInfo); err != nil {
-		http.Error(w, "Failed to decode user info", http.StatusInternalServerError)
-		return
-	}
-
-	// GitHub may not return email in main response, fetch from emails endpoint
-	if userInfo.Email == "" {
-		emailResp, err := client.Get("https://api.github.com/user/emails")
-		if err == nil {
-			defer emailResp.Body.Close()
-			var emails []struct {
-				Email   string `json:"email"`
-				Primary bool   `json:"primary"`
-			}
-			if json.NewDecoder(emailResp.Body).Decode(&emails) == nil {
-				for _, e := range emails {
-					if e.Primary {
-						userInfo.Email = e.Email
-						break
-					}
-				}
-			}
-		}
-	}
-
-	// Use login as name if name is empty
-	displayName := userInfo.Name
-	if displayName == "" {
-		displayName = userInfo.Login
-	}
-
-	user := &models.User{
-		ID:        "github:" + strconv.FormatInt(userInfo.ID, 10),
-		Email:     userInfo.Email,
-		Name:      displayName,
-		AvatarURL: userInfo.AvatarURL,
-		CreatedAt: time.Now(),
-	}
-	if err := s.db.CreateOrUpdateUser(user); err != nil {
-		http.Error(w, "Failed to save user", http.StatusInternalServerError)
-		return
-	}
-
-	sessionToken, err := generateToken(32)
-	if err != nil {
-		http.Error(w, "Failed to create session", http.StatusInternalServerError)
-		return
-	}
-
-	expiresAt := time.Now().Add(sessionDuration)
-	if err := s.db.CreateSession(sessionToken, user.ID, expiresAt); err != nil {
-		http.Error(w, "Failed to save session", http.StatusInternalServerError)
-		return
-	}
-
-	http.SetCookie(w, &http.Cookie{
-		Name:     sessionCookieName,
-		Value:    sessionToken,
-		Path:     "/",
-		Expires:  expiresAt,
-		HttpOnly: true,
-		SameSite: http.SameSiteLaxMode,
-	})
-
-	http.Redirect(w, r, redirect, http.StatusTemporaryRedirect)
-}
-
-// handleAuthProviders returns the list of configured OAuth providers
+// handleAuthProviders returns the registered sign in providers and whether
+// email sign in is available
 func (s *Server) handleAuthProviders(w http.ResponseWriter, r *http.Request) {
 	type provider struct {
 		ID   string `json:"id"`
 		Name string `json:"name"`
 	}
 
-	var providers []provider
-
-	if os.Getenv("GOOGLE_CLIENT_ID") != "" {
-		providers = append(providers, provider{ID: "google", Name: "Google"})
-	}
-	if os.Getenv("GITHUB_CLIENT_ID") != "" {
-		providers = append(providers, provider{ID: "github", Name: "GitHub"})
+	providers := make([]provider, 0, len(s.providers))
+	for _, p := range s.providers {
+		providers = append(providers, provider{ID: p.ID(), Name: p.Name()})
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string][]provider{"providers": providers})
+	json.NewEncoder(w).Encode(struct {
+		Providers []provider `json:"providers"`
+		Email     bool       `json:"email"` // Sign in links can be emailed
+	}{providers, s.mailer != nil})
 }
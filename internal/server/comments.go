@@ -9,6 +9,8 @@ import (
 	"strings"
 	"time"
 
+	"site/internal/models"
+
 	"github.com/yuin/goldmark"
 	"github.com/yuin/goldmark/extension"
 	"github.com/yuin/goldmark/parser"
@@ -72,6 +74,9 @@ func (s *Server) handleComment(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// duplicateWindow is how long identical comments from a user are rejected
+const duplicateWindow = 24 * time.Hour
+
 type commentResponse struct {
 	ID         int64  `json:"id"`
 	Content    string `json:"content"`
@@ -81,9 +86,22 @@ type commentResponse struct {
 	UserID     string `json:"userId"`
 	UserName   string `json:"userName"`
 	UserAvatar string `json:"userAvatar"`
+	Status     string `json:"status,omitempty"` // Set for comments awaiting moderation
+	ParentID   int64  `json:"parentId,omitempty"`
+	Depth      int    `json:"depth"`
+	Deleted    bool   `json:"deleted,omitempty"` // Tombstone: content and author are blank
+}
+
+// pendingStatus returns the status to expose to a comment's author: only
+// "pending" is shown, since other statuses are never returned publicly
+func pendingStatus(status string) string {
+	if status == models.CommentPending {
+		return status
+	}
+	return ""
 }
 
-// getComments returns all comments for a post
+// getComments returns the visible comments for a post
 func (s *Server) getComments(w http.ResponseWriter, r *http.Request) {
 	postSlug := r.URL.Query().Get("post")
 	if postSlug == "" {
@@ -91,7 +109,17 @@ func (s *Server) getComments(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	comments, err := s.db.GetComments(postSlug)
+	if _, ok := s.requirePost(w, postSlug); !ok {
+		return
+	}
+
+	// Authors see their own comments while they await moderation
+	viewerID := ""
+	if user := s.getSessionUser(r); user != nil {
+		viewerID = user.ID
+	}
+
+	comments, err := s.db.GetComments(postSlug, viewerID)
 	if err != nil {
 		http.Error(w, "Failed to get comments", http.StatusInternalServerError)
 		return
@@ -99,6 +127,18 @@ func (s *Server) getComments(w http.ResponseWriter, r *http.Request) {
 
 	response := make([]commentResponse, 0, len(comments))
 	for _, c := range comments {
+		if c.Deleted {
+			response = append(response, commentResponse{
+				ID:        c.ID,
+				CreatedAt: c.CreatedAt.Format(time.RFC3339),
+				UpdatedAt: c.CreatedAt.Format(time.RFC3339),
+				ParentID:  c.ParentID,
+				Depth:     c.Depth,
+				Deleted:   true,
+			})
+			continue
+		}
+
 		response = append(response, commentResponse{
 			ID:          c.ID,
 			Content:     c.Content,
@@ -108,6 +148,9 @@ func (s *Server) getComments(w http.ResponseWriter, r *http.Request) {
 			UserID:      c.UserID,
 			UserName:    c.UserName,
 			UserAvatar:  c.UserAvatar,
+			Status:      pendingStatus(c.Status),
+			ParentID:    c.ParentID,
+			Depth:       c.Depth,
 		})
 	}
 
@@ -124,8 +167,9 @@ func (s *Server) createComment(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var req struct {
-		Post    string `json:"post"`
-		Content string `json:"content"`
+		Post     string `json:"post"`
+		Content  string `json:"content"`
+		ParentID int64  `json:"parentId"` // Optional, the comment being replied to
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -146,7 +190,40 @@ func (s *Server) createComment(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	comment, err := s.db.CreateComment(user.ID, req.Post, req.Content)
+	post, ok := s.requirePost(w, req.Post)
+	if !ok {
+		return
+	}
+	if !post.Comments {
+		http.Error(w, "Comments are disabled for this post", http.StatusForbidden)
+		return
+	}
+
+	// Reject the same comment posted again, e.g. by a script or a double submit
+	duplicate, err := s.db.HasRecentComment(user.ID, req.Content, time.Now().Add(-duplicateWindow))
+	if err != nil {
+		http.Error(w, "Failed to create comment", http.StatusInternalServerError)
+		return
+	}
+	if duplicate {
+		http.Error(w, "You already posted this comment", http.StatusConflict)
+		return
+	}
+
+	parentID, depth := int64(0), 0
+	if req.ParentID != 0 {
+		if parentID, depth, ok = s.resolveParent(w, req.Post, req.ParentID); !ok {
+			return
+		}
+	}
+
+	status, err := s.commentStatus(user)
+	if err != nil {
+		http.Error(w, "Failed to create comment", http.StatusInternalServerError)
+		return
+	}
+
+	comment, err := s.db.CreateComment(user.ID, req.Post, parentID, req.Content, status)
 	if err != nil {
 		http.Error(w, "Failed to create comment", http.StatusInternalServerError)
 		return
@@ -163,9 +240,39 @@ func (s *Server) createComment(w http.ResponseWriter, r *http.Request) {
 		UserID:      user.ID,
 		UserName:    user.Name,
 		UserAvatar:  user.AvatarURL,
+		Status:      pendingStatus(comment.Status),
+		ParentID:    comment.ParentID,
+		Depth:       depth,
 	})
 }
 
+// resolveParent checks a reply's parent is a visible comment on the same
+// post and returns the comment to attach the reply to, with the reply's
+// depth. Replies that would nest deeper than models.MaxCommentDepth become
+// siblings of their parent instead. It writes an error and returns false
+// when the parent is invalid.
+func (s *Server) resolveParent(w http.ResponseWriter, postSlug string, parentID int64) (int64, int, bool) {
+	parent, err := s.db.GetComment(parentID)
+	if err != nil {
+		http.Error(w, "Failed to create comment", http.StatusInternalServerError)
+		return 0, 0, false
+	}
+	if parent == nil || parent.PostSlug != postSlug || parent.Deleted || parent.Status != models.CommentApproved {
+		http.Error(w, "Invalid parent comment", http.StatusBadRequest)
+		return 0, 0, false
+	}
+
+	depth, err := s.db.CommentDepth(parent.ID)
+	if err != nil {
+		http.Error(w, "Failed to create comment", http.StatusInternalServerError)
+		return 0, 0, false
+	}
+	if depth >= models.MaxCommentDepth {
+		return parent.ParentID, depth, true
+	}
+	return parent.ID, depth + 1, true
+}
+
 // updateComment updates an existing comment
 func (s *Server) updateComment(w http.ResponseWriter, r *http.Request, id int64) {
 	user := s.getSessionUser(r)
@@ -204,12 +311,25 @@ func (s *Server) updateComment(w http.ResponseWriter, r *http.Request, id int64)
 		return
 	}
 
+	// Under the strictest policy an edit needs approval again
+	if s.config.Comments.Moderation == ModerationAll && !user.IsAdmin() {
+		if err := s.db.SetCommentStatus(id, models.CommentPending); err != nil {
+			http.Error(w, "Failed to update comment", http.StatusInternalServerError)
+			return
+		}
+	}
+
 	// Fetch the updated comment to return
 	comment, err := s.db.GetComment(id)
 	if err != nil || comment == nil {
 		http.Error(w, "Failed to get updated comment", http.StatusInternalServerError)
 		return
 	}
+	depth, err := s.db.CommentDepth(id)
+	if err != nil {
+		http.Error(w, "Failed to get updated comment", http.StatusInternalServerError)
+		return
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(commentResponse{
@@ -221,6 +341,9 @@ func (s *Server) updateComment(w http.ResponseWriter, r *http.Request, id int64)
 		UserID:      user.ID,
 		UserName:    user.Name,
 		UserAvatar:  user.AvatarURL,
+		Status:      pendingStatus(comment.Status),
+		ParentID:    comment.ParentID,
+		Depth:       depth,
 	})
 }
 
@@ -1,5 +1,7 @@
 package server
 
+import "site/internal/auth"
+
 type Config struct {
 	Port        int
 	ContentDir  string
@@ -8,7 +10,68 @@ type Config struct {
 	TemplateDir string
 	DevMode     bool
 	BaseURL     string
+	DBPath      string
 	Profile     ProfileConfig
+	Admins      []string // Emails or provider IDs (e.g. "github:123") of moderators
+	Comments    CommentsConfig
+	RateLimits  RateLimitConfig
+	TrustProxy  bool // Take client IPs from X-Forwarded-For, when behind a reverse proxy
+	Auth        AuthConfig
+	Email       EmailConfig
+	Webmentions WebmentionsConfig
+	ActivityPub ActivityPubConfig
+	Analytics   AnalyticsConfig
+	Newsletter  NewsletterConfig
+}
+
+// NewsletterConfig lets readers subscribe by email to new posts, from the
+// whole site or one series. It needs email to be configured; digests are
+// sent with `site newsletter send`.
+type NewsletterConfig struct {
+	Enabled bool `yaml:"enabled"`
+}
+
+// AnalyticsConfig turns on counting page views. Nothing is counted for
+// browsers that send Do Not Track or Global Privacy Control.
+type AnalyticsConfig struct {
+	Enabled bool `yaml:"enabled"`
+}
+
+// ActivityPubConfig lets fediverse accounts follow the site as
+// @username@host. It is off unless enabled.
+type ActivityPubConfig struct {
+	Enabled  bool   `yaml:"enabled"`
+	Username string `yaml:"username"` // Defaults to "blog"
+	Name     string `yaml:"name"`     // Display name, defaults to the site title
+}
+
+// WebmentionsConfig sets whether webmentions from other sites are held for
+// moderation. Only "none" publishes them as soon as they are verified.
+type WebmentionsConfig struct {
+	Moderation string `yaml:"moderation"`
+}
+
+// EmailConfig sets how email, such as sign in links and newsletters, is
+// sent. Without an SMTP host or a directory, email sign in and the
+// newsletter are off, except in dev mode where messages are printed to the
+// log.
+type EmailConfig struct {
+	From string     `yaml:"from"` // Defaults to noreply@ the base URL's host
+	SMTP SMTPConfig `yaml:"smtp"`
+	Dir  string     `yaml:"dir"` // Write messages to .eml files here instead of sending them
+}
+
+type SMTPConfig struct {
+	Host        string `yaml:"host"`
+	Port        int    `yaml:"port"` // Defaults to 587
+	Username    string `yaml:"username"`
+	PasswordEnv string `yaml:"password_env"` // Environment variable holding the password
+}
+
+// AuthConfig lists sign in providers beyond Google and GitHub, which are
+// enabled by their environment variables
+type AuthConfig struct {
+	OIDC []auth.OIDCConfig `yaml:"oidc"`
 }
 
 type ProfileConfig struct {
@@ -18,3 +81,41 @@ type ProfileConfig struct {
 	LinkedIn string `yaml:"linkedin"`
 	Email    string `yaml:"email"`
 }
+
+// Comment moderation policies
+const (
+	ModerationNone  = "none"          // Publish every comment immediately
+	ModerationFirst = "first-comment" // Hold a user's comments until one is approved
+	ModerationAll   = "all"           // Hold every comment from non-admins
+)
+
+// What happens to a user's comments when they delete their account
+const (
+	AccountDeleteComments    = "delete"    // Remove them, keeping tombstones for replied-to comments
+	AccountAnonymizeComments = "anonymize" // Keep them, attributed to a "Deleted user"
+)
+
+type CommentsConfig struct {
+	Moderation      string `yaml:"moderation"`
+	OnAccountDelete string `yaml:"on_account_delete"`
+}
+
+// RateLimitConfig sets the budgets for each group of API routes. Zero
+// values fall back to the defaults.
+type RateLimitConfig struct {
+	Disabled    bool      `yaml:"disabled"`
+	Comments    RateLimit `yaml:"comments"`
+	Reactions   RateLimit `yaml:"reactions"`
+	Search      RateLimit `yaml:"search"`
+	Email       RateLimit `yaml:"email"`
+	Webmentions RateLimit `yaml:"webmentions"`
+	Feedback    RateLimit `yaml:"feedback"`
+}
+
+// RateLimit is a token bucket budget, per user and per IP address
+type RateLimit struct {
+	PerMinute   float64 `yaml:"per_minute"`
+	Burst       int     `yaml:"burst"`
+	IPPerMinute float64 `yaml:"ip_per_minute"`
+	IPBurst     int     `yaml:"ip_burst"`
+}
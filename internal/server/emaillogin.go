@@ -0,0 +1,224 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	netmail "net/mail"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"site/internal/mail"
+	"site/internal/models"
+)
+
+const (
+	loginLinkDuration = 15 * time.Minute // How long an emailed sign in link works
+	loginLinkMaxSends = 3                // Links sent to one address per loginLinkDuration
+)
+
+// NewMailer picks how email is sent: over SMTP when a host is configured,
+// to files when a directory is, and to the log in dev mode. It returns nil
+// when email is not configured.
+func NewMailer(cfg EmailConfig, devMode bool) mail.Mailer {
+	switch {
+	case cfg.SMTP.Host != "":
+		return &mail.SMTP{
+			Host:     cfg.SMTP.Host,
+			Port:     cfg.SMTP.Port,
+			Username: cfg.SMTP.Username,
+			Password: os.Getenv(cfg.SMTP.PasswordEnv),
+		}
+	case cfg.Dir != "":
+		return &mail.File{Dir: cfg.Dir}
+	case devMode:
+		return mail.Log{}
+	}
+	return nil
+}
+
+// mailFrom returns the sender address for outgoing email
+func (s *Server) mailFrom() string {
+	if s.config.Email.From != "" {
+		return s.config.Email.From
+	}
+	return "noreply@" + s.siteHost()
+}
+
+// siteHost returns the host name of the base URL
+func (s *Server) siteHost() string {
+	if u, err := url.Parse(s.config.BaseURL); err == nil && u.Hostname() != "" {
+		return u.Hostname()
+	}
+	return "localhost"
+}
+
+// normalizeEmail checks s is a bare email address and returns it lowercased
+func normalizeEmail(s string) (string, bool) {
+	s = strings.TrimSpace(s)
+	addr, err := netmail.ParseAddress(s)
+	if err != nil || addr.Address != s || len(s) > 254 {
+		return "", false
+	}
+	return strings.ToLower(s), true
+}
+
+// emailUserID returns the user ID for an email address. It is a hash, so
+// addresses don't appear in public comment data.
+func emailUserID(email string) string {
+	sum := sha256.Sum256([]byte(email))
+	return "email:" + hex.EncodeToString(sum[:10])
+}
+
+// hashToken returns how the token of an emailed link, such as a sign in
+// link, is stored
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// handleEmailLogin emails a single-use sign in link: POST /auth/email
+func (s *Server) handleEmailLogin(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.mailer == nil {
+		http.Error(w, "Email sign in is not enabled", http.StatusNotFound)
+		return
+	}
+
+	var req struct {
+		Email    string `json:"email"`
+		Redirect string `json:"redirect"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	email, ok := normalizeEmail(req.Email)
+	if !ok {
+		http.Error(w, "Invalid email address", http.StatusBadRequest)
+		return
+	}
+
+	sent, err := s.db.CountLoginTokens(email, time.Now().Add(-loginLinkDuration))
+	if err != nil {
+		http.Error(w, "Failed to send sign in link", http.StatusInternalServerError)
+		return
+	}
+	if sent >= loginLinkMaxSends {
+		http.Error(w, "Too many sign in links requested, check your inbox", http.StatusTooManyRequests)
+		return
+	}
+
+	token, err := generateToken(32)
+	if err != nil {
+		http.Error(w, "Failed to send sign in link", http.StatusInternalServerError)
+		return
+	}
+	expiresAt := time.Now().Add(loginLinkDuration)
+	if err := s.db.CreateLoginToken(hashToken(token), email, safeRedirect(req.Redirect), expiresAt); err != nil {
+		http.Error(w, "Failed to send sign in link", http.StatusInternalServerError)
+		return
+	}
+
+	link := s.config.BaseURL + "/auth/email/verify?token=" + url.QueryEscape(token)
+	msg := mail.Message{
+		From:    s.mailFrom(),
+		To:      email,
+		Subject: "Sign in to " + s.siteHost(),
+		Body: fmt.Sprintf("Open this link to sign in to %s:\n\n%s\n\n"+
+			"It works once and expires in %d minutes. If you didn't ask to sign in, you can ignore this email.\n",
+			s.siteHost(), link, int(loginLinkDuration.Minutes())),
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+	if err := s.mailer.Send(ctx, msg); err != nil {
+		log.Printf("Failed to send sign in link: %v", err)
+		http.Error(w, "Failed to send sign in link", http.StatusBadGateway)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]bool{"sent": true})
+}
+
+// verifyPage confirms a sign in link. Signing in takes a POST so link
+// scanners that fetch URLs in emails don't use up the link.
+var verifyPage = template.Must(template.New("verify").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<meta name="robots" content="noindex">
+<title>Sign in</title>
+<style>
+body { font-family: system-ui, sans-serif; max-width: 28rem; margin: 15vh auto; padding: 0 1rem; text-align: center; }
+button { font: inherit; padding: 0.6rem 1.4rem; cursor: pointer; }
+</style>
+</head>
+<body>
+<h1>Sign in</h1>
+{{if .Token}}
+<form method="post" action="/auth/email/verify">
+<input type="hidden" name="token" value="{{.Token}}">
+<button type="submit">Continue</button>
+</form>
+{{else}}
+<p>This sign in link is invalid, has expired or was already used. Request a new one from the site.</p>
+<p><a href="/">Back to the site</a></p>
+{{end}}
+</body>
+</html>
+`))
+
+// handleEmailVerify shows the sign in confirmation for a link (GET) and
+// signs the user in (POST): /auth/email/verify
+func (s *Server) handleEmailVerify(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Referrer-Policy", "no-referrer")
+
+	switch r.Method {
+	case http.MethodGet:
+		s.renderVerifyPage(w, r.URL.Query().Get("token"), http.StatusOK)
+	case http.MethodPost:
+		email, redirect, err := s.db.ConsumeLoginToken(hashToken(r.PostFormValue("token")))
+		if err != nil {
+			http.Error(w, "Failed to sign in", http.StatusInternalServerError)
+			return
+		}
+		if email == "" {
+			s.renderVerifyPage(w, "", http.StatusBadRequest)
+			return
+		}
+
+		name, _, _ := strings.Cut(email, "@")
+		user := &models.User{
+			ID:            emailUserID(email),
+			Email:         email,
+			EmailVerified: true, // The link was sent to it
+			Name:          name,
+			CreatedAt:     time.Now(),
+		}
+		s.signIn(w, r, user, safeRedirect(redirect), http.StatusSeeOther)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) renderVerifyPage(w http.ResponseWriter, token string, status int) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	verifyPage.Execute(w, struct{ Token string }{token})
+}
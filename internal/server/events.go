@@ -0,0 +1,193 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"site/internal/db"
+	"site/internal/models"
+)
+
+const (
+	eventHeartbeat      = 25 * time.Second
+	eventBuffer         = 16 // Events queued per stream before it is dropped as too slow
+	maxStreamsPerClient = 8
+)
+
+// eventClient is one open event stream for a post
+type eventClient struct {
+	post   string
+	userID string // Logged-in viewer, who also gets events for their pending comments
+	addr   string // Remote IP, for the per-client connection cap
+	events chan []byte
+}
+
+// handleEvents streams live comment and reaction changes for a post as
+// Server-Sent Events: GET /api/events?post=blog/welcome
+func (s *Server) handleEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	postSlug := r.URL.Query().Get("post")
+	if postSlug == "" {
+		http.Error(w, "Missing post parameter", http.StatusBadRequest)
+		return
+	}
+	if _, ok := s.requirePost(w, postSlug); !ok {
+		return
+	}
+
+	client := &eventClient{
+		post:   postSlug,
+		addr:   s.clientIP(r),
+		events: make(chan []byte, eventBuffer),
+	}
+	if user := s.getSessionUser(r); user != nil {
+		client.userID = user.ID
+	}
+
+	if !s.addEventClient(client) {
+		http.Error(w, "Too many connections", http.StatusTooManyRequests)
+		return
+	}
+	defer s.removeEventClient(client)
+
+	// Streams outlive the server's write timeout
+	rc := http.NewResponseController(w)
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no") // Disable proxy buffering
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, "retry: 5000\n\n")
+	if err := rc.Flush(); err != nil {
+		return
+	}
+
+	heartbeat := time.NewTicker(eventHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case event, ok := <-client.events:
+			if !ok {
+				// Dropped as too slow, or the server is shutting down
+				return
+			}
+			if _, err := w.Write(event); err != nil {
+				return
+			}
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+				return
+			}
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// addEventClient registers a stream unless its client already has too many
+func (s *Server) addEventClient(client *eventClient) bool {
+	s.eventLock.Lock()
+	defer s.eventLock.Unlock()
+
+	open := 0
+	for c := range s.eventClients {
+		if c.addr == client.addr {
+			open++
+		}
+	}
+	if open >= maxStreamsPerClient {
+		return false
+	}
+
+	s.eventClients[client] = true
+	return true
+}
+
+func (s *Server) removeEventClient(client *eventClient) {
+	s.eventLock.Lock()
+	defer s.eventLock.Unlock()
+
+	if s.eventClients[client] {
+		delete(s.eventClients, client)
+		close(client.events)
+	}
+}
+
+// closeEventClients ends every stream, so shutdown doesn't wait on them
+func (s *Server) closeEventClients() {
+	s.eventLock.Lock()
+	defer s.eventLock.Unlock()
+
+	for client := range s.eventClients {
+		delete(s.eventClients, client)
+		close(client.events)
+	}
+}
+
+// broadcastChange turns a database change into an event for the post's
+// streams. Comment events only carry the ID, and clients refetch through
+// the comments API so moderation and threading rules apply per viewer.
+func (s *Server) broadcastChange(change db.Change) {
+	var payload any
+	switch change.Kind {
+	case db.ChangeReactions:
+		counts, err := s.db.GetReactionCounts(change.PostSlug)
+		if err != nil {
+			log.Printf("Failed to get reactions for event: %v", err)
+			return
+		}
+		if counts == nil {
+			counts = []models.ReactionCount{}
+		}
+		payload = counts
+	case db.ChangeWebmentions:
+		// Clients refetch the approved list, which a pending one isn't in
+		if change.Status == models.CommentPending {
+			return
+		}
+		payload = struct{}{}
+	default:
+		payload = map[string]int64{"id": change.CommentID}
+	}
+
+	data, err := json.Marshal(payload)
+	if err != nil {
+		log.Printf("Failed to encode event: %v", err)
+		return
+	}
+	event := []byte(fmt.Sprintf("event: %s\ndata: %s\n\n", change.Kind, data))
+
+	// Comments awaiting moderation are only visible to their author
+	private := change.Status == models.CommentPending
+
+	s.eventLock.Lock()
+	defer s.eventLock.Unlock()
+
+	for client := range s.eventClients {
+		if client.post != change.PostSlug || (private && client.userID != change.UserID) {
+			continue
+		}
+		select {
+		case client.events <- event:
+		default:
+			// Drop streams that stopped reading; EventSource reconnects
+			delete(s.eventClients, client)
+			close(client.events)
+		}
+	}
+}
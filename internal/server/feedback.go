@@ -0,0 +1,165 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"log"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"site/internal/models"
+)
+
+const (
+	maxFeedbackReason     = 500 // Characters
+	defaultFeedbackDays   = 90
+	feedbackReasonsOnPage = 5 // Latest reasons listed per page in the report
+)
+
+type feedbackResponse struct {
+	Helpful bool   `json:"helpful"`
+	Reason  string `json:"reason"`
+}
+
+// handleFeedback handles "was this page helpful?" votes on docs pages:
+// GET /api/feedback?post= returns the reader's vote, POST casts or
+// changes it
+func (s *Server) handleFeedback(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		s.getFeedback(w, r)
+	case http.MethodPost:
+		s.postFeedback(w, r)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// getFeedback returns the reader's vote on a page, or null
+func (s *Server) getFeedback(w http.ResponseWriter, r *http.Request) {
+	postSlug := r.URL.Query().Get("post")
+	if postSlug == "" {
+		http.Error(w, "Missing post parameter", http.StatusBadRequest)
+		return
+	}
+	if _, ok := s.requirePost(w, postSlug); !ok {
+		return
+	}
+
+	f, err := s.db.GetFeedback(postSlug, s.feedbackVoter(r, postSlug))
+	if err != nil {
+		http.Error(w, "Failed to get feedback", http.StatusInternalServerError)
+		return
+	}
+
+	var response *feedbackResponse
+	if f != nil {
+		response = &feedbackResponse{Helpful: f.Helpful, Reason: f.Reason}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// postFeedback records a vote. Readers don't need to sign in; voting
+// again on a page replaces their vote.
+func (s *Server) postFeedback(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Post    string `json:"post"`
+		Helpful *bool  `json:"helpful"`
+		Reason  string `json:"reason"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.Post == "" || req.Helpful == nil {
+		http.Error(w, "Missing post or helpful", http.StatusBadRequest)
+		return
+	}
+
+	reason := strings.TrimSpace(req.Reason)
+	if utf8.RuneCountInString(reason) > maxFeedbackReason {
+		http.Error(w, "Reason is too long (max 500 characters)", http.StatusBadRequest)
+		return
+	}
+
+	post, ok := s.requirePost(w, req.Post)
+	if !ok {
+		return
+	}
+	if !post.Docs {
+		http.Error(w, "Feedback is only collected on docs pages", http.StatusForbidden)
+		return
+	}
+
+	f := &models.Feedback{
+		PostSlug:   req.Post,
+		Collection: path.Dir(req.Post),
+		Voter:      s.feedbackVoter(r, req.Post),
+		Helpful:    *req.Helpful,
+		Reason:     reason,
+	}
+	if user := s.getSessionUser(r); user != nil {
+		f.UserID = user.ID
+	}
+
+	if err := s.db.RecordFeedback(f); err != nil {
+		log.Printf("Failed to record feedback: %v", err)
+		http.Error(w, "Failed to record feedback", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// feedbackVoter identifies who votes on a page: the signed in user, or
+// for anonymous readers a keyed hash of their address and browser. The
+// page is part of the hash, so anonymous votes can't be linked across
+// pages.
+func (s *Server) feedbackVoter(r *http.Request, postSlug string) string {
+	if user := s.getSessionUser(r); user != nil {
+		return "user:" + user.ID
+	}
+	mac := hmac.New(sha256.New, s.hashKey)
+	mac.Write([]byte("feedback:" + postSlug + "\n" + s.clientIP(r) + "\n" + r.UserAgent()))
+	return "anon:" + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))[:16]
+}
+
+// handleAdminFeedback reports votes by docs collection and page, the
+// pages most in need of rewriting first:
+// GET /api/admin/feedback?days=90&collection=docs
+func (s *Server) handleAdminFeedback(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.requireAdmin(w, r) == nil {
+		return
+	}
+
+	days := defaultFeedbackDays
+	if v := r.URL.Query().Get("days"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			http.Error(w, "Invalid days", http.StatusBadRequest)
+			return
+		}
+		days = n
+	}
+
+	since := time.Now().AddDate(0, 0, -days)
+	report, err := s.db.FeedbackReport(since, r.URL.Query().Get("collection"), feedbackReasonsOnPage)
+	if err != nil {
+		log.Printf("Failed to read feedback: %v", err)
+		http.Error(w, "Failed to read feedback", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
@@ -116,9 +116,14 @@ func (s *Server) rebuild() error {
 		OutputDir:   s.config.OutputDir,
 		StaticDir:   s.config.StaticDir,
 		TemplateDir: s.config.TemplateDir,
+		CacheDir:    build.DefaultCacheDir,
 		BaseURL:     s.config.BaseURL,
 		DevMode:     s.config.DevMode,
 		DB:          s.db,
 	}
-	return build.Build(cfg)
+	if err := build.Build(cfg); err != nil {
+		return err
+	}
+	s.loadPosts()
+	return nil
 }
@@ -0,0 +1,259 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"site/internal/mail"
+)
+
+const (
+	newsletterConfirmDuration = 48 * time.Hour   // How long a confirmation link works
+	newsletterResendAfter     = 10 * time.Minute // Between confirmation emails to one address
+)
+
+// newsletterEnabled reports whether readers can subscribe
+func (s *Server) newsletterEnabled() bool {
+	return s.config.Newsletter.Enabled && s.mailer != nil
+}
+
+// seriesExists reports whether a collection slug names a series with
+// published posts, directly or in a nested series
+func (s *Server) seriesExists(slug string) bool {
+	s.postsLock.RLock()
+	defer s.postsLock.RUnlock()
+	if s.posts == nil {
+		return false
+	}
+	for id, post := range s.posts.Posts {
+		if dir := path.Dir(id); post.Series && (dir == slug || strings.HasPrefix(dir, slug+"/")) {
+			return true
+		}
+	}
+	return false
+}
+
+// handleNewsletter handles newsletter sign ups: GET /api/newsletter
+// reports whether they are open, POST subscribes an address and emails it
+// a confirmation link
+func (s *Server) handleNewsletter(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]bool{"enabled": s.newsletterEnabled()})
+	case http.MethodPost:
+		s.subscribeNewsletter(w, r)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// subscribeNewsletter stores an unconfirmed subscription and sends the
+// confirmation link. The response is the same whether or not the address
+// was already subscribed, so it can't be used to find out who is.
+func (s *Server) subscribeNewsletter(w http.ResponseWriter, r *http.Request) {
+	if !s.newsletterEnabled() {
+		http.Error(w, "Newsletter is not enabled", http.StatusNotFound)
+		return
+	}
+
+	var req struct {
+		Email  string `json:"email"`
+		Series string `json:"series"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	email, ok := normalizeEmail(req.Email)
+	if !ok {
+		http.Error(w, "Invalid email address", http.StatusBadRequest)
+		return
+	}
+	series := strings.Trim(req.Series, "/")
+	if series != "" && !s.seriesExists(series) {
+		http.Error(w, "Series not found", http.StatusNotFound)
+		return
+	}
+
+	if err := s.db.DeleteUnconfirmedSubscribers(time.Now().Add(-newsletterConfirmDuration)); err != nil {
+		log.Printf("Failed to delete unconfirmed subscribers: %v", err)
+	}
+
+	sub, err := s.db.GetSubscriber(email, series)
+	if err != nil {
+		http.Error(w, "Failed to subscribe", http.StatusInternalServerError)
+		return
+	}
+	if sub != nil && (sub.Confirmed || time.Since(sub.ConfirmSentAt) < newsletterResendAfter) {
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+
+	token, err := generateToken(32)
+	if err != nil {
+		http.Error(w, "Failed to subscribe", http.StatusInternalServerError)
+		return
+	}
+	unsubscribeToken, err := generateToken(32)
+	if err != nil {
+		http.Error(w, "Failed to subscribe", http.StatusInternalServerError)
+		return
+	}
+	if err := s.db.SubscribeNewsletter(email, series, hashToken(token), unsubscribeToken); err != nil {
+		http.Error(w, "Failed to subscribe", http.StatusInternalServerError)
+		return
+	}
+
+	what := "new posts from " + s.siteHost()
+	if series != "" {
+		what = fmt.Sprintf("new posts in %s from %s", series, s.siteHost())
+	}
+	link := s.config.BaseURL + "/newsletter/confirm?token=" + url.QueryEscape(token)
+	msg := mail.Message{
+		From:    s.mailFrom(),
+		To:      email,
+		Subject: "Confirm your subscription to " + s.siteHost(),
+		Body: fmt.Sprintf("Open this link to get %s by email:\n\n%s\n\n"+
+			"It expires in %d hours. If you didn't subscribe, ignore this email and you won't hear from us again.\n",
+			what, link, int(newsletterConfirmDuration.Hours())),
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+	if err := s.mailer.Send(ctx, msg); err != nil {
+		log.Printf("Failed to send newsletter confirmation: %v", err)
+		http.Error(w, "Failed to send confirmation email", http.StatusBadGateway)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// newsletterPage asks readers who open a confirmation or unsubscribe link
+// to press a button, so link scanners that fetch URLs in emails don't
+// confirm or cancel subscriptions
+var newsletterPage = template.Must(template.New("newsletter").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<meta name="robots" content="noindex">
+<title>{{.Title}}</title>
+<style>
+body { font-family: system-ui, sans-serif; max-width: 28rem; margin: 15vh auto; padding: 0 1rem; text-align: center; }
+button { font: inherit; padding: 0.6rem 1.4rem; cursor: pointer; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+<p>{{.Message}}</p>
+{{if .Token}}
+<form method="post" action="{{.Action}}">
+<input type="hidden" name="token" value="{{.Token}}">
+<button type="submit">{{.Button}}</button>
+</form>
+{{else}}
+<p><a href="/">Back to the site</a></p>
+{{end}}
+</body>
+</html>
+`))
+
+type newsletterPageData struct {
+	Title   string
+	Message string
+	Action  string
+	Token   string
+	Button  string
+}
+
+func renderNewsletterPage(w http.ResponseWriter, data newsletterPageData, status int) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	newsletterPage.Execute(w, data)
+}
+
+// handleNewsletterConfirm shows the confirmation for a link (GET) and
+// confirms the subscription (POST): /newsletter/confirm
+func (s *Server) handleNewsletterConfirm(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Referrer-Policy", "no-referrer")
+
+	switch r.Method {
+	case http.MethodGet:
+		renderNewsletterPage(w, newsletterPageData{
+			Title:   "Confirm your subscription",
+			Message: "You'll get an email when new posts are published.",
+			Action:  "/newsletter/confirm",
+			Token:   r.URL.Query().Get("token"),
+			Button:  "Subscribe",
+		}, http.StatusOK)
+	case http.MethodPost:
+		sub, err := s.db.ConfirmSubscriber(hashToken(r.PostFormValue("token")), time.Now().Add(-newsletterConfirmDuration))
+		if err != nil {
+			http.Error(w, "Failed to confirm subscription", http.StatusInternalServerError)
+			return
+		}
+		if sub == nil {
+			renderNewsletterPage(w, newsletterPageData{
+				Title:   "Confirm your subscription",
+				Message: "This link is invalid, has expired or was already used. Subscribe again from the site.",
+			}, http.StatusBadRequest)
+			return
+		}
+		renderNewsletterPage(w, newsletterPageData{
+			Title:   "You're subscribed",
+			Message: "Every email has a link to unsubscribe.",
+		}, http.StatusOK)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleNewsletterUnsubscribe shows the unsubscribe button for a link
+// (GET) and deletes the subscription (POST): /newsletter/unsubscribe.
+// Mail clients that offer one-click unsubscribe (RFC 8058) POST to the
+// link itself, with the token in the query string.
+func (s *Server) handleNewsletterUnsubscribe(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Referrer-Policy", "no-referrer")
+
+	switch r.Method {
+	case http.MethodGet:
+		renderNewsletterPage(w, newsletterPageData{
+			Title:   "Unsubscribe",
+			Message: "You won't get any more emails about new posts.",
+			Action:  "/newsletter/unsubscribe",
+			Token:   r.URL.Query().Get("token"),
+			Button:  "Unsubscribe",
+		}, http.StatusOK)
+	case http.MethodPost:
+		sub, err := s.db.Unsubscribe(r.FormValue("token"))
+		if err != nil {
+			http.Error(w, "Failed to unsubscribe", http.StatusInternalServerError)
+			return
+		}
+		if sub == nil {
+			renderNewsletterPage(w, newsletterPageData{
+				Title:   "Unsubscribe",
+				Message: "This link is invalid or you have already unsubscribed.",
+			}, http.StatusNotFound)
+			return
+		}
+		renderNewsletterPage(w, newsletterPageData{
+			Title:   "You're unsubscribed",
+			Message: "Your address has been deleted.",
+		}, http.StatusOK)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,144 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	stateCookieName = "oauth_state"
+	stateDuration   = 10 * time.Minute
+)
+
+// oauthState is the payload signed into the OAuth state parameter
+type oauthState struct {
+	Nonce    string `json:"n"`
+	Redirect string `json:"r"`
+	Expires  int64  `json:"e"`
+}
+
+// loadStateKey returns the key used to sign OAuth state. AUTH_SECRET keeps
+// it stable across restarts; otherwise a random key is generated, which
+// only invalidates logins in progress when the server restarts.
+func loadStateKey() []byte {
+	if secret := os.Getenv("AUTH_SECRET"); secret != "" {
+		return []byte(secret)
+	}
+
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		log.Fatalf("Failed to generate OAuth state key: %v", err)
+	}
+	return key
+}
+
+// newOAuthState creates a signed state for an OAuth redirect and binds it
+// to the browser with a nonce cookie
+func (s *Server) newOAuthState(w http.ResponseWriter, redirect string) (string, error) {
+	nonce, err := generateToken(16)
+	if err != nil {
+		return "", err
+	}
+
+	payload, err := json.Marshal(oauthState{
+		Nonce:    nonce,
+		Redirect: safeRedirect(redirect),
+		Expires:  time.Now().Add(stateDuration).Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     stateCookieName,
+		Value:    nonce,
+		Path:     "/auth/",
+		MaxAge:   int(stateDuration.Seconds()),
+		HttpOnly: true,
+		Secure:   s.secureCookies(),
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	return encoded + "." + s.signState(encoded), nil
+}
+
+// consumeOAuthState validates the state returned to an OAuth callback and
+// returns the redirect it carries. Each state is accepted once.
+func (s *Server) consumeOAuthState(w http.ResponseWriter, r *http.Request) (string, error) {
+	// The nonce cookie is single use whatever the outcome
+	http.SetCookie(w, &http.Cookie{
+		Name:     stateCookieName,
+		Value:    "",
+		Path:     "/auth/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   s.secureCookies(),
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	encoded, sig, ok := strings.Cut(r.URL.Query().Get("state"), ".")
+	if !ok || !hmac.Equal([]byte(sig), []byte(s.signState(encoded))) {
+		return "", errors.New("invalid state signature")
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", errors.New("malformed state")
+	}
+	var state oauthState
+	if err := json.Unmarshal(payload, &state); err != nil {
+		return "", errors.New("malformed state")
+	}
+
+	if time.Now().Unix() > state.Expires {
+		return "", errors.New("state expired")
+	}
+
+	cookie, err := r.Cookie(stateCookieName)
+	if err != nil || !hmac.Equal([]byte(cookie.Value), []byte(state.Nonce)) {
+		return "", errors.New("state does not match this browser")
+	}
+
+	if !s.markStateUsed(state.Nonce, time.Unix(state.Expires, 0)) {
+		return "", errors.New("state already used")
+	}
+
+	return safeRedirect(state.Redirect), nil
+}
+
+// signState returns the HMAC of an encoded state payload
+func (s *Server) signState(encoded string) string {
+	mac := hmac.New(sha256.New, s.stateKey)
+	mac.Write([]byte(encoded))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// markStateUsed records a nonce until it expires and reports whether it
+// had not been used before
+func (s *Server) markStateUsed(nonce string, expires time.Time) bool {
+	s.stateLock.Lock()
+	defer s.stateLock.Unlock()
+
+	now := time.Now()
+	for n, exp := range s.usedStates {
+		if now.After(exp) {
+			delete(s.usedStates, n)
+		}
+	}
+
+	if _, used := s.usedStates[nonce]; used {
+		return false
+	}
+	s.usedStates[nonce] = expires
+	return true
+}
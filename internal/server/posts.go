@@ -0,0 +1,75 @@
+package server
+
+import (
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"site/internal/build/manifest"
+)
+
+// manifestPollInterval is how often serve mode checks for a new build
+const manifestPollInterval = 30 * time.Second
+
+// loadPosts reloads the post manifest written by the last build. On
+// failure the previous manifest is kept.
+func (s *Server) loadPosts() {
+	m, err := manifest.Load(filepath.Join(s.config.OutputDir, manifest.File))
+	if err != nil {
+		log.Printf("Failed to load post manifest (rebuild the site to enable comments and reactions): %v", err)
+		return
+	}
+
+	s.postsLock.Lock()
+	s.posts = m
+	s.postsLock.Unlock()
+
+	s.publishNewPosts()
+}
+
+// watchManifest reloads the post manifest whenever a build rewrites it,
+// until stop is closed
+func (s *Server) watchManifest(stop <-chan struct{}) {
+	path := filepath.Join(s.config.OutputDir, manifest.File)
+	var modTime time.Time
+	if info, err := os.Stat(path); err == nil {
+		modTime = info.ModTime()
+	}
+
+	ticker := time.NewTicker(manifestPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		}
+
+		info, err := os.Stat(path)
+		if err != nil || info.ModTime().Equal(modTime) {
+			continue
+		}
+		modTime = info.ModTime()
+		log.Println("Post manifest changed, reloading")
+		s.loadPosts()
+	}
+}
+
+// lookupPost returns the manifest entry for a post ID
+func (s *Server) lookupPost(id string) (manifest.Post, bool) {
+	s.postsLock.RLock()
+	defer s.postsLock.RUnlock()
+	return s.posts.Post(id)
+}
+
+// requirePost writes a 404 and returns false if the post does not exist
+func (s *Server) requirePost(w http.ResponseWriter, id string) (manifest.Post, bool) {
+	post, ok := s.lookupPost(id)
+	if !ok {
+		http.Error(w, "Post not found", http.StatusNotFound)
+	}
+	return post, ok
+}
@@ -0,0 +1,202 @@
+package server
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Route groups with their own rate limit budgets
+const (
+	limitComments    = "comments"
+	limitReactions   = "reactions"
+	limitSearch      = "search"
+	limitEmail       = "email" // Sign in link and newsletter requests
+	limitWebmentions = "webmentions"
+	limitFeedback    = "feedback" // Docs page votes
+)
+
+// Budgets used when site.yml doesn't set one
+var defaultRateLimits = map[string]RateLimit{
+	limitComments:    {PerMinute: 6, Burst: 5, IPPerMinute: 20, IPBurst: 15},
+	limitReactions:   {PerMinute: 30, Burst: 20, IPPerMinute: 90, IPBurst: 60},
+	limitSearch:      {PerMinute: 60, Burst: 30, IPPerMinute: 120, IPBurst: 60},
+	limitEmail:       {PerMinute: 2, Burst: 3, IPPerMinute: 4, IPBurst: 5},
+	limitWebmentions: {PerMinute: 6, Burst: 5, IPPerMinute: 10, IPBurst: 10},
+	limitFeedback:    {PerMinute: 10, Burst: 5, IPPerMinute: 20, IPBurst: 10},
+}
+
+// limiterSweep is how often idle buckets are forgotten
+const limiterSweep = 5 * time.Minute
+
+// bucket is a token bucket, refilled continuously up to its burst size
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// rateLimiter holds a token bucket per key, all with the same budget
+type rateLimiter struct {
+	rate    float64 // Tokens per second
+	burst   float64
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	swept   time.Time
+}
+
+func newRateLimiter(perMinute float64, burst int) *rateLimiter {
+	return &rateLimiter{
+		rate:    perMinute / 60,
+		burst:   float64(burst),
+		buckets: make(map[string]*bucket),
+		swept:   time.Now(),
+	}
+}
+
+// reserve takes a token from key's bucket. If the bucket is empty it takes
+// nothing and returns how long until a token is available.
+func (l *rateLimiter) reserve(key string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Sub(l.swept) > limiterSweep {
+		l.sweep(now)
+	}
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+
+	if b.tokens < 1 {
+		wait := time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+		return false, wait
+	}
+	b.tokens--
+	return true, 0
+}
+
+// refund returns a token taken by reserve
+func (l *rateLimiter) refund(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if b, ok := l.buckets[key]; ok {
+		b.tokens = math.Min(l.burst, b.tokens+1)
+	}
+}
+
+// sweep forgets buckets that have refilled, since a new bucket starts full
+func (l *rateLimiter) sweep(now time.Time) {
+	for key, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, key)
+		}
+	}
+	l.swept = now
+}
+
+// routeLimiter limits a route group per user and per IP
+type routeLimiter struct {
+	user *rateLimiter
+	ip   *rateLimiter
+}
+
+// newRouteLimiters builds a limiter for every route group, filling in
+// defaults for budgets site.yml leaves unset
+func newRouteLimiters(cfg RateLimitConfig) map[string]*routeLimiter {
+	if cfg.Disabled {
+		return nil
+	}
+
+	configured := map[string]RateLimit{
+		limitComments:    cfg.Comments,
+		limitReactions:   cfg.Reactions,
+		limitSearch:      cfg.Search,
+		limitEmail:       cfg.Email,
+		limitWebmentions: cfg.Webmentions,
+		limitFeedback:    cfg.Feedback,
+	}
+
+	limiters := make(map[string]*routeLimiter)
+	for group, def := range defaultRateLimits {
+		limit := configured[group]
+		if limit.PerMinute <= 0 {
+			limit.PerMinute = def.PerMinute
+		}
+		if limit.Burst <= 0 {
+			limit.Burst = def.Burst
+		}
+		if limit.IPPerMinute <= 0 {
+			limit.IPPerMinute = def.IPPerMinute
+		}
+		if limit.IPBurst <= 0 {
+			limit.IPBurst = def.IPBurst
+		}
+		limiters[group] = &routeLimiter{
+			user: newRateLimiter(limit.PerMinute, limit.Burst),
+			ip:   newRateLimiter(limit.IPPerMinute, limit.IPBurst),
+		}
+	}
+	return limiters
+}
+
+// rateLimit throttles a route group. Reads are only limited for search;
+// for comments and reactions only writes spend the budget. Requests must
+// fit both the IP's and, when logged in, the user's budget, otherwise they
+// get a 429 with Retry-After.
+func (s *Server) rateLimit(group string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		limiter := s.limiters[group]
+		if limiter == nil || (group != limitSearch && isSafeMethod(r.Method)) {
+			next(w, r)
+			return
+		}
+
+		now := time.Now()
+		ip := s.clientIP(r)
+		ok, wait := limiter.ip.reserve(ip, now)
+		if ok {
+			if user := s.getSessionUser(r); user != nil {
+				if ok, wait = limiter.user.reserve(user.ID, now); !ok {
+					// Don't charge the IP for a request that isn't served
+					limiter.ip.refund(ip)
+				}
+			}
+		}
+
+		if !ok {
+			seconds := int(math.Ceil(wait.Seconds()))
+			w.Header().Set("Retry-After", strconv.Itoa(max(seconds, 1)))
+			http.Error(w, "Too many requests", http.StatusTooManyRequests)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// clientIP returns the IP address of the client. Behind a reverse proxy
+// (trust_proxy in site.yml) that is the last address the proxy appended to
+// X-Forwarded-For, since earlier entries are whatever the client sent.
+func (s *Server) clientIP(r *http.Request) string {
+	if s.config.TrustProxy {
+		if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+			parts := strings.Split(forwarded, ",")
+			return strings.TrimSpace(parts[len(parts)-1])
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -27,6 +27,10 @@ func (s *Server) getReactions(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if _, ok := s.requirePost(w, postSlug); !ok {
+		return
+	}
+
 	counts, err := s.db.GetReactionCounts(postSlug)
 	if err != nil {
 		http.Error(w, "Failed to get reactions", http.StatusInternalServerError)
@@ -69,6 +73,15 @@ func (s *Server) postReaction(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	post, ok := s.requirePost(w, req.Post)
+	if !ok {
+		return
+	}
+	if !post.Reactions {
+		http.Error(w, "Reactions are disabled for this post", http.StatusForbidden)
+		return
+	}
+
 	added, err := s.db.AddReaction(user.ID, req.Post, req.Emoji)
 	if err != nil {
 		http.Error(w, "Failed to toggle reaction", http.StatusInternalServerError)
@@ -98,6 +111,10 @@ func (s *Server) handleUserReactions(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if _, ok := s.requirePost(w, postSlug); !ok {
+		return
+	}
+
 	emojis, err := s.db.GetUserReactions(user.ID, postSlug)
 	if err != nil {
 		http.Error(w, "Failed to get user reactions", http.StatusInternalServerError)
@@ -112,13 +129,20 @@ func (s *Server) handleUserReactions(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(emojis)
 }
 
-// handleMe returns the current user's info
+// handleMe handles GET and DELETE for the current user
 func (s *Server) handleMe(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
+	switch r.Method {
+	case http.MethodGet:
+		s.getMe(w, r)
+	case http.MethodDelete:
+		s.deleteAccount(w, r)
+	default:
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
 	}
+}
 
+// getMe returns the current user's info
+func (s *Server) getMe(w http.ResponseWriter, r *http.Request) {
 	user := s.getSessionUser(r)
 	if user == nil {
 		http.Error(w, "Unauthorized", http.StatusUnauthorized)
@@ -131,5 +155,6 @@ func (s *Server) handleMe(w http.ResponseWriter, r *http.Request) {
 		"email":  user.Email,
 		"name":   user.Name,
 		"avatar": user.AvatarURL,
+		"role":   user.Role,
 	})
 }
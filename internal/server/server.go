@@ -11,8 +11,12 @@ import (
 	"syscall"
 	"time"
 
+	"site/internal/auth"
+	"site/internal/build/manifest"
 	"site/internal/db"
+	"site/internal/mail"
 	"site/internal/models"
+	"site/internal/webmention"
 
 	"github.com/gorilla/websocket"
 )
@@ -30,12 +34,37 @@ type Server struct {
 	wsLock    sync.Mutex
 	upgrader  websocket.Upgrader
 	devUser   *models.User
+	posts     *manifest.Manifest // Published posts, from the last build
+	postsLock sync.RWMutex
+
+	eventClients map[*eventClient]bool // Open live update streams
+	eventLock    sync.Mutex
+
+	limiters map[string]*routeLimiter // Rate limits by route group, nil when disabled
+
+	providers []auth.Provider // Sign in providers, in the order they are offered
+	mailer    mail.Mailer     // Sends sign in links and newsletter confirmations, nil when email is not configured
+
+	remoteClient *http.Client // Fetches webmention sources and fediverse actors
+	webmentions  chan int64   // IDs of received webmentions awaiting verification
+	ap           *activityPub // Nil unless ActivityPub is enabled
+	pageViews    chan pageHit // Views awaiting their visitor hash, nil unless analytics are enabled
+
+	stateKey   []byte               // Signs OAuth state
+	hashKey    []byte               // Keys the IP hashes of sessions and anonymous feedback votes
+	usedStates map[string]time.Time // OAuth state nonces already redeemed
+	stateLock  sync.Mutex
 }
 
 func Run(cfg Config) error {
 	s := &Server{
-		config:    cfg,
-		wsClients: make(map[*websocket.Conn]bool),
+		config:       cfg,
+		wsClients:    make(map[*websocket.Conn]bool),
+		eventClients: make(map[*eventClient]bool),
+		limiters:     newRouteLimiters(cfg.RateLimits),
+		stateKey:     loadStateKey(),
+		usedStates:   make(map[string]time.Time),
+		webmentions:  make(chan int64, webmentionQueueSize),
 		upgrader: websocket.Upgrader{
 			CheckOrigin: func(r *http.Request) bool {
 				return true
@@ -43,12 +72,63 @@ func Run(cfg Config) error {
 		},
 	}
 
-	database, err := db.New("data/sqlite.db")
+	if cfg.DBPath == "" {
+		cfg.DBPath = db.DefaultPath
+	}
+	database, err := db.New(cfg.DBPath)
 	if err != nil {
 		return fmt.Errorf("failed to initialize database: %w", err)
 	}
 	s.db = database
 	defer s.db.Close()
+	s.db.OnChange(s.broadcastChange)
+
+	if s.hashKey, err = s.loadHashKey(); err != nil {
+		return fmt.Errorf("failed to load hash key: %w", err)
+	}
+
+	s.loadAuthProviders()
+	s.mailer = NewMailer(cfg.Email, cfg.DevMode)
+	if cfg.Newsletter.Enabled && s.mailer == nil {
+		log.Println("Newsletter disabled: email is not configured")
+	}
+
+	// Background workers run until the server exits. Those holding
+	// unsaved data are waited for before the database closes.
+	var workers sync.WaitGroup
+	stopWorkers := make(chan struct{})
+	defer workers.Wait()
+	defer close(stopWorkers)
+	go s.cleanSessions(stopWorkers)
+
+	if cfg.Analytics.Enabled {
+		s.pageViews = make(chan pageHit, analyticsQueueSize)
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			s.recordPageViews(stopWorkers)
+		}()
+	}
+
+	// Webmention sources and fediverse servers must be on public addresses,
+	// except in dev mode where they are usually local test servers
+	s.remoteClient = webmention.PublicClient(webmentionVerifyTimeout)
+	if cfg.DevMode {
+		s.remoteClient = &http.Client{Timeout: webmentionVerifyTimeout}
+	}
+	go s.verifyWebmentions(stopWorkers)
+	s.requeueWebmentions()
+
+	if cfg.ActivityPub.Enabled {
+		if s.ap, err = s.newActivityPub(); err != nil {
+			log.Printf("ActivityPub disabled: %v", err)
+		}
+	}
+
+	// Roles follow the admins list in site.yml
+	if err := s.db.SyncAdmins(cfg.Admins); err != nil {
+		log.Printf("Failed to sync admin roles: %v", err)
+	}
 
 	if cfg.DevMode {
 		// Create ephemeral dev user
@@ -57,6 +137,7 @@ func Run(cfg Config) error {
 			Email:     devUserEmail,
 			Name:      devUserName,
 			AvatarURL: "https://avatar.vercel.sh/dev-user.svg?text=MB",
+			Role:      models.RoleAdmin,
 			CreatedAt: time.Now(),
 		}
 		if err := s.db.CreateOrUpdateUser(s.devUser); err != nil {
@@ -74,6 +155,13 @@ func Run(cfg Config) error {
 		}
 	}
 
+	if !cfg.DevMode {
+		// In dev mode rebuild loads the manifest; here builds run
+		// separately, so pick up their manifests as they land
+		s.loadPosts()
+		go s.watchManifest(stopWorkers)
+	}
+
 	mux := s.setupRoutes()
 
 	if cfg.DevMode {
@@ -82,11 +170,12 @@ func Run(cfg Config) error {
 
 	server := &http.Server{
 		Addr:         fmt.Sprintf(":%d", cfg.Port),
-		Handler:      mux,
+		Handler:      s.requireSameOrigin(s.refreshSession(mux)),
 		ReadTimeout:  15 * time.Second,
 		WriteTimeout: 15 * time.Second,
 		IdleTimeout:  60 * time.Second,
 	}
+	server.RegisterOnShutdown(s.closeEventClients)
 
 	done := make(chan bool)
 	quit := make(chan os.Signal, 1)
@@ -133,17 +222,41 @@ func Run(cfg Config) error {
 func (s *Server) setupRoutes() *http.ServeMux {
 	mux := http.NewServeMux()
 
-	mux.HandleFunc("/api/reactions", s.handleReactions)
+	mux.HandleFunc("/api/reactions", s.rateLimit(limitReactions, s.handleReactions))
 	mux.HandleFunc("/api/reactions/user", s.handleUserReactions)
 	mux.HandleFunc("/api/me", s.handleMe)
-	mux.HandleFunc("/api/search", s.handleSearch)
-	mux.HandleFunc("/api/comments", s.handleComments)
-	mux.HandleFunc("/api/comments/", s.handleComment)
-
-	mux.HandleFunc("/auth/google", s.handleGoogleAuth)
-	mux.HandleFunc("/auth/google/callback", s.handleGoogleCallback)
-	mux.HandleFunc("/auth/github", s.handleGitHubAuth)
-	mux.HandleFunc("/auth/github/callback", s.handleGitHubCallback)
+	mux.HandleFunc("/api/me/export", s.handleMeExport)
+	mux.HandleFunc("/api/me/sessions", s.handleSessions)
+	mux.HandleFunc("/api/me/sessions/", s.handleSession)
+	mux.HandleFunc("/api/search", s.rateLimit(limitSearch, s.handleSearch))
+	mux.HandleFunc("/api/events", s.handleEvents)
+	mux.HandleFunc("/api/comments", s.rateLimit(limitComments, s.handleComments))
+	mux.HandleFunc("/api/comments/", s.rateLimit(limitComments, s.handleComment))
+	mux.HandleFunc("/api/admin/comments", s.handleAdminComments)
+	mux.HandleFunc("/api/admin/comments/", s.handleAdminComment)
+	mux.HandleFunc("/api/webmentions", s.handleWebmentions)
+	mux.HandleFunc("/api/admin/webmentions", s.handleAdminWebmentions)
+	mux.HandleFunc("/api/admin/webmentions/", s.handleAdminWebmention)
+	mux.HandleFunc("/api/admin/stats", s.handleAdminStats)
+	mux.HandleFunc("/api/feedback", s.rateLimit(limitFeedback, s.handleFeedback))
+	mux.HandleFunc("/api/admin/feedback", s.handleAdminFeedback)
+	mux.HandleFunc("/webmention", s.rateLimit(limitWebmentions, s.handleWebmention))
+	mux.HandleFunc("/api/newsletter", s.rateLimit(limitEmail, s.handleNewsletter))
+	mux.HandleFunc("/newsletter/confirm", s.handleNewsletterConfirm)
+	mux.HandleFunc("/newsletter/unsubscribe", s.handleNewsletterUnsubscribe)
+
+	if s.ap != nil {
+		mux.HandleFunc("/.well-known/webfinger", s.handleWebFinger)
+		mux.HandleFunc("/ap/actor", s.handleActor)
+		mux.HandleFunc("/ap/inbox", s.handleInbox)
+		mux.HandleFunc("/ap/outbox", s.handleOutbox)
+		mux.HandleFunc("/ap/followers", s.handleFollowers)
+		mux.HandleFunc("/ap/posts/", s.handleNote)
+	}
+
+	mux.HandleFunc("/auth/", s.handleAuth)
+	mux.HandleFunc("/auth/email", s.rateLimit(limitEmail, s.handleEmailLogin))
+	mux.HandleFunc("/auth/email/verify", s.handleEmailVerify)
 	mux.HandleFunc("/auth/logout", s.handleLogout)
 	mux.HandleFunc("/api/auth/providers", s.handleAuthProviders)
 
@@ -0,0 +1,297 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"site/internal/db"
+	"site/internal/models"
+)
+
+const (
+	sessionRotateAfter = 24 * time.Hour  // Token age at which it is replaced and the session extended
+	sessionRotateGrace = time.Minute     // How long a replaced token keeps working
+	sessionTouchEvery  = 5 * time.Minute // How often last-seen is written for a session
+	sessionCleanEvery  = time.Hour       // How often expired sessions are purged
+	maxUserAgentLength = 256
+)
+
+// secureCookies reports whether cookies should only be sent over HTTPS
+func (s *Server) secureCookies() bool {
+	return strings.HasPrefix(s.config.BaseURL, "https://")
+}
+
+// sessionCookie returns the cookie carrying a session token
+func (s *Server) sessionCookie(token string, expiresAt time.Time) *http.Cookie {
+	return &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    token,
+		Path:     "/",
+		Expires:  expiresAt,
+		HttpOnly: true,
+		Secure:   s.secureCookies(),
+		SameSite: http.SameSiteLaxMode,
+	}
+}
+
+// clearSessionCookie tells the browser to forget its session
+func (s *Server) clearSessionCookie(w http.ResponseWriter) {
+	http.SetCookie(w, s.sessionCookie("", time.Unix(0, 0)))
+}
+
+// startSession signs a user in on this browser
+func (s *Server) startSession(w http.ResponseWriter, r *http.Request, userID string) error {
+	token, err := generateToken(32)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	sess := &models.Session{
+		Token:      token,
+		UserID:     userID,
+		CreatedAt:  now,
+		LastSeenAt: now,
+		ExpiresAt:  now.Add(sessionDuration),
+		UserAgent:  truncate(r.UserAgent(), maxUserAgentLength),
+		IPHash:     s.hashIP(s.clientIP(r)),
+	}
+	if err := s.db.CreateSession(sess); err != nil {
+		return err
+	}
+
+	http.SetCookie(w, s.sessionCookie(token, sess.ExpiresAt))
+	return nil
+}
+
+// loadHashKey returns the key for hashing client IPs: AUTH_SECRET if set,
+// otherwise a random key stored in the database. Unlike the OAuth state
+// key it must survive restarts, or the same client would get a new hash.
+func (s *Server) loadHashKey() ([]byte, error) {
+	if secret := os.Getenv("AUTH_SECRET"); secret != "" {
+		return []byte(secret), nil
+	}
+	key, err := s.db.Secret(db.HashKeySecret, func() (string, error) {
+		return generateToken(32)
+	})
+	return []byte(key), err
+}
+
+// hashIP returns a keyed hash of an IP address, so sessions can be told
+// apart by network without storing where users connect from
+func (s *Server) hashIP(ip string) string {
+	mac := hmac.New(sha256.New, s.hashKey)
+	mac.Write([]byte("session-ip:" + ip))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))[:16]
+}
+
+// sessionID is the public identifier of a session, derived from its token
+func sessionID(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return base64.RawURLEncoding.EncodeToString(sum[:12])
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n]
+}
+
+// refreshSession keeps API requests' sessions alive: it records when a
+// session was last used and, once its token is sessionRotateAfter old,
+// swaps it for a new one with a fresh expiry
+func (s *Server) refreshSession(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cookie, err := r.Cookie(sessionCookieName)
+		if err != nil || !strings.HasPrefix(r.URL.Path, "/api/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		sess, err := s.db.GetSession(cookie.Value)
+		if err != nil || sess == nil || sess.Rotated {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		now := time.Now()
+		issuedAt := sess.ExpiresAt.Add(-sessionDuration)
+		switch {
+		case now.Sub(issuedAt) >= sessionRotateAfter:
+			token, err := generateToken(32)
+			if err != nil {
+				break
+			}
+			expiresAt := now.Add(sessionDuration)
+			err = s.db.RotateSession(sess.Token, token, expiresAt, now.Add(sessionRotateGrace))
+			if err == sql.ErrNoRows {
+				break // Another request rotated it first
+			}
+			if err != nil {
+				log.Printf("Failed to rotate session: %v", err)
+				break
+			}
+			http.SetCookie(w, s.sessionCookie(token, expiresAt))
+			r = withSessionToken(r, token)
+		case now.Sub(sess.LastSeenAt) >= sessionTouchEvery:
+			if err := s.db.TouchSession(sess.Token, now); err != nil {
+				log.Printf("Failed to update session: %v", err)
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// withSessionToken returns a copy of r whose session cookie holds token
+func withSessionToken(r *http.Request, token string) *http.Request {
+	cookies := r.Cookies()
+	r = r.Clone(r.Context())
+	r.Header.Del("Cookie")
+	for _, c := range cookies {
+		if c.Name == sessionCookieName {
+			c.Value = token
+		}
+		r.AddCookie(c)
+	}
+	return r
+}
+
+// cleanSessions purges expired sessions until stop is closed
+func (s *Server) cleanSessions(stop <-chan struct{}) {
+	ticker := time.NewTicker(sessionCleanEvery)
+	defer ticker.Stop()
+
+	for {
+		if n, err := s.db.CleanExpiredSessions(); err != nil {
+			log.Printf("Failed to clean expired sessions: %v", err)
+		} else if n > 0 {
+			log.Printf("Removed %d expired sessions", n)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		}
+	}
+}
+
+type sessionResponse struct {
+	ID         string `json:"id"`
+	CreatedAt  string `json:"createdAt"`
+	LastSeenAt string `json:"lastSeenAt"`
+	ExpiresAt  string `json:"expiresAt"`
+	UserAgent  string `json:"userAgent"`
+	Current    bool   `json:"current"`
+	SameIP     bool   `json:"sameIp"` // Used from the same IP address as this request
+}
+
+// handleSessions lists the current user's sessions (GET) or signs out
+// all of them except this one (DELETE)
+func (s *Server) handleSessions(w http.ResponseWriter, r *http.Request) {
+	user := s.getSessionUser(r)
+	if user == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	current := ""
+	if cookie, err := r.Cookie(sessionCookieName); err == nil {
+		current = cookie.Value
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		sessions, err := s.db.ListSessions(user.ID)
+		if err != nil {
+			http.Error(w, "Failed to list sessions", http.StatusInternalServerError)
+			return
+		}
+
+		ipHash := s.hashIP(s.clientIP(r))
+		response := make([]sessionResponse, 0, len(sessions))
+		for _, sess := range sessions {
+			response = append(response, sessionResponse{
+				ID:         sessionID(sess.Token),
+				CreatedAt:  sess.CreatedAt.Format(time.RFC3339),
+				LastSeenAt: sess.LastSeenAt.Format(time.RFC3339),
+				ExpiresAt:  sess.ExpiresAt.Format(time.RFC3339),
+				UserAgent:  sess.UserAgent,
+				Current:    sess.Token == current,
+				SameIP:     sess.IPHash == ipHash,
+			})
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+	case http.MethodDelete:
+		revoked, err := s.db.DeleteOtherSessions(user.ID, current)
+		if err != nil {
+			http.Error(w, "Failed to sign out sessions", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]int64{"revoked": revoked})
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleSession signs out one of the current user's sessions:
+// DELETE /api/me/sessions/{id}
+func (s *Server) handleSession(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	user := s.getSessionUser(r)
+	if user == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	id := strings.TrimPrefix(r.URL.Path, "/api/me/sessions/")
+	sessions, err := s.db.ListSessions(user.ID)
+	if err != nil {
+		http.Error(w, "Failed to sign out session", http.StatusInternalServerError)
+		return
+	}
+
+	token := ""
+	for _, sess := range sessions {
+		if hmac.Equal([]byte(sessionID(sess.Token)), []byte(id)) {
+			token = sess.Token
+			break
+		}
+	}
+	if token == "" {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+
+	err = s.db.DeleteUserSession(user.ID, token)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to sign out session", http.StatusInternalServerError)
+		return
+	}
+
+	if cookie, err := r.Cookie(sessionCookieName); err == nil && cookie.Value == token {
+		s.clearSessionCookie(w)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -9,6 +9,13 @@ import (
 
 func (s *Server) handleStatic(w http.ResponseWriter, r *http.Request) {
 	path := r.URL.Path
+
+	// Build metadata such as the post manifest is not public
+	if strings.HasPrefix(filepath.Base(path), "_") {
+		http.NotFound(w, r)
+		return
+	}
+
 	filePath := filepath.Join(s.config.OutputDir, path)
 
 	if !strings.Contains(filepath.Base(path), ".") {
@@ -33,6 +40,10 @@ func (s *Server) handleStatic(w http.ResponseWriter, r *http.Request) {
 		filePath = filepath.Join(filePath, "index.html")
 	}
 
+	if filepath.Ext(filePath) == ".html" {
+		s.countPageView(r)
+	}
+
 	setContentType(w, filePath)
 	http.ServeFile(w, r, filePath)
 }
@@ -0,0 +1,309 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"site/internal/models"
+	"site/internal/webmention"
+)
+
+const (
+	webmentionQueueSize     = 100              // Mentions waiting for verification before new ones are left for the next start
+	webmentionVerifyTimeout = 15 * time.Second // Time allowed to fetch a source
+	maxWebmentionURL        = 2048
+)
+
+// initialWebmentionStatus returns the status a verified webmention starts with
+func (s *Server) initialWebmentionStatus() string {
+	if s.config.Webmentions.Moderation == ModerationNone {
+		return models.CommentApproved
+	}
+	return models.CommentPending
+}
+
+// handleWebmention receives a webmention: POST /webmention with form fields
+// source and target. Sources are verified in the background, so a valid
+// request is accepted before anyone knows whether the source links here.
+func (s *Server) handleWebmention(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	source := r.PostFormValue("source")
+	target := r.PostFormValue("target")
+	if source == "" || target == "" {
+		http.Error(w, "Missing source or target", http.StatusBadRequest)
+		return
+	}
+
+	sourceURL, ok := parseWebURL(source)
+	if !ok {
+		http.Error(w, "Invalid source URL", http.StatusBadRequest)
+		return
+	}
+	targetURL, ok := parseWebURL(target)
+	if !ok {
+		http.Error(w, "Invalid target URL", http.StatusBadRequest)
+		return
+	}
+	if sourceURL.String() == targetURL.String() {
+		http.Error(w, "Source and target must differ", http.StatusBadRequest)
+		return
+	}
+
+	postID, ok := s.webmentionPost(targetURL)
+	if !ok {
+		http.Error(w, "Target is not a post on this site", http.StatusBadRequest)
+		return
+	}
+
+	id, err := s.db.QueueWebmention(source, target, postID)
+	if err != nil {
+		log.Printf("Failed to queue webmention: %v", err)
+		http.Error(w, "Failed to save webmention", http.StatusInternalServerError)
+		return
+	}
+	s.queueWebmention(id)
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// parseWebURL parses an absolute http(s) URL
+func parseWebURL(raw string) (*url.URL, bool) {
+	if len(raw) > maxWebmentionURL {
+		return nil, false
+	}
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, false
+	}
+	return u, true
+}
+
+// webmentionPost returns the ID of the post a target URL points to. Post
+// URLs are "/" + ID, on the base URL's host.
+func (s *Server) webmentionPost(target *url.URL) (string, bool) {
+	base, err := url.Parse(s.config.BaseURL)
+	if err != nil || !strings.EqualFold(base.Host, target.Host) {
+		return "", false
+	}
+	id := strings.Trim(target.Path, "/")
+	if _, ok := s.lookupPost(id); !ok {
+		return "", false
+	}
+	return id, true
+}
+
+// queueWebmention hands a webmention to the verifier. When the queue is
+// full it stays queued in the database and is picked up on the next start.
+func (s *Server) queueWebmention(id int64) {
+	select {
+	case s.webmentions <- id:
+	default:
+		log.Printf("Webmention queue full, verifying %d after restart", id)
+	}
+}
+
+// requeueWebmentions queues webmentions received before the last shutdown
+// that were never verified
+func (s *Server) requeueWebmentions() {
+	queued, err := s.db.ListWebmentionsByStatus(models.WebmentionQueued, webmentionQueueSize)
+	if err != nil {
+		log.Printf("Failed to load queued webmentions: %v", err)
+		return
+	}
+	for _, m := range queued {
+		s.queueWebmention(m.ID)
+	}
+}
+
+// verifyWebmentions checks queued webmentions one at a time until stop is
+// closed
+func (s *Server) verifyWebmentions(stop <-chan struct{}) {
+	for {
+		select {
+		case id := <-s.webmentions:
+			s.verifyWebmention(id)
+		case <-stop:
+			return
+		}
+	}
+}
+
+// verifyWebmention fetches a webmention's source. Sources that don't link
+// to the target, or no longer exist, have their webmention deleted.
+func (s *Server) verifyWebmention(id int64) {
+	m, err := s.db.GetWebmention(id)
+	if err != nil || m == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), webmentionVerifyTimeout)
+	defer cancel()
+
+	source, err := webmention.Verify(ctx, s.remoteClient, m.Source, m.Target)
+	if err != nil {
+		log.Printf("Rejected webmention from %s: %v", m.Source, err)
+		if err := s.db.DeleteWebmention(id); err != nil && err != sql.ErrNoRows {
+			log.Printf("Failed to delete webmention: %v", err)
+		}
+		return
+	}
+
+	if err := s.db.VerifyWebmention(id, source.Title, source.Author, s.initialWebmentionStatus()); err != nil {
+		log.Printf("Failed to save webmention: %v", err)
+	}
+}
+
+type webmentionResponse struct {
+	ID        int64  `json:"id"`
+	Source    string `json:"source"`
+	Title     string `json:"title"`
+	Author    string `json:"author"`
+	CreatedAt string `json:"createdAt"`
+}
+
+func newWebmentionResponse(m models.Webmention) webmentionResponse {
+	return webmentionResponse{
+		ID:        m.ID,
+		Source:    m.Source,
+		Title:     m.Title,
+		Author:    m.Author,
+		CreatedAt: m.CreatedAt.Format(time.RFC3339),
+	}
+}
+
+// handleWebmentions lists a post's approved webmentions:
+// GET /api/webmentions?post=collection/slug
+func (s *Server) handleWebmentions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	postSlug := r.URL.Query().Get("post")
+	if postSlug == "" {
+		http.Error(w, "Missing post parameter", http.StatusBadRequest)
+		return
+	}
+	if _, ok := s.requirePost(w, postSlug); !ok {
+		return
+	}
+
+	mentions, err := s.db.GetWebmentions(postSlug)
+	if err != nil {
+		http.Error(w, "Failed to get webmentions", http.StatusInternalServerError)
+		return
+	}
+
+	response := make([]webmentionResponse, 0, len(mentions))
+	for _, m := range mentions {
+		response = append(response, newWebmentionResponse(m))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+type adminWebmentionResponse struct {
+	webmentionResponse
+	Target string `json:"target"`
+	Post   string `json:"post"`
+	Status string `json:"status"`
+}
+
+// handleAdminWebmentions lists webmentions by moderation status:
+// GET /api/admin/webmentions?status=pending
+func (s *Server) handleAdminWebmentions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.requireAdmin(w, r) == nil {
+		return
+	}
+
+	status := r.URL.Query().Get("status")
+	if status == "" {
+		status = models.CommentPending
+	}
+	if !models.IsValidCommentStatus(status) && status != models.WebmentionQueued {
+		http.Error(w, "Invalid status", http.StatusBadRequest)
+		return
+	}
+
+	mentions, err := s.db.ListWebmentionsByStatus(status, adminListLimit)
+	if err != nil {
+		http.Error(w, "Failed to list webmentions", http.StatusInternalServerError)
+		return
+	}
+
+	response := make([]adminWebmentionResponse, 0, len(mentions))
+	for _, m := range mentions {
+		response = append(response, adminWebmentionResponse{
+			webmentionResponse: newWebmentionResponse(m),
+			Target:             m.Target,
+			Post:               m.PostSlug,
+			Status:             m.Status,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// handleAdminWebmention moderates a single webmention:
+// POST /api/admin/webmentions/123/{approve,reject,spam} or
+// DELETE /api/admin/webmentions/123
+func (s *Server) handleAdminWebmention(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/api/admin/webmentions/")
+	idPart, action, _ := strings.Cut(path, "/")
+	id, err := strconv.ParseInt(idPart, 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid webmention ID", http.StatusBadRequest)
+		return
+	}
+
+	var result error
+	switch {
+	case r.Method == http.MethodDelete && action == "":
+		if s.requireAdmin(w, r) == nil {
+			return
+		}
+		result = s.db.DeleteWebmention(id)
+
+	case r.Method == http.MethodPost:
+		status, ok := moderationActions[action]
+		if !ok {
+			http.Error(w, "Unknown action", http.StatusNotFound)
+			return
+		}
+		if s.requireAdmin(w, r) == nil {
+			return
+		}
+		result = s.db.SetWebmentionStatus(id, status)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	switch {
+	case result == sql.ErrNoRows:
+		http.Error(w, "Webmention not found", http.StatusNotFound)
+	case result != nil:
+		http.Error(w, "Failed to moderate webmention", http.StatusInternalServerError)
+	default:
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
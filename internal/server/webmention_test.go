@@ -0,0 +1,146 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"site/internal/models"
+)
+
+// fakeSource serves the pages that send webmentions. Each path's body can
+// be changed between requests; a nil body answers 410 Gone.
+type fakeSource struct {
+	srv *httptest.Server
+
+	mu    sync.Mutex
+	pages map[string]*string
+}
+
+func newFakeSource(t *testing.T) *fakeSource {
+	t.Helper()
+	f := &fakeSource{pages: make(map[string]*string)}
+	f.srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		body, ok := f.pages[r.URL.Path]
+		f.mu.Unlock()
+		switch {
+		case !ok:
+			http.NotFound(w, r)
+		case body == nil:
+			http.Error(w, "Gone", http.StatusGone)
+		default:
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.Write([]byte(*body))
+		}
+	}))
+	t.Cleanup(f.srv.Close)
+	return f
+}
+
+func (f *fakeSource) set(path string, body *string) {
+	f.mu.Lock()
+	f.pages[path] = body
+	f.mu.Unlock()
+}
+
+func ptr(s string) *string { return &s }
+
+func TestVerifyWebmention(t *testing.T) {
+	const target = testBaseURL + "/blog/hello"
+
+	tests := []struct {
+		name       string
+		body       *string
+		moderation string
+		wantStatus string // "" if the webmention should be deleted
+		wantTitle  string
+	}{
+		{
+			name:       "link found",
+			body:       ptr(`<title>Re: hello</title><meta name=author content='Ada'><a href="` + target + `">hello</a>`),
+			moderation: ModerationNone,
+			wantStatus: models.CommentApproved,
+			wantTitle:  "Re: hello",
+		},
+		{
+			name:       "held for moderation",
+			body:       ptr(`<a href='` + target + `#comments'>hello</a>`),
+			moderation: ModerationAll,
+			wantStatus: models.CommentPending,
+		},
+		{
+			name: "no link",
+			body: ptr(`<title>Re: hello</title><a href="` + testBaseURL + `/blog/other">other</a>`),
+		},
+		{
+			name: "gone",
+			body: nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t)
+			s.config.Webmentions.Moderation = tt.moderation
+			source := newFakeSource(t)
+			source.set("/reply", tt.body)
+
+			id, err := s.db.QueueWebmention(source.srv.URL+"/reply", target, "blog/hello")
+			if err != nil {
+				t.Fatal(err)
+			}
+			s.verifyWebmention(id)
+
+			m, err := s.db.GetWebmention(id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.wantStatus == "" {
+				if m != nil {
+					t.Fatalf("webmention kept with status %q", m.Status)
+				}
+				return
+			}
+			if m == nil {
+				t.Fatal("webmention was deleted")
+			}
+			if m.Status != tt.wantStatus || m.Title != tt.wantTitle {
+				t.Errorf("got status %q, title %q; want %q, %q", m.Status, m.Title, tt.wantStatus, tt.wantTitle)
+			}
+		})
+	}
+}
+
+// A source that is deleted after its webmention was approved resends the
+// webmention, which removes it
+func TestVerifyWebmentionSourceDeleted(t *testing.T) {
+	const target = testBaseURL + "/blog/hello"
+	s := newTestServer(t)
+	s.config.Webmentions.Moderation = ModerationNone
+	source := newFakeSource(t)
+	source.set("/reply", ptr(`<a href="../other">other</a> <a href="`+target+`">hello</a>`))
+
+	id, err := s.db.QueueWebmention(source.srv.URL+"/reply", target, "blog/hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.verifyWebmention(id)
+	if approved, _ := s.db.GetWebmentions("blog/hello"); len(approved) != 1 {
+		t.Fatalf("approved webmentions = %d, want 1", len(approved))
+	}
+
+	source.set("/reply", nil)
+	again, err := s.db.QueueWebmention(source.srv.URL+"/reply", target, "blog/hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again != id {
+		t.Fatalf("resent webmention got a new ID %d, want %d", again, id)
+	}
+	s.verifyWebmention(again)
+	if approved, _ := s.db.GetWebmentions("blog/hello"); len(approved) != 0 {
+		t.Fatalf("approved webmentions after 410 = %d, want 0", len(approved))
+	}
+}
@@ -0,0 +1,265 @@
+// Package webmention sends and verifies Webmentions, the W3C protocol for
+// telling a page that another page links to it
+package webmention
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// maxBody caps how much of a page is read when discovering an endpoint or
+// verifying a source
+const maxBody = 1 << 20
+
+// maxTitle caps the length of a source's title, in runes
+const maxTitle = 200
+
+var (
+	// ErrNoLink means the source doesn't link to the target
+	ErrNoLink = errors.New("source does not link to target")
+	// ErrGone means the source was deleted, so its mention should be too
+	ErrGone = errors.New("source is gone")
+)
+
+var (
+	commentRegex = regexp.MustCompile(`(?s)<!--.*?-->`)
+	tagRegex     = regexp.MustCompile(`(?is)<(a|link|img|meta|audio|video|source|iframe)\b([^>]*)>`)
+	attrRegex    = regexp.MustCompile(`(?is)([a-z][a-z0-9-]*)\s*=\s*(?:"([^"]*)"|'([^']*)'|([^\s"'>]+))`)
+	titleRegex   = regexp.MustCompile(`(?is)<title[^>]*>(.*?)</title>`)
+	linkRegex    = regexp.MustCompile(`<([^>]*)>([^,<]*)`)
+	relRegex     = regexp.MustCompile(`(?i);\s*rel\s*=\s*(?:"([^"]*)"|([^\s;,"]+))`)
+)
+
+// Source is what a verified source page says about itself
+type Source struct {
+	Title  string
+	Author string // From <meta name="author">, if the page has one
+}
+
+// Discover returns the Webmention endpoint advertised by target, resolved
+// to an absolute URL, or "" if it has none
+func Discover(ctx context.Context, client *http.Client, target string) (string, error) {
+	resp, err := get(ctx, client, target)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return "", fmt.Errorf("fetching %s: %s", target, resp.Status)
+	}
+	base := resp.Request.URL
+
+	// The Link header takes precedence over the document
+	for _, header := range resp.Header.Values("Link") {
+		for _, m := range linkRegex.FindAllStringSubmatch(header, -1) {
+			for _, rel := range relRegex.FindAllStringSubmatch(m[2], -1) {
+				if hasRel(rel[1]+rel[2], "webmention") {
+					return resolve(base, m[1])
+				}
+			}
+		}
+	}
+
+	if !isHTML(resp.Header.Get("Content-Type")) {
+		return "", nil
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxBody))
+	if err != nil {
+		return "", fmt.Errorf("reading %s: %w", target, err)
+	}
+
+	for _, tag := range parseTags(string(body)) {
+		if tag.name != "a" && tag.name != "link" {
+			continue
+		}
+		href, ok := tag.attrs["href"]
+		if ok && hasRel(tag.attrs["rel"], "webmention") {
+			return resolve(base, href)
+		}
+	}
+	return "", nil
+}
+
+// Send notifies endpoint that source links to target
+func Send(ctx context.Context, client *http.Client, endpoint, source, target string) error {
+	form := url.Values{"source": {source}, "target": {target}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, maxBody))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("endpoint %s returned %s", endpoint, resp.Status)
+	}
+	return nil
+}
+
+// Verify fetches source and checks that it links to target. It returns
+// ErrNoLink if it doesn't and ErrGone if the source has been deleted.
+func Verify(ctx context.Context, client *http.Client, source, target string) (*Source, error) {
+	resp, err := get(ctx, client, source)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusGone {
+		return nil, ErrGone
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("fetching %s: %s", source, resp.Status)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxBody))
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", source, err)
+	}
+
+	// Anything other than HTML only has to mention the target somewhere
+	if !isHTML(resp.Header.Get("Content-Type")) {
+		if !strings.Contains(string(body), target) {
+			return nil, ErrNoLink
+		}
+		return &Source{}, nil
+	}
+
+	found := false
+	var src Source
+	for _, tag := range parseTags(string(body)) {
+		if tag.name == "meta" {
+			if strings.EqualFold(tag.attrs["name"], "author") {
+				src.Author = strings.TrimSpace(tag.attrs["content"])
+			}
+			continue
+		}
+		for _, attr := range []string{"href", "src"} {
+			if v, ok := tag.attrs[attr]; ok && sameURL(resp.Request.URL, v, target) {
+				found = true
+			}
+		}
+	}
+	if !found {
+		return nil, ErrNoLink
+	}
+
+	if m := titleRegex.FindStringSubmatch(string(body)); m != nil {
+		src.Title = truncate(strings.Join(strings.Fields(html.UnescapeString(m[1])), " "), maxTitle)
+	}
+	return &src, nil
+}
+
+// Links returns the distinct absolute http(s) URLs that <a> elements in an
+// HTML fragment point to, resolving relative links against base
+func Links(content string, base *url.URL) []string {
+	seen := make(map[string]bool)
+	var links []string
+	for _, tag := range parseTags(content) {
+		href, ok := tag.attrs["href"]
+		if tag.name != "a" || !ok {
+			continue
+		}
+		u, err := base.Parse(href)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			continue
+		}
+		u.Fragment = ""
+		if link := u.String(); !seen[link] {
+			seen[link] = true
+			links = append(links, link)
+		}
+	}
+	return links
+}
+
+// tag is an HTML start tag with its attribute values unescaped
+type tag struct {
+	name  string
+	attrs map[string]string
+}
+
+// parseTags returns the start tags that can carry links, in document order
+func parseTags(content string) []tag {
+	content = commentRegex.ReplaceAllString(content, "")
+
+	var tags []tag
+	for _, m := range tagRegex.FindAllStringSubmatch(content, -1) {
+		t := tag{name: strings.ToLower(m[1]), attrs: make(map[string]string)}
+		for _, a := range attrRegex.FindAllStringSubmatch(m[2], -1) {
+			name := strings.ToLower(a[1])
+			if _, ok := t.attrs[name]; !ok {
+				t.attrs[name] = html.UnescapeString(a[2] + a[3] + a[4])
+			}
+		}
+		tags = append(tags, t)
+	}
+	return tags
+}
+
+func get(ctx context.Context, client *http.Client, rawURL string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "text/html, */*;q=0.5")
+	return client.Do(req)
+}
+
+// hasRel reports whether a space-separated rel attribute includes value
+func hasRel(rel, value string) bool {
+	for _, r := range strings.Fields(rel) {
+		if strings.EqualFold(r, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// resolve makes ref absolute. An empty ref is the page itself.
+func resolve(base *url.URL, ref string) (string, error) {
+	u, err := base.Parse(strings.TrimSpace(ref))
+	if err != nil {
+		return "", fmt.Errorf("invalid endpoint %q: %w", ref, err)
+	}
+	return u.String(), nil
+}
+
+// sameURL reports whether ref, found on the page at base, points to target
+func sameURL(base *url.URL, ref, target string) bool {
+	u, err := base.Parse(strings.TrimSpace(ref))
+	if err != nil {
+		return false
+	}
+	u.Fragment = ""
+	return u.String() == target
+}
+
+func isHTML(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	// Pages served without a type are most likely HTML
+	return err != nil || mediaType == "text/html" || mediaType == "application/xhtml+xml"
+}
+
+func truncate(s string, n int) string {
+	runes := []rune(s)
+	if len(runes) <= n {
+		return s
+	}
+	return string(runes[:n])
+}
@@ -0,0 +1,213 @@
+package webmention
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+// page is a response served by the fake site
+type page struct {
+	status      int
+	contentType string
+	link        string // Link header
+	body        string
+}
+
+// fakeSite serves pages by path. Paths it doesn't know are 404s.
+func fakeSite(t *testing.T, pages map[string]page) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p, ok := pages[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		if p.link != "" {
+			w.Header().Set("Link", p.link)
+		}
+		contentType := p.contentType
+		if contentType == "" {
+			contentType = "text/html; charset=utf-8"
+		}
+		w.Header().Set("Content-Type", contentType)
+		if p.status != 0 {
+			w.WriteHeader(p.status)
+		}
+		w.Write([]byte(p.body))
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestVerify(t *testing.T) {
+	const target = "http://site.test/blog/hello"
+
+	srv := fakeSite(t, map[string]page{
+		"/linked": {body: `<html><head><title> A  reply &amp; more </title>
+			<meta name="author" content="Ada"></head>
+			<body><p>See <a href="http://site.test/blog/hello">this post</a>.</p></body></html>`},
+		"/single-quoted":  {body: `<a class=u-in-reply-to href='http://site.test/blog/hello#comments'>reply</a>`},
+		"/unquoted":       {body: `<a href=http://site.test/blog/hello>reply</a>`},
+		"/image":          {body: `<img src="http://site.test/blog/hello">`},
+		"/unlinked":       {body: `<title>Unrelated</title><a href="http://site.test/blog/other">other</a>`},
+		"/commented":      {body: `<!-- <a href="http://site.test/blog/hello">hidden</a> -->`},
+		"/prefix":         {body: `<a href="http://site.test/blog/hello-again">close</a>`},
+		"/deleted":        {status: http.StatusGone, body: "Gone"},
+		"/broken":         {status: http.StatusInternalServerError, body: "oops"},
+		"/plain":          {contentType: "text/plain", body: "Replying to http://site.test/blog/hello"},
+		"/plain-unlinked": {contentType: "text/plain", body: "Nothing here"},
+	})
+
+	tests := []struct {
+		path       string
+		wantErr    error
+		wantAnyErr bool
+		want       *Source
+	}{
+		{path: "/linked", want: &Source{Title: "A reply & more", Author: "Ada"}},
+		{path: "/single-quoted", want: &Source{}},
+		{path: "/unquoted", want: &Source{}},
+		{path: "/image", want: &Source{}},
+		{path: "/plain", want: &Source{}},
+		{path: "/unlinked", wantErr: ErrNoLink},
+		{path: "/commented", wantErr: ErrNoLink},
+		{path: "/prefix", wantErr: ErrNoLink},
+		{path: "/plain-unlinked", wantErr: ErrNoLink},
+		{path: "/deleted", wantErr: ErrGone},
+		{path: "/broken", wantAnyErr: true},
+		{path: "/missing", wantAnyErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			got, err := Verify(context.Background(), srv.Client(), srv.URL+tt.path, target)
+			switch {
+			case tt.wantErr != nil:
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("err = %v, want %v", err, tt.wantErr)
+				}
+			case tt.wantAnyErr:
+				if err == nil || errors.Is(err, ErrNoLink) || errors.Is(err, ErrGone) {
+					t.Fatalf("err = %v, want a fetch error", err)
+				}
+			default:
+				if err != nil {
+					t.Fatalf("Verify: %v", err)
+				}
+				if !reflect.DeepEqual(got, tt.want) {
+					t.Errorf("got %+v, want %+v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestVerifyRelativeLink(t *testing.T) {
+	srv := fakeSite(t, map[string]page{
+		"/notes/1": {body: `<a href="../blog/hello">my earlier post</a>`},
+	})
+	target := srv.URL + "/blog/hello"
+	if _, err := Verify(context.Background(), srv.Client(), srv.URL+"/notes/1", target); err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+}
+
+func TestDiscover(t *testing.T) {
+	srv := fakeSite(t, map[string]page{
+		"/header":          {link: `<https://example.com/style.css>; rel="stylesheet", </endpoints/wm?x=1>; rel="webmention"`},
+		"/header-relative": {link: `<wm>; rel=webmention`, body: `<link rel="webmention" href="/ignored">`},
+		"/header-multiple": {link: `</other>; rel="me webmention"`},
+		"/link":            {body: `<head><link rel="stylesheet" href="/s.css"><link rel=webmention href=/wm></head>`},
+		"/link-relative":   {body: `<link href="../endpoint" rel="webmention">`},
+		"/anchor":          {body: `<a rel='webmention' href='https://wm.example.com/api?u=1&amp;v=2'>endpoint</a>`},
+		"/self":            {body: `<link rel="webmention" href="">`},
+		"/commented":       {body: `<!-- <link rel="webmention" href="/wm"> -->`},
+		"/none":            {body: `<a href="/about">about</a>`},
+		"/json":            {contentType: "application/json", body: `{"rel":"webmention"}`},
+	})
+
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"/header", srv.URL + "/endpoints/wm?x=1"},
+		{"/header-relative", srv.URL + "/wm"},
+		{"/header-multiple", srv.URL + "/other"},
+		{"/link", srv.URL + "/wm"},
+		{"/link-relative", srv.URL + "/endpoint"},
+		{"/anchor", "https://wm.example.com/api?u=1&v=2"},
+		{"/self", srv.URL + "/self"},
+		{"/commented", ""},
+		{"/none", ""},
+		{"/json", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			got, err := Discover(context.Background(), srv.Client(), srv.URL+tt.path)
+			if err != nil {
+				t.Fatalf("Discover: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := Discover(context.Background(), srv.Client(), srv.URL+"/missing"); err == nil {
+		t.Error("Discover of a 404 succeeded")
+	}
+}
+
+func TestSend(t *testing.T) {
+	var got url.Values
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/x-www-form-urlencoded" {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		r.ParseForm()
+		got = r.PostForm
+		if r.URL.Path == "/reject" {
+			http.Error(w, "no", http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer srv.Close()
+
+	if err := Send(context.Background(), srv.Client(), srv.URL+"/wm", "http://site.test/a", "https://example.com/b"); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	if got.Get("source") != "http://site.test/a" || got.Get("target") != "https://example.com/b" {
+		t.Errorf("endpoint got %v", got)
+	}
+	if err := Send(context.Background(), srv.Client(), srv.URL+"/reject", "http://site.test/a", "https://example.com/b"); err == nil {
+		t.Error("Send ignored a 400")
+	}
+}
+
+func TestLinks(t *testing.T) {
+	base, _ := url.Parse("http://site.test/blog/hello")
+	content := `<p><a href="https://example.com/a#top">a</a> <a href='/about'>about</a>
+		<a href=https://example.com/a>again</a> <a href="mailto:me@example.com">mail</a>
+		<a name="anchor">no href</a> <img src="https://example.com/img.png"></p>`
+
+	want := []string{"https://example.com/a", "http://site.test/about"}
+	if got := Links(content, base); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestPublicClientRefusesLoopback(t *testing.T) {
+	srv := fakeSite(t, map[string]page{"/": {body: "hi"}})
+	_, err := Verify(context.Background(), PublicClient(0), srv.URL+"/", "http://site.test/")
+	if !errors.Is(err, ErrPrivateAddress) {
+		t.Fatalf("err = %v, want %v", err, ErrPrivateAddress)
+	}
+}
@@ -10,6 +10,7 @@
     providersContainer: null,
     closeBtn: null,
     providers: null,
+    emailLogin: false,
     redirectUrl: null,
 
     init() {
@@ -73,6 +74,7 @@
 
         const data = await response.json();
         this.providers = data.providers || [];
+        this.emailLogin = !!data.email;
         this.renderProviders();
       } catch (err) {
         console.error("Failed to load auth providers:", err);
@@ -82,7 +84,7 @@
     },
 
     renderProviders() {
-      if (!this.providers || this.providers.length === 0) {
+      if ((!this.providers || this.providers.length === 0) && !this.emailLogin) {
         this.providersContainer.innerHTML =
           '<div class="login-loading">No sign in options available</div>';
         return;
@@ -103,6 +105,49 @@
                 </a>`;
         })
         .join("");
+
+      if (this.emailLogin) {
+        this.providersContainer.insertAdjacentHTML(
+          "beforeend",
+          `${this.providers.length > 0 ? '<div class="login-divider"><span>or</span></div>' : ""}
+          <form class="login-email">
+            <input type="email" name="email" placeholder="you@example.com" autocomplete="email" required />
+            <button type="submit" class="login-provider-btn">Email me a sign in link</button>
+          </form>`,
+        );
+        this.providersContainer
+          .querySelector(".login-email")
+          .addEventListener("submit", (e) => this.sendEmailLink(e));
+      }
+    },
+
+    async sendEmailLink(e) {
+      e.preventDefault();
+      const form = e.target;
+      const email = form.email.value.trim();
+      const button = form.querySelector("button");
+      button.disabled = true;
+
+      try {
+        const response = await fetch("/auth/email", {
+          method: "POST",
+          headers: { "Content-Type": "application/json" },
+          body: JSON.stringify({ email, redirect: this.redirectUrl }),
+        });
+        if (!response.ok) {
+          alert(await response.text());
+          button.disabled = false;
+          return;
+        }
+
+        const sent = document.createElement("div");
+        sent.className = "login-email-sent";
+        sent.textContent = `Check your inbox: we sent a sign in link to ${email}.`;
+        form.replaceWith(sent);
+      } catch (err) {
+        console.error("Failed to send sign in link:", err);
+        button.disabled = false;
+      }
     },
   };
 
@@ -114,7 +159,10 @@
       this.initMobileMenu();
       this.initSearch();
       this.initCollectionSort();
+      PostEvents.init();
       this.initReactions();
+      this.initFeedback();
+      this.initNewsletter();
       this.initComments();
     },
 
@@ -376,6 +424,31 @@
       new Reactions(container, postSlug);
     },
 
+    initFeedback() {
+      const container = document.querySelector(".feedback");
+      if (!container) return;
+
+      const postSlug = container.dataset.post;
+      if (!postSlug) return;
+
+      // Skip if already initialized
+      if (container.dataset.initialized === postSlug) return;
+      container.dataset.initialized = postSlug;
+
+      new Feedback(container, postSlug);
+    },
+
+    initNewsletter() {
+      const form = document.querySelector(".newsletter");
+      if (!form) return;
+
+      // Skip if already initialized
+      if (form.dataset.initialized) return;
+      form.dataset.initialized = "true";
+
+      new Newsletter(form);
+    },
+
     initComments() {
       const container = document.querySelector(".comments-section");
       if (!container) return;
@@ -391,6 +464,193 @@
     },
   };
 
+  // ========================================
+  // Live Post Events
+  // ========================================
+  // One event stream per post page, shared by reactions and comments
+  const PostEvents = {
+    source: null,
+    postSlug: null,
+
+    init() {
+      const container = document.querySelector("[data-post]");
+      const postSlug = container ? container.dataset.post : null;
+      if (postSlug === this.postSlug) return;
+
+      if (this.source) {
+        this.source.close();
+        this.source = null;
+      }
+      this.postSlug = postSlug;
+      if (!postSlug || !window.EventSource) return;
+
+      this.source = new EventSource(
+        `/api/events?post=${encodeURIComponent(postSlug)}`
+      );
+    },
+
+    on(postSlug, types, handler) {
+      if (!this.source || postSlug !== this.postSlug) return;
+      types.forEach((type) => {
+        this.source.addEventListener(type, (e) =>
+          handler(JSON.parse(e.data))
+        );
+      });
+    },
+  };
+
+  // ========================================
+  // Docs Page Feedback
+  // ========================================
+  class Feedback {
+    constructor(container, postSlug) {
+      this.container = container;
+      this.postSlug = postSlug;
+      this.form = container.querySelector(".feedback-form");
+      this.reason = container.querySelector(".feedback-reason");
+      this.thanks = container.querySelector(".feedback-thanks");
+      this.helpful = null;
+
+      this.init();
+    }
+
+    async init() {
+      this.container.querySelectorAll(".feedback-btn").forEach((btn) => {
+        btn.addEventListener("click", () =>
+          this.vote(btn.dataset.helpful === "true")
+        );
+      });
+      this.form.addEventListener("submit", (e) => {
+        e.preventDefault();
+        this.send(this.reason.value.trim());
+      });
+
+      try {
+        const response = await fetch(
+          `/api/feedback?post=${encodeURIComponent(this.postSlug)}`
+        );
+        if (response.ok) {
+          const vote = await response.json();
+          if (vote) {
+            this.helpful = vote.helpful;
+            this.reason.value = vote.reason;
+            this.updateActiveStates();
+          }
+        }
+      } catch (err) {
+        console.error("Failed to fetch feedback:", err);
+      }
+    }
+
+    // Votes count straight away; the reason is optional and sent after
+    async vote(helpful) {
+      this.helpful = helpful;
+      this.updateActiveStates();
+      this.reason.placeholder = helpful
+        ? "What did you find useful? (optional)"
+        : "What could be better? (optional)";
+      if (await this.send(this.reason.value.trim(), false)) {
+        this.form.hidden = false;
+        this.thanks.hidden = true;
+        this.reason.focus();
+      }
+    }
+
+    async send(reason, done = true) {
+      if (this.helpful === null) return false;
+      try {
+        const response = await fetch("/api/feedback", {
+          method: "POST",
+          headers: { "Content-Type": "application/json" },
+          body: JSON.stringify({
+            post: this.postSlug,
+            helpful: this.helpful,
+            reason,
+          }),
+        });
+        if (!response.ok) {
+          alert(await response.text());
+          return false;
+        }
+      } catch (err) {
+        console.error("Failed to send feedback:", err);
+        return false;
+      }
+
+      if (done) {
+        this.form.hidden = true;
+        this.thanks.hidden = false;
+      }
+      return true;
+    }
+
+    updateActiveStates() {
+      this.container.querySelectorAll(".feedback-btn").forEach((btn) => {
+        btn.classList.toggle(
+          "active",
+          (btn.dataset.helpful === "true") === this.helpful
+        );
+      });
+    }
+  }
+
+  // ========================================
+  // Newsletter Sign Up
+  // ========================================
+  class Newsletter {
+    constructor(form) {
+      this.form = form;
+      this.email = form.querySelector(".newsletter-email");
+      this.series = form.querySelector(".newsletter-series");
+      this.status = form.querySelector(".newsletter-status");
+
+      this.init();
+    }
+
+    // The form stays hidden unless the server takes subscriptions
+    async init() {
+      try {
+        const response = await fetch("/api/newsletter");
+        if (!response.ok || !(await response.json()).enabled) return;
+      } catch (err) {
+        return;
+      }
+
+      this.form.hidden = false;
+      this.form.addEventListener("submit", (e) => {
+        e.preventDefault();
+        this.subscribe();
+      });
+    }
+
+    async subscribe() {
+      const button = this.form.querySelector("button");
+      button.disabled = true;
+      try {
+        const response = await fetch("/api/newsletter", {
+          method: "POST",
+          headers: { "Content-Type": "application/json" },
+          body: JSON.stringify({
+            email: this.email.value.trim(),
+            series: this.series ? this.series.value : "",
+          }),
+        });
+        if (!response.ok) {
+          alert(await response.text());
+          return;
+        }
+        this.status.textContent =
+          "Check your inbox and confirm your subscription.";
+        this.status.hidden = false;
+        this.email.value = "";
+      } catch (err) {
+        console.error("Failed to subscribe:", err);
+      } finally {
+        button.disabled = false;
+      }
+    }
+  }
+
   // ========================================
   // Reactions Handler
   // ========================================
@@ -406,6 +666,9 @@
 
     async init() {
       this.attachHandlers();
+      PostEvents.on(this.postSlug, ["reactions"], (data) =>
+        this.updateCounts(data)
+      );
       await Promise.all([this.fetchReactions(), this.fetchUserReactions()]);
     }
 
@@ -528,6 +791,7 @@
       this.comments = [];
       this.isPreviewMode = false;
       this.editingCommentId = null;
+      this.isStale = false;
 
       this.form = container.querySelector(".comment-form");
       this.textarea = container.querySelector(".comment-input");
@@ -536,16 +800,46 @@
       this.submitBtn = container.querySelector(".comment-submit");
       this.commentsList = container.querySelector(".comments-list");
       this.avatarPlaceholder = container.querySelector(".avatar-placeholder");
+      this.webmentions = container.querySelector(".webmentions");
+      this.webmentionsList = container.querySelector(".webmentions-list");
 
       this.init();
     }
 
     async init() {
       this.attachHandlers();
-      await Promise.all([this.checkAuth(), this.fetchComments()]);
+      PostEvents.on(
+        this.postSlug,
+        ["comment.created", "comment.updated", "comment.deleted"],
+        () => this.refreshComments()
+      );
+      PostEvents.on(this.postSlug, ["webmentions"], () =>
+        this.fetchWebmentions()
+      );
+      await Promise.all([
+        this.checkAuth(),
+        this.fetchComments(),
+        this.fetchWebmentions(),
+      ]);
       this.updateFormState();
     }
 
+    // Refetch after someone else's change, unless that would wipe out an
+    // open edit or reply form; those refetch when they close
+    refreshComments() {
+      const formOpen = [
+        ...this.commentsList.querySelectorAll(
+          ".comment-edit-form, .comment-reply-form"
+        ),
+      ].some((form) => form.style.display === "block");
+
+      if (formOpen) {
+        this.isStale = true;
+        return;
+      }
+      this.fetchComments();
+    }
+
     attachHandlers() {
       // Submit button
       this.submitBtn.addEventListener("click", () => this.submitComment());
@@ -611,6 +905,7 @@
         );
         if (response.ok) {
           this.comments = await response.json();
+          this.isStale = false;
           this.renderComments();
         }
       } catch (err) {
@@ -618,6 +913,43 @@
       }
     }
 
+    async fetchWebmentions() {
+      if (!this.webmentionsList) return;
+      try {
+        const response = await fetch(
+          `/api/webmentions?post=${encodeURIComponent(this.postSlug)}`
+        );
+        if (response.ok) {
+          this.renderWebmentions(await response.json());
+        }
+      } catch (err) {
+        console.error("Failed to fetch webmentions:", err);
+      }
+    }
+
+    // Pages elsewhere that link to this post
+    renderWebmentions(mentions) {
+      this.webmentions.hidden = mentions.length === 0;
+      this.webmentionsList.innerHTML = mentions
+        .map((mention) => {
+          const host = new URL(mention.source).hostname;
+          const by = mention.author ? `${mention.author} on ${host}` : host;
+          const timeAgo = this.formatTimeAgo(new Date(mention.createdAt));
+          return `<li class="webmention">
+                    <a href="${this.escapeHtml(mention.source).replace(
+                      /"/g,
+                      "&quot;"
+                    )}" rel="nofollow ugc">${this.escapeHtml(
+            mention.title || mention.source
+          )}</a>
+                    <span class="webmention-meta">${this.escapeHtml(
+                      by
+                    )} · ${timeAgo}</span>
+                  </li>`;
+        })
+        .join("");
+    }
+
     renderComments() {
       if (!this.comments || this.comments.length === 0) {
         this.commentsList.innerHTML =
@@ -657,9 +989,47 @@
             this.cancelEdit(parseInt(btn.dataset.id))
           );
         });
+
+      this.commentsList
+        .querySelectorAll(".comment-reply-btn")
+        .forEach((btn) => {
+          btn.addEventListener("click", () =>
+            this.toggleReply(parseInt(btn.dataset.id), true)
+          );
+        });
+
+      this.commentsList
+        .querySelectorAll(".comment-reply-cancel-btn")
+        .forEach((btn) => {
+          btn.addEventListener("click", () =>
+            this.toggleReply(parseInt(btn.dataset.id), false)
+          );
+        });
+
+      this.commentsList
+        .querySelectorAll(".comment-reply-submit-btn")
+        .forEach((btn) => {
+          btn.addEventListener("click", () =>
+            this.submitReply(parseInt(btn.dataset.id))
+          );
+        });
     }
 
     renderComment(comment) {
+      const depth = comment.depth || 0;
+
+      // Deleted comments stay as placeholders so their replies keep context
+      if (comment.deleted) {
+        return `
+                <div class="comment comment-deleted" data-id="${comment.id}" style="--comment-depth: ${depth}">
+                    <div class="comment-avatar"><span class="avatar-initial">–</span></div>
+                    <div class="comment-main">
+                        <div class="comment-body">This comment was deleted.</div>
+                    </div>
+                </div>
+            `;
+      }
+
       const isOwn = this.currentUser && comment.userId === this.currentUser.id;
       const avatar = comment.userAvatar
         ? `<img src="${comment.userAvatar}" alt="${comment.userName}" class="comment-avatar-img">`
@@ -669,9 +1039,10 @@
       const date = new Date(comment.createdAt);
       const timeAgo = this.formatTimeAgo(date);
       const isEdited = comment.updatedAt !== comment.createdAt;
+      const isPending = comment.status === "pending";
 
       return `
-                <div class="comment" data-id="${comment.id}">
+                <div class="comment${isPending ? " is-pending" : ""}" data-id="${comment.id}" style="--comment-depth: ${depth}">
                     <div class="comment-avatar">${avatar}</div>
                     <div class="comment-main">
                         <div class="comment-header">
@@ -681,6 +1052,11 @@
                             <span class="comment-time" title="${date.toLocaleString()}">${timeAgo}${
         isEdited ? " (edited)" : ""
       }</span>
+                            ${
+                              isPending
+                                ? `<span class="comment-pending" title="Only you can see this comment until it is approved">Awaiting moderation</span>`
+                                : ""
+                            }
                             ${
                               isOwn
                                 ? `
@@ -706,6 +1082,22 @@
                                 }">Save</button>
                             </div>
                         </div>
+                        ${
+                          this.isLoggedIn && !isPending
+                            ? `
+                            <div class="comment-footer">
+                                <button class="comment-reply-btn" data-id="${comment.id}">Reply</button>
+                            </div>
+                            <div class="comment-reply-form" style="display: none;">
+                                <textarea class="comment-edit-input comment-reply-input" placeholder="Write a reply... (Markdown supported)"></textarea>
+                                <div class="comment-edit-actions">
+                                    <button class="comment-reply-cancel-btn" data-id="${comment.id}">Cancel</button>
+                                    <button class="comment-reply-submit-btn" data-id="${comment.id}">Reply</button>
+                                </div>
+                            </div>
+                        `
+                            : ""
+                        }
                     </div>
                 </div>
             `;
@@ -813,6 +1205,9 @@
           if (this.isPreviewMode) {
             this.togglePreview();
           }
+        } else if (response.status === 409 || response.status === 429) {
+          // Duplicate or rate limited; the server explains which
+          alert((await response.text()).trim());
         } else {
           throw new Error("Failed to post comment");
         }
@@ -825,6 +1220,65 @@
       }
     }
 
+    toggleReply(commentId, open) {
+      const commentEl = this.container.querySelector(
+        `.comment[data-id="${commentId}"]`
+      );
+      if (!commentEl) return;
+
+      const form = commentEl.querySelector(".comment-reply-form");
+      form.style.display = open ? "block" : "none";
+      commentEl.querySelector(".comment-footer").style.display = open
+        ? "none"
+        : "flex";
+      if (open) {
+        form.querySelector(".comment-reply-input").focus();
+      } else if (this.isStale) {
+        this.fetchComments();
+      }
+    }
+
+    async submitReply(parentId) {
+      const commentEl = this.container.querySelector(
+        `.comment[data-id="${parentId}"]`
+      );
+      if (!commentEl) return;
+
+      const content = commentEl
+        .querySelector(".comment-reply-input")
+        .value.trim();
+      if (!content) return;
+
+      const submitBtn = commentEl.querySelector(".comment-reply-submit-btn");
+      submitBtn.disabled = true;
+      submitBtn.textContent = "Posting...";
+
+      try {
+        const response = await fetch("/api/comments", {
+          method: "POST",
+          headers: { "Content-Type": "application/json" },
+          body: JSON.stringify({ post: this.postSlug, content, parentId }),
+        });
+
+        if (response.status === 409 || response.status === 429) {
+          alert((await response.text()).trim());
+          submitBtn.disabled = false;
+          submitBtn.textContent = "Reply";
+          return;
+        }
+        if (!response.ok) {
+          throw new Error("Failed to post reply");
+        }
+        // Refetch so the reply lands in its place in the thread
+        await this.fetchComments();
+      } catch (err) {
+        console.error("Failed to post reply:", err);
+        alert("Failed to post reply. Please try again.");
+        submitBtn.disabled = false;
+        submitBtn.textContent = "Reply";
+      }
+    }
+
     startEdit(commentId) {
       const commentEl = this.container.querySelector(
         `.comment[data-id="${commentId}"]`
@@ -850,6 +1304,10 @@
       commentEl.querySelector(".comment-body").style.display = "block";
       commentEl.querySelector(".comment-actions").style.display = "flex";
       commentEl.querySelector(".comment-edit-form").style.display = "none";
+
+      if (this.isStale) {
+        this.fetchComments();
+      }
     }
 
     async saveEdit(commentId) {
@@ -879,7 +1337,11 @@
           if (index !== -1) {
             this.comments[index] = updatedComment;
           }
-          this.renderComments();
+          if (this.isStale) {
+            await this.fetchComments();
+          } else {
+            this.renderComments();
+          }
         } else {
           throw new Error("Failed to update comment");
         }
@@ -900,8 +1362,8 @@
         });
 
         if (response.ok) {
-          this.comments = this.comments.filter((c) => c.id !== commentId);
-          this.renderComments();
+          // Comments with replies are kept as placeholders, so refetch
+          await this.fetchComments();
         } else {
           throw new Error("Failed to delete comment");
         }
@@ -962,6 +1424,11 @@
         this.toggleMenu();
       });
 
+      const deleteButton = this.dropdown.querySelector(".profile-delete-account");
+      if (deleteButton) {
+        deleteButton.addEventListener("click", () => this.deleteAccount());
+      }
+
       // Close on click outside
       document.addEventListener("click", (e) => {
         if (this.dropdown && !this.dropdown.contains(e.target)) {
@@ -1022,6 +1489,21 @@
               ${this.user.email ? `<span class="profile-menu-email">${this.escapeHtml(this.user.email)}</span>` : ""}
             </div>
             <div class="profile-menu-divider"></div>
+            <a href="/api/me/export" class="profile-menu-item" download data-no-router>
+              <svg width="16" height="16" viewBox="0 0 24 24" fill="none" stroke="currentColor" stroke-width="2">
+                <path d="M21 15v4a2 2 0 0 1-2 2H5a2 2 0 0 1-2-2v-4"></path>
+                <polyline points="7 10 12 15 17 10"></polyline>
+                <line x1="12" y1="15" x2="12" y2="3"></line>
+              </svg>
+              Download my data
+            </a>
+            <button type="button" class="profile-menu-item profile-delete-account">
+              <svg width="16" height="16" viewBox="0 0 24 24" fill="none" stroke="currentColor" stroke-width="2">
+                <polyline points="3 6 5 6 21 6"></polyline>
+                <path d="M19 6l-1 14a2 2 0 0 1-2 2H8a2 2 0 0 1-2-2L5 6"></path>
+              </svg>
+              Delete account
+            </button>
             <a href="/auth/logout?redirect=${encodeURIComponent(window.location.pathname)}" class="profile-menu-item" data-no-router>
               <svg width="16" height="16" viewBox="0 0 24 24" fill="none" stroke="currentColor" stroke-width="2">
                 <path d="M9 21H5a2 2 0 0 1-2-2V5a2 2 0 0 1 2-2h4"></path>
@@ -1040,6 +1522,26 @@
       this.attachHandlers();
     },
 
+    async deleteAccount() {
+      if (
+        !confirm(
+          "Delete your account? Your reactions and sign-in sessions are removed and this cannot be undone.",
+        )
+      )
+        return;
+
+      try {
+        const response = await fetch("/api/me", { method: "DELETE" });
+        if (!response.ok) {
+          alert(await response.text());
+          return;
+        }
+        window.location.reload();
+      } catch (err) {
+        console.error("Failed to delete account:", err);
+      }
+    },
+
     escapeHtml(text) {
       const div = document.createElement("div");
       div.textContent = text;